@@ -8,6 +8,9 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/mixailo/go-training-metrics/internal/service/sender"
 )
 
 type endpoint struct {
@@ -38,6 +41,21 @@ type config struct {
 	pollInterval   int64
 	reportInterval int64
 	logLevel       string
+	batchMode      bool
+	collectors     string
+	diskFSInclude  string
+	diskFSExclude  string
+	rateLimit      int
+	spoolDir       string
+	spoolMaxSize   int64
+	spoolMaxAge    int64
+	retryAttempts  int
+	retryBaseDelay int64 // milliseconds
+	retryMaxDelay  int64 // milliseconds
+	retryJitter    float64
+	agentID        string
+	key            string
+	cryptoKey      string
 }
 
 func (e *endpoint) String() string {
@@ -65,6 +83,15 @@ func defaultConfig() (cfg config) {
 		pollInterval:   2,
 		reportInterval: 10,
 		logLevel:       "info",
+		batchMode:      true,
+		collectors:     "runtime",
+		rateLimit:      1,
+		spoolMaxSize:   10 << 20,
+		spoolMaxAge:    24 * 3600,
+		retryAttempts:  4,
+		retryBaseDelay: 100,
+		retryMaxDelay:  5000,
+		retryJitter:    0.2,
 	}
 	return
 }
@@ -104,11 +131,168 @@ func envConfig(defCfg config) (cfg config) {
 		cfg.logLevel = v
 	}
 
+	v, ok = os.LookupEnv("BATCH_MODE")
+	if ok {
+		cfg.batchMode = v == "true"
+	}
+
+	v, ok = os.LookupEnv("COLLECTORS")
+	if ok {
+		cfg.collectors = v
+	}
+
+	v, ok = os.LookupEnv("RATE_LIMIT")
+	if ok {
+		limit, err := strconv.Atoi(v)
+		if err != nil {
+			log.Fatal(err)
+		}
+		cfg.rateLimit = limit
+	}
+
+	v, ok = os.LookupEnv("SPOOL_DIR")
+	if ok {
+		cfg.spoolDir = v
+	}
+
+	v, ok = os.LookupEnv("SPOOL_MAX_SIZE")
+	if ok {
+		size, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			log.Fatal(err)
+		}
+		cfg.spoolMaxSize = size
+	}
+
+	v, ok = os.LookupEnv("SPOOL_MAX_AGE")
+	if ok {
+		age, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			log.Fatal(err)
+		}
+		cfg.spoolMaxAge = age
+	}
+
+	v, ok = os.LookupEnv("RETRY_ATTEMPTS")
+	if ok {
+		attempts, err := strconv.Atoi(v)
+		if err != nil {
+			log.Fatal(err)
+		}
+		cfg.retryAttempts = attempts
+	}
+
+	v, ok = os.LookupEnv("RETRY_BASE_DELAY")
+	if ok {
+		delay, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			log.Fatal(err)
+		}
+		cfg.retryBaseDelay = delay
+	}
+
+	v, ok = os.LookupEnv("RETRY_MAX_DELAY")
+	if ok {
+		delay, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			log.Fatal(err)
+		}
+		cfg.retryMaxDelay = delay
+	}
+
+	v, ok = os.LookupEnv("RETRY_JITTER")
+	if ok {
+		jitter, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			log.Fatal(err)
+		}
+		cfg.retryJitter = jitter
+	}
+
+	v, ok = os.LookupEnv("AGENT_ID")
+	if ok {
+		cfg.agentID = v
+	}
+
+	v, ok = os.LookupEnv("KEY")
+	if ok {
+		cfg.key = v
+	}
+
+	v, ok = os.LookupEnv("CRYPTO_KEY")
+	if ok {
+		cfg.cryptoKey = v
+	}
+
+	v, ok = os.LookupEnv("DISK_FS_INCLUDE")
+	if ok {
+		cfg.diskFSInclude = v
+	}
+
+	v, ok = os.LookupEnv("DISK_FS_EXCLUDE")
+	if ok {
+		cfg.diskFSExclude = v
+	}
+
 	return cfg
 }
 
+// retryPolicy returns policy of sending requests
+func (c *config) retryPolicy() sender.RetryPolicy {
+	return sender.RetryPolicy{
+		MaxAttempts: c.retryAttempts,
+		BaseDelay:   time.Duration(c.retryBaseDelay) * time.Millisecond,
+		MaxDelay:    time.Duration(c.retryMaxDelay) * time.Millisecond,
+		Jitter:      c.retryJitter,
+	}
+}
+
+// collectorNames returns names of enabled collectors
+func (c *config) collectorNames() []string {
+	return splitList(c.collectors)
+}
+
+// diskFSTypes returns filesystem types to include and exclude, nil exclude means the default ones
+func (c *config) diskFSTypes() (include, exclude []string) {
+	include = splitList(c.diskFSInclude)
+	if c.diskFSExclude != "" {
+		exclude = splitList(c.diskFSExclude)
+	}
+
+	return include, exclude
+}
+
+// splitList splits comma separated values skipping empty ones
+func splitList(list string) []string {
+	values := make([]string, 0)
+	for _, v := range strings.Split(list, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+
+	return values
+}
+
 func initConfig() config {
-	return argsConfig(envConfig(defaultConfig()))
+	cfg := argsConfig(envConfig(defaultConfig()))
+	if cfg.rateLimit < 1 {
+		log.Fatal("rate limit must be a positive number")
+	}
+	if cfg.spoolMaxSize < 0 || cfg.spoolMaxAge < 0 {
+		log.Fatal("spool limits must be positive numbers or zero")
+	}
+	if cfg.retryAttempts < 1 {
+		log.Fatal("retry attempts must be a positive number")
+	}
+	if cfg.retryBaseDelay < 0 || cfg.retryMaxDelay < 0 {
+		log.Fatal("retry delays must be positive numbers or zero")
+	}
+	if cfg.retryJitter < 0 || cfg.retryJitter > 1 {
+		log.Fatal("retry jitter must be between 0 and 1")
+	}
+
+	return cfg
 }
 
 func argsConfig(cfg config) config {
@@ -116,6 +300,21 @@ func argsConfig(cfg config) config {
 	flag.Int64Var(&cfg.pollInterval, "p", cfg.pollInterval, "poll interval")
 	flag.Int64Var(&cfg.reportInterval, "r", cfg.reportInterval, "report interval")
 	flag.StringVar(&cfg.logLevel, "l", cfg.logLevel, "log level [info]")
+	flag.BoolVar(&cfg.batchMode, "b", cfg.batchMode, "send report in a single batch request")
+	flag.StringVar(&cfg.collectors, "collectors", cfg.collectors, "comma separated collectors to poll [runtime,host,disk,net]")
+	flag.IntVar(&cfg.rateLimit, "rate-limit", cfg.rateLimit, "max number of concurrent requests to server")
+	flag.StringVar(&cfg.spoolDir, "spool-dir", cfg.spoolDir, "directory of undelivered reports, they are dropped if empty")
+	flag.Int64Var(&cfg.spoolMaxSize, "spool-max-size", cfg.spoolMaxSize, "max size of undelivered reports in bytes, 0 for no limit")
+	flag.Int64Var(&cfg.spoolMaxAge, "spool-max-age", cfg.spoolMaxAge, "age of undelivered reports in seconds to merge them, 0 for no limit")
+	flag.IntVar(&cfg.retryAttempts, "retry-attempts", cfg.retryAttempts, "max number of attempts to send request")
+	flag.Int64Var(&cfg.retryBaseDelay, "retry-base-delay", cfg.retryBaseDelay, "delay before the first retry in milliseconds, doubled on every retry")
+	flag.Int64Var(&cfg.retryMaxDelay, "retry-max-delay", cfg.retryMaxDelay, "max delay between retries in milliseconds, 0 for no limit")
+	flag.Float64Var(&cfg.retryJitter, "retry-jitter", cfg.retryJitter, "random fraction of retry delay subtracted from it [0..1]")
+	flag.StringVar(&cfg.key, "k", cfg.key, "key of HMAC-SHA256 signatures of requests, requests are not signed if empty")
+	flag.StringVar(&cfg.cryptoKey, "crypto-key", cfg.cryptoKey, "path to PEM encoded RSA public key of server encrypting request bodies, bodies are not encrypted if empty")
+	flag.StringVar(&cfg.agentID, "agent-id", cfg.agentID, "agent id sent with idempotency keys, if empty it is generated and kept in spool dir")
+	flag.StringVar(&cfg.diskFSInclude, "disk-fs-include", cfg.diskFSInclude, "comma separated filesystem types reported by disk collector, all if empty")
+	flag.StringVar(&cfg.diskFSExclude, "disk-fs-exclude", cfg.diskFSExclude, "comma separated filesystem types skipped by disk collector, pseudo filesystems if empty")
 
 	flag.Parse()
 	return cfg
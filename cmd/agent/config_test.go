@@ -121,6 +121,15 @@ func TestEnvConfig(t *testing.T) {
 				reportInterval: 10,
 				pollInterval:   2,
 				logLevel:       "info",
+				batchMode:      true,
+				collectors:     "runtime",
+				rateLimit:      1,
+				spoolMaxSize:   10485760,
+				spoolMaxAge:    86400,
+				retryAttempts:  4,
+				retryBaseDelay: 100,
+				retryMaxDelay:  5000,
+				retryJitter:    0.2,
 			},
 		},
 		{
@@ -139,6 +148,15 @@ func TestEnvConfig(t *testing.T) {
 				reportInterval: 10,
 				pollInterval:   2,
 				logLevel:       "info",
+				batchMode:      true,
+				collectors:     "runtime",
+				rateLimit:      1,
+				spoolMaxSize:   10485760,
+				spoolMaxAge:    86400,
+				retryAttempts:  4,
+				retryBaseDelay: 100,
+				retryMaxDelay:  5000,
+				retryJitter:    0.2,
 			},
 		},
 		{
@@ -157,6 +175,178 @@ func TestEnvConfig(t *testing.T) {
 				reportInterval: 100,
 				pollInterval:   20,
 				logLevel:       "info",
+				batchMode:      true,
+				collectors:     "runtime",
+				rateLimit:      1,
+				spoolMaxSize:   10485760,
+				spoolMaxAge:    86400,
+				retryAttempts:  4,
+				retryBaseDelay: 100,
+				retryMaxDelay:  5000,
+				retryJitter:    0.2,
+			},
+		},
+		{
+			"batch mode disabled",
+			map[string]string{
+				"ADDRESS":    "127.0.0.1:80",
+				"BATCH_MODE": "false",
+			},
+			config{
+				endpoint: endpoint{
+					Host: "127.0.0.1",
+					Port: 80,
+				},
+				reportInterval: 10,
+				pollInterval:   2,
+				logLevel:       "info",
+				batchMode:      false,
+				collectors:     "runtime",
+				rateLimit:      1,
+				spoolMaxSize:   10485760,
+				spoolMaxAge:    86400,
+				retryAttempts:  4,
+				retryBaseDelay: 100,
+				retryMaxDelay:  5000,
+				retryJitter:    0.2,
+			},
+		},
+		{
+			"rate limit",
+			map[string]string{
+				"ADDRESS":    "127.0.0.1:80",
+				"RATE_LIMIT": "4",
+			},
+			config{
+				endpoint: endpoint{
+					Host: "127.0.0.1",
+					Port: 80,
+				},
+				reportInterval: 10,
+				pollInterval:   2,
+				logLevel:       "info",
+				batchMode:      true,
+				collectors:     "runtime",
+				rateLimit:      4,
+				spoolMaxSize:   10485760,
+				spoolMaxAge:    86400,
+				retryAttempts:  4,
+				retryBaseDelay: 100,
+				retryMaxDelay:  5000,
+				retryJitter:    0.2,
+			},
+		},
+		{
+			"spool",
+			map[string]string{
+				"ADDRESS":        "127.0.0.1:80",
+				"SPOOL_DIR":      "spool",
+				"SPOOL_MAX_SIZE": "1024",
+				"SPOOL_MAX_AGE":  "60",
+				"AGENT_ID":       "agent-1",
+			},
+			config{
+				endpoint: endpoint{
+					Host: "127.0.0.1",
+					Port: 80,
+				},
+				reportInterval: 10,
+				pollInterval:   2,
+				logLevel:       "info",
+				batchMode:      true,
+				collectors:     "runtime",
+				rateLimit:      1,
+				spoolDir:       "spool",
+				spoolMaxSize:   1024,
+				spoolMaxAge:    60,
+				retryAttempts:  4,
+				retryBaseDelay: 100,
+				retryMaxDelay:  5000,
+				retryJitter:    0.2,
+				agentID:        "agent-1",
+			},
+		},
+		{
+			"retry",
+			map[string]string{
+				"ADDRESS":          "127.0.0.1:80",
+				"RETRY_ATTEMPTS":   "2",
+				"RETRY_BASE_DELAY": "50",
+				"RETRY_MAX_DELAY":  "0",
+				"RETRY_JITTER":     "0",
+			},
+			config{
+				endpoint: endpoint{
+					Host: "127.0.0.1",
+					Port: 80,
+				},
+				reportInterval: 10,
+				pollInterval:   2,
+				logLevel:       "info",
+				batchMode:      true,
+				collectors:     "runtime",
+				rateLimit:      1,
+				spoolMaxSize:   10485760,
+				spoolMaxAge:    86400,
+				retryAttempts:  2,
+				retryBaseDelay: 50,
+			},
+		},
+		{
+			"keys",
+			map[string]string{
+				"ADDRESS":    "127.0.0.1:80",
+				"KEY":        "secret",
+				"CRYPTO_KEY": "public.pem",
+			},
+			config{
+				endpoint: endpoint{
+					Host: "127.0.0.1",
+					Port: 80,
+				},
+				reportInterval: 10,
+				pollInterval:   2,
+				logLevel:       "info",
+				batchMode:      true,
+				collectors:     "runtime",
+				rateLimit:      1,
+				spoolMaxSize:   10485760,
+				spoolMaxAge:    86400,
+				retryAttempts:  4,
+				retryBaseDelay: 100,
+				retryMaxDelay:  5000,
+				retryJitter:    0.2,
+				key:            "secret",
+				cryptoKey:      "public.pem",
+			},
+		},
+		{
+			"collectors",
+			map[string]string{
+				"ADDRESS":         "127.0.0.1:80",
+				"COLLECTORS":      "runtime,host,disk",
+				"DISK_FS_INCLUDE": "ext4,xfs",
+				"DISK_FS_EXCLUDE": "tmpfs",
+			},
+			config{
+				endpoint: endpoint{
+					Host: "127.0.0.1",
+					Port: 80,
+				},
+				reportInterval: 10,
+				pollInterval:   2,
+				logLevel:       "info",
+				batchMode:      true,
+				collectors:     "runtime,host,disk",
+				rateLimit:      1,
+				spoolMaxSize:   10485760,
+				spoolMaxAge:    86400,
+				retryAttempts:  4,
+				retryBaseDelay: 100,
+				retryMaxDelay:  5000,
+				retryJitter:    0.2,
+				diskFSInclude:  "ext4,xfs",
+				diskFSExclude:  "tmpfs",
 			},
 		},
 	}
@@ -167,6 +357,21 @@ func TestEnvConfig(t *testing.T) {
 			os.Unsetenv("REPORT_INTERVAL")
 			os.Unsetenv("POLL_INTERVAL")
 			os.Unsetenv("LOG_LEVEL")
+			os.Unsetenv("BATCH_MODE")
+			os.Unsetenv("COLLECTORS")
+			os.Unsetenv("RATE_LIMIT")
+			os.Unsetenv("SPOOL_DIR")
+			os.Unsetenv("SPOOL_MAX_SIZE")
+			os.Unsetenv("SPOOL_MAX_AGE")
+			os.Unsetenv("RETRY_ATTEMPTS")
+			os.Unsetenv("RETRY_BASE_DELAY")
+			os.Unsetenv("RETRY_MAX_DELAY")
+			os.Unsetenv("RETRY_JITTER")
+			os.Unsetenv("AGENT_ID")
+			os.Unsetenv("KEY")
+			os.Unsetenv("CRYPTO_KEY")
+			os.Unsetenv("DISK_FS_INCLUDE")
+			os.Unsetenv("DISK_FS_EXCLUDE")
 			for k, v := range tt.args {
 				assert.NoError(t, os.Setenv(k, v))
 			}
@@ -174,3 +379,32 @@ func TestEnvConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestConfig_CollectorNames(t *testing.T) {
+	tests := []struct {
+		collectors string
+		want       []string
+	}{
+		{collectors: "runtime", want: []string{"runtime"}},
+		{collectors: " runtime, host ,", want: []string{"runtime", "host"}},
+		{collectors: "", want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.collectors, func(t *testing.T) {
+			c := config{collectors: tt.collectors}
+			assert.Equal(t, tt.want, c.collectorNames())
+		})
+	}
+}
+
+func TestConfig_DiskFSTypes(t *testing.T) {
+	c := config{}
+	include, exclude := c.diskFSTypes()
+	assert.Empty(t, include)
+	assert.Nil(t, exclude, "default types are excluded")
+
+	c = config{diskFSInclude: "ext4, xfs", diskFSExclude: "tmpfs"}
+	include, exclude = c.diskFSTypes()
+	assert.Equal(t, []string{"ext4", "xfs"}, include)
+	assert.Equal(t, []string{"tmpfs"}, exclude)
+}
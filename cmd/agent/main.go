@@ -1,31 +1,26 @@
 package main
 
 import (
-	"github.com/mixailo/go-training-metrics/internal/service/logger"
-	"log"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"syscall"
 	"time"
 
+	"go.uber.org/zap"
+
+	"github.com/mixailo/go-training-metrics/internal/service/encryption"
+	"github.com/mixailo/go-training-metrics/internal/service/logger"
 	"github.com/mixailo/go-training-metrics/internal/service/metrics"
 	"github.com/mixailo/go-training-metrics/internal/service/poller"
 	"github.com/mixailo/go-training-metrics/internal/service/sender"
 )
 
-var totalPolls int64
-var report metrics.Report
-
-// yet ungraceful
-func gracefulShutdown() {
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-	go func() {
-		<-c
-		log.Println("Shutting down gracefully")
-		os.Exit(0)
-	}()
-}
-
 var agentConf config
 
 func main() {
@@ -34,29 +29,102 @@ func main() {
 		panic(err)
 	}
 	logger.Log.Info("agent start")
-	gracefulShutdown()
 
-	lastPoll := time.Now()
-	lastReport := lastPoll
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	registry := poller.DefaultRegistry()
+	include, exclude := agentConf.diskFSTypes()
+	registry.Register(poller.NewDiskCollector("/proc", include, exclude))
+	if err := registry.Enable(agentConf.collectorNames()...); err != nil {
+		logger.Log.Fatal("invalid collectors", zap.Error(err), zap.Strings("known", registry.Names()))
+	}
 
+	sender.SetRetryPolicy(agentConf.retryPolicy())
+	id := agentConf.agentID
+	if id == "" {
+		var err error
+		if id, err = spooledAgentID(agentConf.spoolDir); err != nil {
+			logger.Log.Fatal("cannot keep agent id", zap.Error(err), zap.String("dir", agentConf.spoolDir))
+		}
+	}
+	sender.SetAgentID(id)
+	sender.SetSignKey(agentConf.key)
+	if agentConf.cryptoKey != "" {
+		pub, err := encryption.LoadPublicKey(agentConf.cryptoKey)
+		if err != nil {
+			logger.Log.Fatal("cannot load public key", zap.Error(err), zap.String("path", agentConf.cryptoKey))
+		}
+		sender.SetPublicKey(pub)
+	}
+	logger.Log.Info("agent id", zap.String("id", id))
 	reportEndpoint := sender.NewServerEndpoint("http", agentConf.endpoint.Host, agentConf.endpoint.Port)
-	for {
-		time.Sleep(100 * time.Millisecond)
-		currentTime := time.Now()
-		if (currentTime.Sub(lastPoll).Milliseconds()) >= agentConf.pollInterval*1000 {
-			report = poller.PollMetrics()
-			totalPolls += 1
-			lastPoll = currentTime
+	send := func(report metrics.Report) error {
+		if agentConf.batchMode {
+			return sender.SendBatchReport(report, reportEndpoint)
 		}
-		if currentTime.Sub(lastReport).Milliseconds() >= agentConf.reportInterval*1000 {
-			report.Add(metrics.Metrics{ID: "PollCount", MType: metrics.TypeCounter.String(), Delta: &totalPolls})
-			err := sender.SendReport(report, reportEndpoint)
-			if err != nil {
-				log.Print(err.Error())
-			}
-			lastReport = currentTime
-			totalPolls = 0
+		return sender.SendReport(report, reportEndpoint)
+	}
+	if agentConf.spoolDir != "" {
+		spool, err := sender.NewSpool(agentConf.spoolDir, agentConf.spoolMaxSize, time.Duration(agentConf.spoolMaxAge)*time.Second)
+		if err != nil {
+			logger.Log.Fatal("cannot open spool", zap.Error(err), zap.String("dir", agentConf.spoolDir))
 		}
+		logger.Log.Info("undelivered reports are spooled", zap.String("dir", agentConf.spoolDir), zap.Int("spooled", spool.Len()))
+		direct := send
+		send = func(report metrics.Report) error {
+			return spool.Send(report, direct)
+		}
+	}
+
+	p := pipeline{
+		collectors:     registry.Enabled(),
+		pollInterval:   time.Duration(agentConf.pollInterval) * time.Second,
+		reportInterval: time.Duration(agentConf.reportInterval) * time.Second,
+		workers:        agentConf.rateLimit,
+		send:           send,
+	}
+	p.run(ctx)
+
+	logger.Log.Info("shutting down gracefully")
+}
+
+// agentIDFile keeps generated agent id in spool dir, it is not a spool file
+const agentIDFile = "agent.id"
 
+// spooledAgentID returns agent id kept in spool dir, it is generated and saved on the first start,
+// so reports spooled before restart are sent with the same idempotency keys. Id is not kept without spool.
+func spooledAgentID(dir string) (string, error) {
+	if dir == "" {
+		return randomAgentID()
 	}
+
+	path := filepath.Join(dir, agentIDFile)
+	data, err := os.ReadFile(path)
+	if err == nil && len(bytes.TrimSpace(data)) > 0 {
+		return string(bytes.TrimSpace(data)), nil
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+
+	id, err := randomAgentID()
+	if err != nil {
+		return "", err
+	}
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	return id, os.WriteFile(path, []byte(id+"\n"), 0644)
+}
+
+// randomAgentID returns id of agent which is unique with high probability
+func randomAgentID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
 }
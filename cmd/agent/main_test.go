@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_spooledAgentID(t *testing.T) {
+	t.Run("kept between starts", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "spool")
+
+		first, err := spooledAgentID(dir)
+		require.NoError(t, err)
+		require.NotEmpty(t, first)
+
+		second, err := spooledAgentID(dir)
+		require.NoError(t, err)
+		assert.Equal(t, first, second)
+	})
+
+	t.Run("saved id", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, agentIDFile), []byte("agent-1\n"), 0644))
+
+		id, err := spooledAgentID(dir)
+		require.NoError(t, err)
+		assert.Equal(t, "agent-1", id)
+	})
+
+	t.Run("without spool", func(t *testing.T) {
+		first, err := spooledAgentID("")
+		require.NoError(t, err)
+		second, err := spooledAgentID("")
+		require.NoError(t, err)
+		assert.NotEqual(t, first, second)
+	})
+}
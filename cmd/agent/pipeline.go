@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mixailo/go-training-metrics/internal/service/logger"
+	"github.com/mixailo/go-training-metrics/internal/service/metrics"
+	"github.com/mixailo/go-training-metrics/internal/service/poller"
+)
+
+// pipeline polls every collector on its own ticker, aggregates polled metrics into report
+// and sends reports by a pool of workers, so slow server does not delay polling
+type pipeline struct {
+	collectors     []poller.Collector
+	pollInterval   time.Duration
+	reportInterval time.Duration
+	workers        int // max number of reports sent concurrently
+	send           func(report metrics.Report) error
+}
+
+// run blocks until ctx is done, report aggregated by then is sent before it returns
+func (p *pipeline) run(ctx context.Context) {
+	polled := make(chan metrics.Report)
+	// report is handed over only to an idle worker
+	jobs := make(chan metrics.Report)
+
+	var pollers sync.WaitGroup
+	for _, c := range p.collectors {
+		pollers.Add(1)
+		go func(c poller.Collector) {
+			defer pollers.Done()
+			p.poll(ctx, c, polled)
+		}(c)
+	}
+	go func() {
+		pollers.Wait()
+		close(polled)
+	}()
+
+	var workers sync.WaitGroup
+	for i := 0; i < p.workers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for report := range jobs {
+				if err := p.send(report); err != nil {
+					logger.Log.Error("send report error", zap.Error(err))
+				}
+			}
+		}()
+	}
+
+	p.aggregate(polled, jobs)
+	workers.Wait()
+}
+
+func (p *pipeline) poll(ctx context.Context, c poller.Collector, polled chan<- metrics.Report) {
+	ticker := time.NewTicker(p.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		report := metrics.NewReport()
+		if err := c.Collect(&report); err != nil {
+			logger.Log.Error("poll error", zap.String("collector", c.Name()), zap.Error(err))
+		}
+		// aggregator reads polled until every poller is stopped, so collected metrics are not lost
+		polled <- report
+	}
+}
+
+// aggregate merges polled reports until polled is closed and passes them to workers every report interval
+func (p *pipeline) aggregate(polled <-chan metrics.Report, jobs chan<- metrics.Report) {
+	defer close(jobs)
+
+	ticker := time.NewTicker(p.reportInterval)
+	defer ticker.Stop()
+
+	report := metrics.NewReport()
+	for {
+		select {
+		case r, ok := <-polled:
+			if !ok {
+				if report.Length() > 0 {
+					jobs <- report
+				}
+				return
+			}
+			// counters are deltas since the previous poll, so they are summed until report is sent
+			report.Merge(r)
+		case <-ticker.C:
+			if report.Length() == 0 {
+				continue
+			}
+			select {
+			case jobs <- report:
+				report = metrics.NewReport()
+			default:
+				// polled metrics are kept to be sent with the next report
+				logger.Log.Warn("all senders are busy, report is postponed", zap.Int("workers", p.workers))
+			}
+		}
+	}
+}
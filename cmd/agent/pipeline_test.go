@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mixailo/go-training-metrics/internal/service/metrics"
+	"github.com/mixailo/go-training-metrics/internal/service/poller"
+)
+
+type countingCollector struct {
+	name  string
+	polls atomic.Int64
+}
+
+func (c *countingCollector) Name() string {
+	return c.name
+}
+
+func (c *countingCollector) Collect(report *metrics.Report) error {
+	c.polls.Add(1)
+	report.AddUnConverted(metrics.TypeCounter, c.name+"Polls", "1")
+	report.AddUnConverted(metrics.TypeGauge, c.name+"Value", "1")
+	return nil
+}
+
+// sentDeltas sums counter deltas of sent reports
+type sentDeltas struct {
+	mu     sync.Mutex
+	deltas map[string]int64
+}
+
+func (s *sentDeltas) add(report metrics.Report) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, m := range report.All() {
+		if m.Delta != nil {
+			s.deltas[m.ID] += *m.Delta
+		}
+	}
+}
+
+func TestPipeline_Run(t *testing.T) {
+	tests := []struct {
+		name      string
+		workers   int
+		sendDelay time.Duration
+	}{
+		{name: "fast server", workers: 1},
+		{name: "slow server", workers: 1, sendDelay: 30 * time.Millisecond},
+		{name: "slow server with concurrent senders", workers: 3, sendDelay: 30 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := &countingCollector{name: "a"}, &countingCollector{name: "b"}
+			sent := &sentDeltas{deltas: make(map[string]int64)}
+			var inFlight, maxInFlight atomic.Int64
+
+			p := pipeline{
+				collectors:     []poller.Collector{a, b},
+				pollInterval:   2 * time.Millisecond,
+				reportInterval: 5 * time.Millisecond,
+				workers:        tt.workers,
+				send: func(report metrics.Report) error {
+					n := inFlight.Add(1)
+					defer inFlight.Add(-1)
+					for {
+						max := maxInFlight.Load()
+						if n <= max || maxInFlight.CompareAndSwap(max, n) {
+							break
+						}
+					}
+					time.Sleep(tt.sendDelay)
+					sent.add(report)
+					return nil
+				},
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 150*time.Millisecond)
+			defer cancel()
+			done := make(chan struct{})
+			go func() {
+				p.run(ctx)
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("pipeline is not stopped")
+			}
+
+			assert.Greater(t, a.polls.Load(), int64(10), "polling is not blocked by sending")
+			assert.Equal(t, a.polls.Load(), sent.deltas["aPolls"], "polled deltas are lost")
+			assert.Equal(t, b.polls.Load(), sent.deltas["bPolls"], "polled deltas are lost")
+			assert.LessOrEqual(t, maxInFlight.Load(), int64(tt.workers))
+		})
+	}
+}
@@ -0,0 +1,43 @@
+// keygen writes RSA key pair for encryption of agent requests:
+// the server is started with -crypto-key of private key and agents with -crypto-key of public one
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/mixailo/go-training-metrics/internal/service/encryption"
+)
+
+func main() {
+	bits := flag.Int("bits", 4096, "RSA key size in bits")
+	privPath := flag.String("private", "private.pem", "path to write private key to")
+	pubPath := flag.String("public", "public.pem", "path to write public key to")
+	flag.Parse()
+
+	if err := generate(*bits, *privPath, *pubPath); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("keys are written to %s and %s", *privPath, *pubPath)
+}
+
+// generate writes PEM encoded private and public keys, private key is readable only by owner
+func generate(bits int, privPath, pubPath string) error {
+	priv, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		return err
+	}
+	pub, err := encryption.EncodePublicKey(&priv.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	if err = os.WriteFile(privPath, encryption.EncodePrivateKey(priv), 0600); err != nil {
+		return err
+	}
+
+	return os.WriteFile(pubPath, pub, 0644)
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mixailo/go-training-metrics/internal/service/encryption"
+)
+
+func Test_generate(t *testing.T) {
+	dir := t.TempDir()
+	privPath, pubPath := filepath.Join(dir, "private.pem"), filepath.Join(dir, "public.pem")
+	require.NoError(t, generate(2048, privPath, pubPath))
+
+	priv, err := encryption.LoadPrivateKey(privPath)
+	require.NoError(t, err)
+	pub, err := encryption.LoadPublicKey(pubPath)
+	require.NoError(t, err)
+	assert.True(t, priv.PublicKey.Equal(pub))
+	assert.Equal(t, 2048, pub.N.BitLen())
+}
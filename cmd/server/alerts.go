@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mixailo/go-training-metrics/internal/service/alerting"
+	"github.com/mixailo/go-training-metrics/internal/service/logger"
+	"github.com/mixailo/go-training-metrics/internal/service/notifier"
+)
+
+type alertsResponse struct {
+	Alerts []alerting.Alert `json:"alerts"`
+}
+
+// enableAlerting creates rules engine evaluating rules against the storage
+func (sa *storageAware) enableAlerting(rules []alerting.Rule) *alerting.Engine {
+	sa.alerts = alerting.NewEngine(rules, sa.stor)
+	return sa.alerts
+}
+
+// newNotifier creates notifier for configured webhooks, nil if there are none
+func newNotifier(c *config) *notifier.Notifier {
+	urls := splitList(c.alertWebhooks)
+	if len(urls) == 0 {
+		return nil
+	}
+
+	return notifier.New(notifier.Config{
+		URLs:           urls,
+		GroupBy:        splitList(c.alertGroupBy),
+		RepeatInterval: time.Duration(c.alertRepeatInterval) * time.Second,
+		MaxAttempts:    3,
+		Backoff:        time.Second,
+		Timeout:        5 * time.Second,
+	})
+}
+
+// listAlerts returns pending and firing alerts
+func (sa *storageAware) listAlerts(w http.ResponseWriter, r *http.Request) {
+	res := alertsResponse{Alerts: make([]alerting.Alert, 0)}
+	if sa.alerts != nil {
+		res.Alerts = sa.alerts.Alerts()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(res)
+}
+
+// notificationQueue passes evaluated alerts to notifier in background, so slow webhooks do not delay evaluation.
+// Alerts evaluated while notifier is busy are coalesced, the latest state of every rule wins, so the queue
+// never keeps more alerts than there are rules.
+type notificationQueue struct {
+	mu      sync.Mutex
+	pending map[string]alerting.Alert
+	ready   chan struct{}
+}
+
+func newNotificationQueue() *notificationQueue {
+	return &notificationQueue{
+		pending: make(map[string]alerting.Alert),
+		ready:   make(chan struct{}, 1),
+	}
+}
+
+// push queues alerts without waiting for notifier
+func (q *notificationQueue) push(alerts []alerting.Alert) {
+	q.mu.Lock()
+	for _, a := range alerts {
+		q.pending[a.Rule] = a
+	}
+	q.mu.Unlock()
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+		// notifier is signalled already
+	}
+}
+
+// take returns queued alerts and empties the queue
+func (q *notificationQueue) take() []alerting.Alert {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	alerts := make([]alerting.Alert, 0, len(q.pending))
+	for _, a := range q.pending {
+		alerts = append(alerts, a)
+	}
+	clear(q.pending)
+
+	return alerts
+}
+
+// run delivers queued alerts until ctx is done, which cancels the delivery in progress as well
+func (q *notificationQueue) run(ctx context.Context, n *notifier.Notifier) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.ready:
+			if err := n.Notify(ctx, q.take()); err != nil {
+				logger.Log.Error("alert notification error", zap.Error(err))
+			}
+		}
+	}
+}
+
+// alertsEvaluated logs changed alerts and queues active and resolved ones for notifier, if any
+func alertsEvaluated(engine *alerting.Engine, q *notificationQueue) func(changed []alerting.Alert) {
+	return func(changed []alerting.Alert) {
+		for _, a := range changed {
+			logger.Log.Info("alert state changed", zap.String("rule", a.Rule), zap.String("state", string(a.State)), zap.Float64("value", a.Value))
+		}
+		if q == nil {
+			return
+		}
+
+		q.push(append(engine.Alerts(), changed...))
+	}
+}
+
+// startAlerting evaluates rules in background and delivers notifications apart from evaluation until ctx is done
+func startAlerting(ctx context.Context, engine *alerting.Engine, interval time.Duration, n *notifier.Notifier) {
+	var q *notificationQueue
+	if n != nil {
+		q = newNotificationQueue()
+		go q.run(ctx, n)
+	}
+
+	go engine.Run(ctx, interval, alertsEvaluated(engine, q))
+}
+
+// splitList splits comma separated config value skipping empty items
+func splitList(value string) []string {
+	result := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mixailo/go-training-metrics/internal/repository/storage"
+	"github.com/mixailo/go-training-metrics/internal/service/alerting"
+	"github.com/mixailo/go-training-metrics/internal/service/notifier"
+)
+
+// slowWebhook holds every notification until it is released
+type slowWebhook struct {
+	arrived  chan struct{}
+	release  chan struct{}
+	received chan notifier.Notification
+}
+
+func newSlowWebhook() *slowWebhook {
+	return &slowWebhook{
+		arrived:  make(chan struct{}, 10),
+		release:  make(chan struct{}),
+		received: make(chan notifier.Notification, 10),
+	}
+}
+
+func (wh *slowWebhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// body is read before waiting, so the server notices a client which is gone
+	var n notifier.Notification
+	if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	wh.arrived <- struct{}{}
+	select {
+	case <-wh.release:
+		wh.received <- n
+	case <-r.Context().Done():
+	}
+}
+
+func wait[T any](t *testing.T, ch <-chan T) T {
+	t.Helper()
+
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "timeout")
+	}
+
+	var zero T
+	return zero
+}
+
+func Test_alertsEvaluated(t *testing.T) {
+	wh := newSlowWebhook()
+	hook := httptest.NewServer(wh)
+	defer hook.Close()
+
+	sa := newStorageAware(storage.NewShardedStorage())
+	engine := sa.enableAlerting([]alerting.Rule{
+		{Name: "HighAlloc", Metric: "Alloc", Type: "gauge", Op: ">", Threshold: 100},
+	})
+	q := newNotificationQueue()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.run(ctx, notifier.New(notifier.Config{URLs: []string{hook.URL}, RepeatInterval: time.Hour, Timeout: 10 * time.Second}))
+	evaluated := alertsEvaluated(engine, q)
+
+	sa.stor.UpdateGauge("Alloc", 200)
+	evaluated(engine.Evaluate(time.Now()))
+	wait(t, wh.arrived)
+
+	// evaluation goes on while webhook is waited for
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sa.stor.UpdateGauge("Alloc", 50)
+		evaluated(engine.Evaluate(time.Now()))
+	}()
+	wait(t, done)
+	assert.Empty(t, engine.Alerts())
+
+	close(wh.release)
+	assert.Equal(t, "firing", wait(t, wh.received).Status)
+	assert.Equal(t, "resolved", wait(t, wh.received).Status)
+}
+
+func Test_notificationQueue_cancel(t *testing.T) {
+	wh := newSlowWebhook()
+	hook := httptest.NewServer(wh)
+	defer hook.Close()
+
+	q := newNotificationQueue()
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		q.run(ctx, notifier.New(notifier.Config{URLs: []string{hook.URL}, RepeatInterval: time.Hour, Timeout: time.Minute}))
+	}()
+
+	q.push([]alerting.Alert{{Rule: "A", State: alerting.StateFiring}})
+	wait(t, wh.arrived)
+
+	// delivery in progress is abandoned
+	cancel()
+	wait(t, stopped)
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+
+	"github.com/mixailo/go-training-metrics/internal/repository/snapshot"
+	"github.com/mixailo/go-training-metrics/internal/service/logger"
+	"github.com/mixailo/go-training-metrics/internal/service/signature"
+)
+
+type backupsResponse struct {
+	Backups []snapshot.Backup `json:"backups"`
+}
+
+// enableBackups makes storage saved to timestamped snapshots, restored is called after storage is replaced by backup
+func (sa *storageAware) enableBackups(b *snapshot.Backups, restored func() error) {
+	sa.backups = b
+	sa.restored = restored
+}
+
+// adminPathPrefix is a prefix of admin API routes
+const adminPathPrefix = "/api/v1/admin/"
+
+// adminRequestMaxAge limits difference between time admin request was signed at and time it is received
+const adminRequestMaxAge = time.Minute
+
+// adminRequests remembers signatures of accepted admin requests until they expire, so none is accepted twice
+type adminRequests struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+// accept remembers signature, it returns false if the signature was accepted already
+func (ar *adminRequests) accept(hash string, now time.Time) bool {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+
+	for h, at := range ar.seen {
+		if now.Sub(at) > 2*adminRequestMaxAge {
+			delete(ar.seen, h)
+		}
+	}
+	if _, ok := ar.seen[hash]; ok {
+		return false
+	}
+	ar.seen[hash] = now
+
+	return true
+}
+
+// enableAdmin makes admin API available, its requests must be signed
+func (sa *storageAware) enableAdmin() {
+	sa.admin = &adminRequests{seen: make(map[string]time.Time)}
+}
+
+// adminOnly passes requests to admin API only if it is enabled and the request is signed along with its method,
+// path and time, so that a captured request cannot be sent to another route, later or once more.
+// Body, if any, is verified by signed.
+func (sa *storageAware) adminOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if sa.admin == nil || sa.signKey == nil {
+			http.Error(w, "admin API is disabled", http.StatusNotFound)
+			return
+		}
+
+		now := time.Now()
+		hash, timestamp := r.Header.Get(signature.RequestHeader), r.Header.Get(signature.TimestampHeader)
+		signedAt, err := time.Parse(time.RFC3339Nano, timestamp)
+		if err != nil || now.Sub(signedAt).Abs() > adminRequestMaxAge {
+			logger.Log.Warn("admin request is not signed or stale", zap.String("uri", r.RequestURI), zap.String("remote", r.RemoteAddr))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if !signature.Verify(sa.signKey, signature.RequestData(r.Method, r.URL.Path, timestamp), hash) {
+			logger.Log.Warn("admin request signature mismatch", zap.String("uri", r.RequestURI), zap.String("remote", r.RemoteAddr))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if !sa.admin.accept(hash, now) {
+			logger.Log.Warn("admin request is replayed", zap.String("uri", r.RequestURI), zap.String("remote", r.RemoteAddr))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// backup saves snapshot of storage as a new backup
+func (sa *storageAware) backup() (snapshot.Backup, error) {
+	data, err := sa.snapshotData(sa.stor)
+	if err != nil {
+		return snapshot.Backup{}, err
+	}
+
+	return sa.backups.Save(data)
+}
+
+// replace loads storage from snapshot at path dropping current data.
+// With write-ahead log enabled no update is logged until the data is replaced.
+func (sa *storageAware) replace(path string) (snapshot.Header, error) {
+	header, data, err := snapshot.Read(path)
+	if err != nil {
+		return header, err
+	}
+
+	if sa.wal != nil {
+		sa.wal.mu.Lock()
+		defer sa.wal.mu.Unlock()
+	}
+
+	return header, sa.load(data)
+}
+
+// backupPath resolves name of backup, other values are treated as snapshot paths
+func (sa *storageAware) backupPath(name string) string {
+	if sa.backups == nil {
+		return name
+	}
+	path, err := sa.backups.Path(name)
+	if err != nil {
+		return name
+	}
+
+	return path
+}
+
+func backupsTicker(c *config) {
+	ticker := time.NewTicker(time.Duration(c.backupInterval) * time.Second)
+	defer ticker.Stop()
+
+	logger.Log.Debug("init backups ticker", zap.Int64("interval", c.backupInterval))
+
+	for range ticker.C {
+		backup, err := sa.backup()
+		if err != nil {
+			logger.Log.Error("backup error", zap.Error(err), zap.String("dir", c.backupDir))
+		} else {
+			logger.Log.Info("backup saved", zap.String("name", backup.Name))
+		}
+	}
+}
+
+// listBackups returns kept backups, the latest first
+func (sa *storageAware) listBackups(w http.ResponseWriter, r *http.Request) {
+	if sa.backups == nil {
+		http.Error(w, "backups are disabled", http.StatusNotFound)
+		return
+	}
+
+	backups, err := sa.backups.List()
+	if err != nil {
+		logger.Log.Error("cannot list backups", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(backupsResponse{Backups: backups})
+}
+
+// createBackup saves backup immediately
+func (sa *storageAware) createBackup(w http.ResponseWriter, r *http.Request) {
+	if sa.backups == nil {
+		http.Error(w, "backups are disabled", http.StatusNotFound)
+		return
+	}
+
+	backup, err := sa.backup()
+	if err != nil {
+		logger.Log.Error("cannot save backup", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(backup)
+}
+
+// restoreBackup replaces storage data by the backup and persists it at once
+func (sa *storageAware) restoreBackup(w http.ResponseWriter, r *http.Request) {
+	if sa.backups == nil {
+		http.Error(w, "backups are disabled", http.StatusNotFound)
+		return
+	}
+
+	path, err := sa.backups.Path(chi.URLParam(r, "name"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	header, err := sa.replace(path)
+	if errors.Is(err, snapshot.ErrFormat) || errors.Is(err, snapshot.ErrVersion) || errors.Is(err, snapshot.ErrChecksum) || errors.Is(err, snapshot.ErrCounts) {
+		// storage is left intact as the backup is verified before it is loaded
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		logger.Log.Error("cannot restore backup", zap.Error(err), zap.String("path", path))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	logger.Log.Info("storage restored from backup", zap.String("path", path))
+
+	if sa.restored != nil {
+		if err = sa.restored(); err != nil {
+			logger.Log.Error("cannot persist restored storage", zap.Error(err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(header)
+}
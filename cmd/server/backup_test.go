@@ -0,0 +1,232 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mixailo/go-training-metrics/internal/repository/snapshot"
+	"github.com/mixailo/go-training-metrics/internal/repository/storage"
+	"github.com/mixailo/go-training-metrics/internal/service/signature"
+)
+
+var adminKey = []byte("secret")
+
+// signAdmin signs admin request and its empty body with key as if it was signed at signedAt
+func signAdmin(req *http.Request, key []byte, signedAt time.Time) {
+	timestamp := signedAt.UTC().Format(time.RFC3339Nano)
+	req.Header.Set(signature.Header, signature.Sign(key, nil))
+	req.Header.Set(signature.TimestampHeader, timestamp)
+	req.Header.Set(signature.RequestHeader, signature.Sign(key, signature.RequestData(req.Method, req.URL.Path, timestamp)))
+}
+
+// adminRequest sends request to admin API signed with key, it is not signed if key is nil
+func adminRequest(t *testing.T, method, url string, key []byte) *http.Response {
+	req, err := http.NewRequest(method, url, nil)
+	require.NoError(t, err)
+	if key != nil {
+		signAdmin(req, key, time.Now())
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+
+	return resp
+}
+
+func Test_storageAware_backups(t *testing.T) {
+	dir := t.TempDir()
+	backups, err := snapshot.NewBackups(dir, 0, 0)
+	require.NoError(t, err)
+
+	sa := newStorageAware(storage.NewShardedStorage())
+	sa.enableSigning(adminKey)
+	sa.enableAdmin()
+	restored := 0
+	sa.enableBackups(backups, func() error {
+		restored++
+		return nil
+	})
+	server := httptest.NewServer(newMux(sa))
+	defer server.Close()
+
+	sa.stor.UpdateCounter("c", 3)
+	resp := adminRequest(t, http.MethodPost, server.URL+"/api/v1/admin/backups", adminKey)
+	var backup snapshot.Backup
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&backup))
+	resp.Body.Close()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	resp = adminRequest(t, http.MethodGet, server.URL+"/api/v1/admin/backups", adminKey)
+	var list backupsResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&list))
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Len(t, list.Backups, 1)
+	assert.Equal(t, backup.Name, list.Backups[0].Name)
+
+	corrupt := "snapshot-20201001T120000.000000000Z.json"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, corrupt), []byte(`{"version":1,"data":{}`), 0666))
+
+	sa.stor.UpdateCounter("c", 4)
+	tests := []struct {
+		name       string
+		backup     string
+		wantStatus int
+		wantValue  int64
+	}{
+		{name: "unknown backup", backup: "snapshot-20201001T130000.000000000Z.json", wantStatus: http.StatusNotFound, wantValue: 7},
+		{name: "corrupt backup", backup: corrupt, wantStatus: http.StatusUnprocessableEntity, wantValue: 7},
+		{name: "restored", backup: backup.Name, wantStatus: http.StatusOK, wantValue: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := adminRequest(t, http.MethodPost, server.URL+"/api/v1/admin/backups/"+tt.backup+"/restore", adminKey)
+			resp.Body.Close()
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+
+			counter, _ := sa.stor.GetCounter("c")
+			assert.Equal(t, tt.wantValue, counter)
+		})
+	}
+	assert.Equal(t, 1, restored, "restored storage is persisted once")
+}
+
+func Test_storageAware_backupsDisabled(t *testing.T) {
+	sa := newStorageAware(storage.NewShardedStorage())
+	sa.enableSigning(adminKey)
+	sa.enableAdmin()
+	server := httptest.NewServer(newMux(sa))
+	defer server.Close()
+
+	resp := adminRequest(t, http.MethodGet, server.URL+"/api/v1/admin/backups", adminKey)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func Test_storageAware_adminOnly(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	const path = "/api/v1/admin/backups"
+
+	tests := []struct {
+		name       string
+		admin      bool
+		signKey    []byte
+		decrypting bool
+		method     string
+		sign       func(req *http.Request)
+		wantStatus int
+	}{
+		{
+			name: "signed list", admin: true, signKey: adminKey, method: http.MethodGet,
+			sign:       func(req *http.Request) { signAdmin(req, adminKey, time.Now()) },
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "signed backup with decryption", admin: true, signKey: adminKey, decrypting: true, method: http.MethodPost,
+			sign:       func(req *http.Request) { signAdmin(req, adminKey, time.Now()) },
+			wantStatus: http.StatusCreated,
+		},
+		{
+			name: "admin API disabled", signKey: adminKey, method: http.MethodGet,
+			sign:       func(req *http.Request) { signAdmin(req, adminKey, time.Now()) },
+			wantStatus: http.StatusNotFound,
+		},
+		{name: "no signing key", admin: true, method: http.MethodPost, sign: func(*http.Request) {}, wantStatus: http.StatusNotFound},
+		{name: "unsigned list", admin: true, signKey: adminKey, method: http.MethodGet, sign: func(*http.Request) {}, wantStatus: http.StatusUnauthorized},
+		{
+			name: "body signature only", admin: true, signKey: adminKey, method: http.MethodPost,
+			sign:       func(req *http.Request) { req.Header.Set(signature.Header, signature.Sign(adminKey, nil)) },
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "signed with other key", admin: true, signKey: adminKey, method: http.MethodGet,
+			sign: func(req *http.Request) {
+				signAdmin(req, []byte("other"), time.Now())
+				req.Header.Set(signature.Header, signature.Sign(adminKey, nil))
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "stale", admin: true, signKey: adminKey, method: http.MethodPost,
+			sign:       func(req *http.Request) { signAdmin(req, adminKey, time.Now().Add(-2*adminRequestMaxAge)) },
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "signed for other route", admin: true, signKey: adminKey, method: http.MethodPost,
+			sign: func(req *http.Request) {
+				signAdmin(req, adminKey, time.Now())
+				timestamp := req.Header.Get(signature.TimestampHeader)
+				req.Header.Set(signature.RequestHeader, signature.Sign(adminKey, signature.RequestData(http.MethodGet, path, timestamp)))
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backups, err := snapshot.NewBackups(t.TempDir(), 0, 0)
+			require.NoError(t, err)
+			sa := newStorageAware(storage.NewShardedStorage())
+			sa.enableBackups(backups, nil)
+			if tt.signKey != nil {
+				sa.enableSigning(tt.signKey)
+			}
+			if tt.admin {
+				sa.enableAdmin()
+			}
+			if tt.decrypting {
+				sa.enableDecryption(priv)
+			}
+			server := httptest.NewServer(newMux(sa))
+			defer server.Close()
+
+			req, err := http.NewRequest(tt.method, server.URL+path, nil)
+			require.NoError(t, err)
+			tt.sign(req)
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+
+			if tt.wantStatus >= http.StatusBadRequest {
+				list, err := backups.List()
+				require.NoError(t, err)
+				assert.Empty(t, list, "backup is not created by rejected request")
+			}
+		})
+	}
+}
+
+func Test_storageAware_adminReplay(t *testing.T) {
+	backups, err := snapshot.NewBackups(t.TempDir(), 0, 0)
+	require.NoError(t, err)
+	sa := newStorageAware(storage.NewShardedStorage())
+	sa.enableBackups(backups, nil)
+	sa.enableSigning(adminKey)
+	sa.enableAdmin()
+	server := httptest.NewServer(newMux(sa))
+	defer server.Close()
+
+	req, err := http.NewRequest(http.MethodPost, server.URL+"/api/v1/admin/backups", nil)
+	require.NoError(t, err)
+	signAdmin(req, adminKey, time.Now())
+	for _, want := range []int{http.StatusCreated, http.StatusUnauthorized} {
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, want, resp.StatusCode)
+	}
+
+	list, err := backups.List()
+	require.NoError(t, err)
+	assert.Len(t, list, 1, "replayed request is not applied")
+}
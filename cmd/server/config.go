@@ -8,6 +8,8 @@ import (
 	"os"
 	"strconv"
 	"strings"
+
+	"github.com/mixailo/go-training-metrics/internal/repository/wal"
 )
 
 type endpoint struct {
@@ -21,6 +23,35 @@ type config struct {
 	storeInterval   int64
 	fileStoragePath string
 	doRestoreValues bool
+	databaseDSN     string
+	dbMaxOpenConns  int
+	dbMaxIdleConns  int
+	dbConnLifetime  int64
+	historySize     int
+	historyMaxAge   int64
+	alertRulesPath  string
+	alertInterval   int64
+
+	alertWebhooks       string
+	alertGroupBy        string
+	alertRepeatInterval int64
+
+	walDir          string
+	walSync         string
+	walSyncInterval int64
+
+	backupDir      string
+	backupInterval int64
+	backupKeep     int
+	backupMaxAge   int64
+	restoreFrom    string
+	adminAPI       bool
+
+	idempotencyKeys   int
+	idempotencyAgents int
+
+	key       string
+	cryptoKey string
 }
 
 func (e *endpoint) String() string {
@@ -61,6 +92,41 @@ func (c *config) validate() error {
 	if c.storeInterval < 0 {
 		return errors.New("store interval must be a positive number or zero")
 	}
+	if c.dbMaxOpenConns < 0 || c.dbMaxIdleConns < 0 || c.dbConnLifetime < 0 {
+		return errors.New("database pool settings must be positive numbers or zero")
+	}
+	if c.historySize < 0 || c.historyMaxAge < 0 {
+		return errors.New("history size and age must be positive numbers or zero")
+	}
+	if c.alertInterval <= 0 {
+		return errors.New("alert evaluation interval must be a positive number")
+	}
+	if c.alertRepeatInterval < 0 {
+		return errors.New("alert repeat interval must be a positive number or zero")
+	}
+	if c.walDir != "" {
+		if _, err := wal.ParseSyncPolicy(c.walSync); err != nil {
+			return err
+		}
+		if c.walSyncInterval <= 0 {
+			return errors.New("write-ahead log sync interval must be a positive number")
+		}
+		if c.storeInterval == 0 {
+			return errors.New("write-ahead log is compacted every store interval, it must be a positive number")
+		}
+	}
+	if c.backupDir != "" && c.backupInterval <= 0 {
+		return errors.New("backup interval must be a positive number")
+	}
+	if c.backupKeep < 0 || c.backupMaxAge < 0 {
+		return errors.New("backup retention settings must be positive numbers or zero")
+	}
+	if c.adminAPI && c.key == "" {
+		return errors.New("admin API requires signing key, its requests must be signed")
+	}
+	if c.idempotencyKeys < 0 || c.idempotencyAgents < 0 {
+		return errors.New("idempotency cache limits must be positive numbers or zero")
+	}
 
 	return nil
 }
@@ -112,6 +178,165 @@ func envConfig(defCfg config) config {
 		cfg.doRestoreValues = v == "true"
 	}
 
+	v, ok = os.LookupEnv("DATABASE_DSN")
+	if ok {
+		cfg.databaseDSN = v
+	}
+
+	v, ok = os.LookupEnv("DB_MAX_OPEN_CONNS")
+	if ok {
+		vv, err := strconv.Atoi(v)
+		if err == nil {
+			cfg.dbMaxOpenConns = vv
+		}
+	}
+
+	v, ok = os.LookupEnv("DB_MAX_IDLE_CONNS")
+	if ok {
+		vv, err := strconv.Atoi(v)
+		if err == nil {
+			cfg.dbMaxIdleConns = vv
+		}
+	}
+
+	v, ok = os.LookupEnv("DB_CONN_LIFETIME")
+	if ok {
+		vv, err := strconv.ParseInt(v, 10, 64)
+		if err == nil {
+			cfg.dbConnLifetime = vv
+		}
+	}
+
+	v, ok = os.LookupEnv("HISTORY_SIZE")
+	if ok {
+		vv, err := strconv.Atoi(v)
+		if err == nil {
+			cfg.historySize = vv
+		}
+	}
+
+	v, ok = os.LookupEnv("HISTORY_MAX_AGE")
+	if ok {
+		vv, err := strconv.ParseInt(v, 10, 64)
+		if err == nil {
+			cfg.historyMaxAge = vv
+		}
+	}
+
+	v, ok = os.LookupEnv("ALERT_RULES")
+	if ok {
+		cfg.alertRulesPath = v
+	}
+
+	v, ok = os.LookupEnv("ALERT_INTERVAL")
+	if ok {
+		vv, err := strconv.ParseInt(v, 10, 64)
+		if err == nil {
+			cfg.alertInterval = vv
+		}
+	}
+
+	v, ok = os.LookupEnv("ALERT_WEBHOOKS")
+	if ok {
+		cfg.alertWebhooks = v
+	}
+
+	v, ok = os.LookupEnv("ALERT_GROUP_BY")
+	if ok {
+		cfg.alertGroupBy = v
+	}
+
+	v, ok = os.LookupEnv("ALERT_REPEAT_INTERVAL")
+	if ok {
+		vv, err := strconv.ParseInt(v, 10, 64)
+		if err == nil {
+			cfg.alertRepeatInterval = vv
+		}
+	}
+
+	v, ok = os.LookupEnv("WAL_DIR")
+	if ok {
+		cfg.walDir = v
+	}
+
+	v, ok = os.LookupEnv("WAL_SYNC")
+	if ok {
+		cfg.walSync = v
+	}
+
+	v, ok = os.LookupEnv("WAL_SYNC_INTERVAL")
+	if ok {
+		vv, err := strconv.ParseInt(v, 10, 64)
+		if err == nil {
+			cfg.walSyncInterval = vv
+		}
+	}
+
+	v, ok = os.LookupEnv("BACKUP_DIR")
+	if ok {
+		cfg.backupDir = v
+	}
+
+	v, ok = os.LookupEnv("BACKUP_INTERVAL")
+	if ok {
+		vv, err := strconv.ParseInt(v, 10, 64)
+		if err == nil {
+			cfg.backupInterval = vv
+		}
+	}
+
+	v, ok = os.LookupEnv("BACKUP_KEEP")
+	if ok {
+		vv, err := strconv.Atoi(v)
+		if err == nil {
+			cfg.backupKeep = vv
+		}
+	}
+
+	v, ok = os.LookupEnv("BACKUP_MAX_AGE")
+	if ok {
+		vv, err := strconv.ParseInt(v, 10, 64)
+		if err == nil {
+			cfg.backupMaxAge = vv
+		}
+	}
+
+	v, ok = os.LookupEnv("RESTORE_FROM")
+	if ok {
+		cfg.restoreFrom = v
+	}
+
+	v, ok = os.LookupEnv("ADMIN_API")
+	if ok {
+		cfg.adminAPI = v == "true"
+	}
+
+	v, ok = os.LookupEnv("KEY")
+	if ok {
+		cfg.key = v
+	}
+
+	v, ok = os.LookupEnv("CRYPTO_KEY")
+	if ok {
+		cfg.cryptoKey = v
+	}
+
+	v, ok = os.LookupEnv("IDEMPOTENCY_KEYS")
+	if ok {
+		vv, err := strconv.Atoi(v)
+		if err == nil {
+			cfg.idempotencyKeys = vv
+		}
+	}
+
+	v, ok = os.LookupEnv("IDEMPOTENCY_AGENTS")
+	if ok {
+		vv, err := strconv.Atoi(v)
+		if err == nil {
+			cfg.idempotencyAgents = vv
+		}
+	}
+
 	return cfg
 }
 
@@ -125,6 +350,25 @@ func defaultConfig() (cfg config) {
 		doRestoreValues: true,
 		storeInterval:   300,
 		fileStoragePath: "values.json",
+		dbMaxOpenConns:  10,
+		dbMaxIdleConns:  5,
+		dbConnLifetime:  300,
+		historySize:     1000,
+		historyMaxAge:   3600,
+		alertInterval:   15,
+
+		alertGroupBy:        "alertname",
+		alertRepeatInterval: 3600,
+
+		walSync:         "interval",
+		walSyncInterval: 1,
+
+		backupInterval: 3600,
+		backupKeep:     24,
+		backupMaxAge:   7 * 24 * 3600,
+
+		idempotencyKeys:   1000,
+		idempotencyAgents: 1000,
 	}
 }
 
@@ -134,6 +378,30 @@ func argsConfig(cfg config) config {
 	flag.BoolVar(&cfg.doRestoreValues, "r", false, "do restore saved values")
 	flag.StringVar(&cfg.fileStoragePath, "f", cfg.fileStoragePath, "path to storage file")
 	flag.Int64Var(&cfg.storeInterval, "i", cfg.storeInterval, "storage save interval in seconds")
+	flag.StringVar(&cfg.databaseDSN, "d", cfg.databaseDSN, "PostgreSQL connection string or SQLite database path, file storage is used if empty")
+	flag.IntVar(&cfg.dbMaxOpenConns, "db-max-open-conns", cfg.dbMaxOpenConns, "max open PostgreSQL connections, 0 for no limit")
+	flag.IntVar(&cfg.dbMaxIdleConns, "db-max-idle-conns", cfg.dbMaxIdleConns, "max idle PostgreSQL connections")
+	flag.Int64Var(&cfg.dbConnLifetime, "db-conn-lifetime", cfg.dbConnLifetime, "max lifetime of PostgreSQL connection in seconds, 0 for no limit")
+	flag.IntVar(&cfg.historySize, "history-size", cfg.historySize, "samples kept in history per series, 0 disables history")
+	flag.Int64Var(&cfg.historyMaxAge, "history-age", cfg.historyMaxAge, "max age of history samples in seconds, 0 for no limit")
+	flag.StringVar(&cfg.alertRulesPath, "alert-rules", cfg.alertRulesPath, "path to alert rules file")
+	flag.Int64Var(&cfg.alertInterval, "alert-interval", cfg.alertInterval, "alert rules evaluation interval in seconds")
+	flag.StringVar(&cfg.alertWebhooks, "alert-webhooks", cfg.alertWebhooks, "comma separated webhook urls for alert notifications")
+	flag.StringVar(&cfg.alertGroupBy, "alert-group-by", cfg.alertGroupBy, "comma separated alert labels to group notifications by")
+	flag.Int64Var(&cfg.alertRepeatInterval, "alert-repeat-interval", cfg.alertRepeatInterval, "interval in seconds to repeat notifications of firing alerts")
+	flag.StringVar(&cfg.walDir, "wal-dir", cfg.walDir, "directory of write-ahead log, log is disabled if empty")
+	flag.StringVar(&cfg.walSync, "wal-sync", cfg.walSync, "write-ahead log fsync policy [always|interval|never]")
+	flag.Int64Var(&cfg.walSyncInterval, "wal-sync-interval", cfg.walSyncInterval, "write-ahead log fsync interval in seconds for interval policy")
+	flag.StringVar(&cfg.backupDir, "backup-dir", cfg.backupDir, "directory of timestamped snapshot backups, backups are disabled if empty")
+	flag.Int64Var(&cfg.backupInterval, "backup-interval", cfg.backupInterval, "backup interval in seconds")
+	flag.IntVar(&cfg.backupKeep, "backup-keep", cfg.backupKeep, "number of kept backups, 0 for no limit")
+	flag.Int64Var(&cfg.backupMaxAge, "backup-max-age", cfg.backupMaxAge, "max age of kept backups in seconds, 0 for no limit")
+	flag.StringVar(&cfg.restoreFrom, "restore-from", cfg.restoreFrom, "backup name or snapshot path to restore instead of the storage file")
+	flag.BoolVar(&cfg.adminAPI, "admin-api", cfg.adminAPI, "enable admin API of backups, requests to it must be signed with key")
+	flag.IntVar(&cfg.idempotencyKeys, "idempotency-keys", cfg.idempotencyKeys, "idempotency keys of updates remembered per agent, 0 disables deduplication")
+	flag.IntVar(&cfg.idempotencyAgents, "idempotency-agents", cfg.idempotencyAgents, "agents whose idempotency keys are remembered, 0 for no limit")
+	flag.StringVar(&cfg.key, "k", cfg.key, "key of HMAC-SHA256 signatures of requests and responses, signing is disabled if empty")
+	flag.StringVar(&cfg.cryptoKey, "crypto-key", cfg.cryptoKey, "path to PEM encoded RSA private key decrypting request bodies, POST requests must be encrypted if set and encrypted requests are rejected if empty")
 	flag.Parse()
 
 	return cfg
@@ -119,6 +119,25 @@ func TestEnvConfig(t *testing.T) {
 				storeInterval:   300,
 				doRestoreValues: true,
 				fileStoragePath: "values.json",
+				dbMaxOpenConns:  10,
+				dbMaxIdleConns:  5,
+				dbConnLifetime:  300,
+				historySize:     1000,
+				historyMaxAge:   3600,
+				alertInterval:   15,
+
+				alertGroupBy:        "alertname",
+				alertRepeatInterval: 3600,
+
+				walSync:         "interval",
+				walSyncInterval: 1,
+
+				backupInterval: 3600,
+				backupKeep:     24,
+				backupMaxAge:   604800,
+
+				idempotencyKeys:   1000,
+				idempotencyAgents: 1000,
 			},
 		},
 		{
@@ -135,6 +154,25 @@ func TestEnvConfig(t *testing.T) {
 				storeInterval:   300,
 				doRestoreValues: true,
 				fileStoragePath: "values.json",
+				dbMaxOpenConns:  10,
+				dbMaxIdleConns:  5,
+				dbConnLifetime:  300,
+				historySize:     1000,
+				historyMaxAge:   3600,
+				alertInterval:   15,
+
+				alertGroupBy:        "alertname",
+				alertRepeatInterval: 3600,
+
+				walSync:         "interval",
+				walSyncInterval: 1,
+
+				backupInterval: 3600,
+				backupKeep:     24,
+				backupMaxAge:   604800,
+
+				idempotencyKeys:   1000,
+				idempotencyAgents: 1000,
 			},
 		},
 		{
@@ -149,6 +187,25 @@ func TestEnvConfig(t *testing.T) {
 				storeInterval:   300,
 				doRestoreValues: true,
 				fileStoragePath: "values.json",
+				dbMaxOpenConns:  10,
+				dbMaxIdleConns:  5,
+				dbConnLifetime:  300,
+				historySize:     1000,
+				historyMaxAge:   3600,
+				alertInterval:   15,
+
+				alertGroupBy:        "alertname",
+				alertRepeatInterval: 3600,
+
+				walSync:         "interval",
+				walSyncInterval: 1,
+
+				backupInterval: 3600,
+				backupKeep:     24,
+				backupMaxAge:   604800,
+
+				idempotencyKeys:   1000,
+				idempotencyAgents: 1000,
 			},
 		},
 		{
@@ -165,6 +222,25 @@ func TestEnvConfig(t *testing.T) {
 				storeInterval:   300,
 				doRestoreValues: true,
 				fileStoragePath: "values.json",
+				dbMaxOpenConns:  10,
+				dbMaxIdleConns:  5,
+				dbConnLifetime:  300,
+				historySize:     1000,
+				historyMaxAge:   3600,
+				alertInterval:   15,
+
+				alertGroupBy:        "alertname",
+				alertRepeatInterval: 3600,
+
+				walSync:         "interval",
+				walSyncInterval: 1,
+
+				backupInterval: 3600,
+				backupKeep:     24,
+				backupMaxAge:   604800,
+
+				idempotencyKeys:   1000,
+				idempotencyAgents: 1000,
 			},
 		},
 		{
@@ -182,6 +258,25 @@ func TestEnvConfig(t *testing.T) {
 				storeInterval:   300,
 				doRestoreValues: false,
 				fileStoragePath: "values.json",
+				dbMaxOpenConns:  10,
+				dbMaxIdleConns:  5,
+				dbConnLifetime:  300,
+				historySize:     1000,
+				historyMaxAge:   3600,
+				alertInterval:   15,
+
+				alertGroupBy:        "alertname",
+				alertRepeatInterval: 3600,
+
+				walSync:         "interval",
+				walSyncInterval: 1,
+
+				backupInterval: 3600,
+				backupKeep:     24,
+				backupMaxAge:   604800,
+
+				idempotencyKeys:   1000,
+				idempotencyAgents: 1000,
 			},
 		},
 		{
@@ -200,6 +295,25 @@ func TestEnvConfig(t *testing.T) {
 				storeInterval:   300,
 				doRestoreValues: false,
 				fileStoragePath: "test.log",
+				dbMaxOpenConns:  10,
+				dbMaxIdleConns:  5,
+				dbConnLifetime:  300,
+				historySize:     1000,
+				historyMaxAge:   3600,
+				alertInterval:   15,
+
+				alertGroupBy:        "alertname",
+				alertRepeatInterval: 3600,
+
+				walSync:         "interval",
+				walSyncInterval: 1,
+
+				backupInterval: 3600,
+				backupKeep:     24,
+				backupMaxAge:   604800,
+
+				idempotencyKeys:   1000,
+				idempotencyAgents: 1000,
 			},
 		},
 		{
@@ -218,6 +332,368 @@ func TestEnvConfig(t *testing.T) {
 				storeInterval:   15,
 				doRestoreValues: false,
 				fileStoragePath: "values.json",
+				dbMaxOpenConns:  10,
+				dbMaxIdleConns:  5,
+				dbConnLifetime:  300,
+				historySize:     1000,
+				historyMaxAge:   3600,
+				alertInterval:   15,
+
+				alertGroupBy:        "alertname",
+				alertRepeatInterval: 3600,
+
+				walSync:         "interval",
+				walSyncInterval: 1,
+
+				backupInterval: 3600,
+				backupKeep:     24,
+				backupMaxAge:   604800,
+
+				idempotencyKeys:   1000,
+				idempotencyAgents: 1000,
+			},
+		},
+		{
+			"database",
+			map[string]string{
+				"DATABASE_DSN": "metrics.db",
+			},
+			config{
+				endpoint: endpoint{
+					host: "localhost",
+					port: 8080,
+				},
+				logLevel:        "info",
+				storeInterval:   300,
+				doRestoreValues: true,
+				fileStoragePath: "values.json",
+				databaseDSN:     "metrics.db",
+				dbMaxOpenConns:  10,
+				dbMaxIdleConns:  5,
+				dbConnLifetime:  300,
+				historySize:     1000,
+				historyMaxAge:   3600,
+				alertInterval:   15,
+
+				alertGroupBy:        "alertname",
+				alertRepeatInterval: 3600,
+
+				walSync:         "interval",
+				walSyncInterval: 1,
+
+				backupInterval: 3600,
+				backupKeep:     24,
+				backupMaxAge:   604800,
+
+				idempotencyKeys:   1000,
+				idempotencyAgents: 1000,
+			},
+		},
+		{
+			"database pool",
+			map[string]string{
+				"DATABASE_DSN":      "postgres://localhost/metrics",
+				"DB_MAX_OPEN_CONNS": "20",
+				"DB_MAX_IDLE_CONNS": "2",
+				"DB_CONN_LIFETIME":  "60",
+			},
+			config{
+				endpoint: endpoint{
+					host: "localhost",
+					port: 8080,
+				},
+				logLevel:        "info",
+				storeInterval:   300,
+				doRestoreValues: true,
+				fileStoragePath: "values.json",
+				databaseDSN:     "postgres://localhost/metrics",
+				dbMaxOpenConns:  20,
+				dbMaxIdleConns:  2,
+				dbConnLifetime:  60,
+				historySize:     1000,
+				historyMaxAge:   3600,
+				alertInterval:   15,
+
+				alertGroupBy:        "alertname",
+				alertRepeatInterval: 3600,
+
+				walSync:         "interval",
+				walSyncInterval: 1,
+
+				backupInterval: 3600,
+				backupKeep:     24,
+				backupMaxAge:   604800,
+
+				idempotencyKeys:   1000,
+				idempotencyAgents: 1000,
+			},
+		},
+		{
+			"custom history",
+			map[string]string{
+				"HISTORY_SIZE":    "10",
+				"HISTORY_MAX_AGE": "60",
+			},
+			config{
+				endpoint: endpoint{
+					host: "localhost",
+					port: 8080,
+				},
+				logLevel:        "info",
+				storeInterval:   300,
+				doRestoreValues: true,
+				fileStoragePath: "values.json",
+				dbMaxOpenConns:  10,
+				dbMaxIdleConns:  5,
+				dbConnLifetime:  300,
+				historySize:     10,
+				historyMaxAge:   60,
+				alertInterval:   15,
+
+				alertGroupBy:        "alertname",
+				alertRepeatInterval: 3600,
+
+				walSync:         "interval",
+				walSyncInterval: 1,
+
+				backupInterval: 3600,
+				backupKeep:     24,
+				backupMaxAge:   604800,
+
+				idempotencyKeys:   1000,
+				idempotencyAgents: 1000,
+			},
+		},
+		{
+			"alert rules",
+			map[string]string{
+				"ALERT_RULES":    "rules.json",
+				"ALERT_INTERVAL": "5",
+			},
+			config{
+				endpoint: endpoint{
+					host: "localhost",
+					port: 8080,
+				},
+				logLevel:        "info",
+				storeInterval:   300,
+				doRestoreValues: true,
+				fileStoragePath: "values.json",
+				dbMaxOpenConns:  10,
+				dbMaxIdleConns:  5,
+				dbConnLifetime:  300,
+				historySize:     1000,
+				historyMaxAge:   3600,
+				alertRulesPath:  "rules.json",
+				alertInterval:   5,
+
+				alertGroupBy:        "alertname",
+				alertRepeatInterval: 3600,
+
+				walSync:         "interval",
+				walSyncInterval: 1,
+
+				backupInterval: 3600,
+				backupKeep:     24,
+				backupMaxAge:   604800,
+
+				idempotencyKeys:   1000,
+				idempotencyAgents: 1000,
+			},
+		},
+		{
+			"alert notifications",
+			map[string]string{
+				"ALERT_WEBHOOKS":        "http://a/hook,http://b/hook",
+				"ALERT_GROUP_BY":        "alertname,severity",
+				"ALERT_REPEAT_INTERVAL": "600",
+			},
+			config{
+				endpoint: endpoint{
+					host: "localhost",
+					port: 8080,
+				},
+				logLevel:        "info",
+				storeInterval:   300,
+				doRestoreValues: true,
+				fileStoragePath: "values.json",
+				dbMaxOpenConns:  10,
+				dbMaxIdleConns:  5,
+				dbConnLifetime:  300,
+				historySize:     1000,
+				historyMaxAge:   3600,
+				alertInterval:   15,
+
+				alertWebhooks:       "http://a/hook,http://b/hook",
+				alertGroupBy:        "alertname,severity",
+				alertRepeatInterval: 600,
+
+				walSync:         "interval",
+				walSyncInterval: 1,
+
+				backupInterval: 3600,
+				backupKeep:     24,
+				backupMaxAge:   604800,
+
+				idempotencyKeys:   1000,
+				idempotencyAgents: 1000,
+			},
+		},
+		{
+			"write-ahead log",
+			map[string]string{
+				"WAL_DIR":           "wal",
+				"WAL_SYNC":          "always",
+				"WAL_SYNC_INTERVAL": "5",
+			},
+			config{
+				endpoint: endpoint{
+					host: "localhost",
+					port: 8080,
+				},
+				logLevel:        "info",
+				storeInterval:   300,
+				doRestoreValues: true,
+				fileStoragePath: "values.json",
+				dbMaxOpenConns:  10,
+				dbMaxIdleConns:  5,
+				dbConnLifetime:  300,
+				historySize:     1000,
+				historyMaxAge:   3600,
+				alertInterval:   15,
+
+				alertGroupBy:        "alertname",
+				alertRepeatInterval: 3600,
+
+				walDir:          "wal",
+				walSync:         "always",
+				walSyncInterval: 5,
+
+				backupInterval: 3600,
+				backupKeep:     24,
+				backupMaxAge:   604800,
+
+				idempotencyKeys:   1000,
+				idempotencyAgents: 1000,
+			},
+		},
+		{
+			"backups",
+			map[string]string{
+				"BACKUP_DIR":      "backups",
+				"BACKUP_INTERVAL": "600",
+				"BACKUP_KEEP":     "5",
+				"BACKUP_MAX_AGE":  "86400",
+				"RESTORE_FROM":    "snapshot-20241001T120000.000000000Z.json",
+			},
+			config{
+				endpoint: endpoint{
+					host: "localhost",
+					port: 8080,
+				},
+				logLevel:        "info",
+				storeInterval:   300,
+				doRestoreValues: true,
+				fileStoragePath: "values.json",
+				dbMaxOpenConns:  10,
+				dbMaxIdleConns:  5,
+				dbConnLifetime:  300,
+				historySize:     1000,
+				historyMaxAge:   3600,
+				alertInterval:   15,
+
+				alertGroupBy:        "alertname",
+				alertRepeatInterval: 3600,
+
+				walSync:         "interval",
+				walSyncInterval: 1,
+
+				backupDir:      "backups",
+				backupInterval: 600,
+				backupKeep:     5,
+				backupMaxAge:   86400,
+				restoreFrom:    "snapshot-20241001T120000.000000000Z.json",
+
+				idempotencyKeys:   1000,
+				idempotencyAgents: 1000,
+			},
+		},
+		{
+			"keys",
+			map[string]string{
+				"KEY":        "secret",
+				"CRYPTO_KEY": "private.pem",
+			},
+			config{
+				endpoint: endpoint{
+					host: "localhost",
+					port: 8080,
+				},
+				logLevel:        "info",
+				storeInterval:   300,
+				doRestoreValues: true,
+				fileStoragePath: "values.json",
+				dbMaxOpenConns:  10,
+				dbMaxIdleConns:  5,
+				dbConnLifetime:  300,
+				historySize:     1000,
+				historyMaxAge:   3600,
+				alertInterval:   15,
+
+				alertGroupBy:        "alertname",
+				alertRepeatInterval: 3600,
+
+				walSync:         "interval",
+				walSyncInterval: 1,
+
+				backupInterval: 3600,
+				backupKeep:     24,
+				backupMaxAge:   604800,
+
+				idempotencyKeys:   1000,
+				idempotencyAgents: 1000,
+
+				key:       "secret",
+				cryptoKey: "private.pem",
+			},
+		},
+		{
+			"admin api",
+			map[string]string{
+				"ADMIN_API": "true",
+				"KEY":       "secret",
+			},
+			config{
+				endpoint: endpoint{
+					host: "localhost",
+					port: 8080,
+				},
+				logLevel:        "info",
+				storeInterval:   300,
+				doRestoreValues: true,
+				fileStoragePath: "values.json",
+				dbMaxOpenConns:  10,
+				dbMaxIdleConns:  5,
+				dbConnLifetime:  300,
+				historySize:     1000,
+				historyMaxAge:   3600,
+				alertInterval:   15,
+
+				alertGroupBy:        "alertname",
+				alertRepeatInterval: 3600,
+
+				walSync:         "interval",
+				walSyncInterval: 1,
+
+				backupInterval: 3600,
+				backupKeep:     24,
+				backupMaxAge:   604800,
+				adminAPI:       true,
+
+				idempotencyKeys:   1000,
+				idempotencyAgents: 1000,
+
+				key: "secret",
 			},
 		},
 	}
@@ -230,6 +706,30 @@ func TestEnvConfig(t *testing.T) {
 			os.Unsetenv("STORE_INTERVAL")
 			os.Unsetenv("FILE_STORAGE_PATH")
 			os.Unsetenv("RESTORE")
+			os.Unsetenv("DATABASE_DSN")
+			os.Unsetenv("DB_MAX_OPEN_CONNS")
+			os.Unsetenv("DB_MAX_IDLE_CONNS")
+			os.Unsetenv("DB_CONN_LIFETIME")
+			os.Unsetenv("HISTORY_SIZE")
+			os.Unsetenv("HISTORY_MAX_AGE")
+			os.Unsetenv("ALERT_RULES")
+			os.Unsetenv("ALERT_INTERVAL")
+			os.Unsetenv("ALERT_WEBHOOKS")
+			os.Unsetenv("ALERT_GROUP_BY")
+			os.Unsetenv("ALERT_REPEAT_INTERVAL")
+			os.Unsetenv("WAL_DIR")
+			os.Unsetenv("WAL_SYNC")
+			os.Unsetenv("WAL_SYNC_INTERVAL")
+			os.Unsetenv("BACKUP_DIR")
+			os.Unsetenv("BACKUP_INTERVAL")
+			os.Unsetenv("BACKUP_KEEP")
+			os.Unsetenv("BACKUP_MAX_AGE")
+			os.Unsetenv("RESTORE_FROM")
+			os.Unsetenv("ADMIN_API")
+			os.Unsetenv("IDEMPOTENCY_KEYS")
+			os.Unsetenv("IDEMPOTENCY_AGENTS")
+			os.Unsetenv("KEY")
+			os.Unsetenv("CRYPTO_KEY")
 
 			// set new env vars
 			for k, v := range tt.args {
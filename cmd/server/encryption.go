@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"io"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/mixailo/go-training-metrics/internal/service/encryption"
+	"github.com/mixailo/go-training-metrics/internal/service/logger"
+)
+
+// enableDecryption makes server decrypt request bodies encrypted with the public key of priv
+func (sa *storageAware) enableDecryption(priv *rsa.PrivateKey) {
+	sa.privKey = priv
+}
+
+// decrypted replaces encrypted request body with the decrypted one, which is usually compressed.
+// When server has private key, POST requests must be encrypted, other requests without encrypted key are passed as is.
+// Requests to admin API carry no body, so they are not encrypted.
+func (sa *storageAware) decrypted(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(encryption.KeyHeader)
+		if key == "" && sa.privKey != nil && r.Method == http.MethodPost && !strings.HasPrefix(r.URL.Path, adminPathPrefix) {
+			logger.Log.Warn("request is not encrypted", zap.String("uri", r.RequestURI))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if sa.privKey == nil {
+			logger.Log.Warn("encrypted request is received, but there is no private key", zap.String("uri", r.RequestURI))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		ciphertext, err := io.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		data, err := encryption.Decrypt(sa.privKey, key, ciphertext)
+		if err != nil {
+			logger.Log.Warn("cannot decrypt request", zap.String("uri", r.RequestURI), zap.Error(err))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(data))
+		r.ContentLength = int64(len(data))
+		r.Header.Del(encryption.KeyHeader)
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mixailo/go-training-metrics/internal/repository/storage"
+	"github.com/mixailo/go-training-metrics/internal/service/encryption"
+	"github.com/mixailo/go-training-metrics/internal/service/signature"
+)
+
+func Test_storageAware_decrypted(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	var gzipped bytes.Buffer
+	zw := gzip.NewWriter(&gzipped)
+	_, err = zw.Write([]byte(`{"id":"c","type":"counter","delta":5}`))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	encrypt := func(pub *rsa.PublicKey) (string, []byte) {
+		key, ciphertext, err := encryption.Encrypt(pub, gzipped.Bytes())
+		require.NoError(t, err)
+		return key, ciphertext
+	}
+	key, ciphertext := encrypt(&priv.PublicKey)
+	otherKey, otherCiphertext := encrypt(&other.PublicKey)
+
+	tests := []struct {
+		name       string
+		decrypting bool
+		signKey    []byte
+		key        string
+		body       []byte
+		wantStatus int
+		wantDelta  int64
+	}{
+		{name: "encrypted", decrypting: true, key: key, body: ciphertext, wantStatus: http.StatusOK, wantDelta: 5},
+		{name: "encrypted and signed", decrypting: true, signKey: []byte("secret"), key: key, body: ciphertext, wantStatus: http.StatusOK, wantDelta: 5},
+		{name: "plain", decrypting: true, body: gzipped.Bytes(), wantStatus: http.StatusBadRequest},
+		{name: "plain without private key", body: gzipped.Bytes(), wantStatus: http.StatusOK, wantDelta: 5},
+		{name: "encrypted for other key", decrypting: true, key: otherKey, body: otherCiphertext, wantStatus: http.StatusBadRequest},
+		{name: "altered", decrypting: true, key: key, body: append(bytes.Clone(ciphertext[:len(ciphertext)-1]), 0), wantStatus: http.StatusBadRequest},
+		{name: "no private key", key: key, body: ciphertext, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sa := newStorageAware(storage.NewShardedStorage())
+			if tt.decrypting {
+				sa.enableDecryption(priv)
+			}
+			if tt.signKey != nil {
+				sa.enableSigning(tt.signKey)
+			}
+			server := httptest.NewServer(newMux(sa))
+			defer server.Close()
+
+			req, err := http.NewRequest(http.MethodPost, server.URL+"/update/", bytes.NewReader(tt.body))
+			require.NoError(t, err)
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Content-Encoding", "gzip")
+			if tt.key != "" {
+				req.Header.Set(encryption.KeyHeader, tt.key)
+			}
+			if tt.signKey != nil {
+				// signature covers encrypted body
+				req.Header.Set(signature.Header, signature.Sign(tt.signKey, tt.body))
+			}
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+			c, _ := sa.stor.GetCounter("c")
+			assert.Equal(t, tt.wantDelta, c)
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mixailo/go-training-metrics/internal/repository/history"
+	"github.com/mixailo/go-training-metrics/internal/service/metrics"
+)
+
+// recordingStorage records every gauge and counter update into history
+type recordingStorage struct {
+	metricsStorage
+	hist *history.History
+}
+
+func newRecordingStorage(stor metricsStorage, hist *history.History) *recordingStorage {
+	return &recordingStorage{metricsStorage: stor, hist: hist}
+}
+
+func (rs *recordingStorage) keyed(agent, key string) metricsStorage {
+	return newRecordingStorage(keyedStorage(rs.metricsStorage, agent, key), rs.hist)
+}
+
+func (rs *recordingStorage) UpdateGauge(name string, value float64) error {
+	if err := rs.metricsStorage.UpdateGauge(name, value); err != nil {
+		return err
+	}
+	rs.hist.Record(history.Key(metrics.TypeGauge.String(), name), value)
+
+	return nil
+}
+
+func (rs *recordingStorage) UpdateCounter(name string, value int64) error {
+	if err := rs.metricsStorage.UpdateCounter(name, value); err != nil {
+		return err
+	}
+	rs.recordCounter(name)
+
+	return nil
+}
+
+func (rs *recordingStorage) UpdateBatch(batch []metrics.Metrics) error {
+	err := rs.metricsStorage.UpdateBatch(batch)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range batch {
+		switch item.MType {
+		case metrics.TypeCounter.String():
+			rs.recordCounter(item.SeriesKey())
+		case metrics.TypeGauge.String():
+			rs.hist.Record(history.Key(item.MType, item.SeriesKey()), *item.Value)
+		}
+	}
+
+	return nil
+}
+
+// recordCounter records accumulated counter value
+func (rs *recordingStorage) recordCounter(name string) {
+	if v, ok := rs.metricsStorage.GetCounter(name); ok {
+		rs.hist.Record(history.Key(metrics.TypeCounter.String(), name), float64(v))
+	}
+}
+
+// enableHistory makes storage record updates to be queried by queryRange
+func (sa *storageAware) enableHistory(hist *history.History) {
+	sa.stor = newRecordingStorage(sa.stor, hist)
+	sa.hist = hist
+}
+
+// maxRangePoints limits number of step-aligned values in a single response
+const maxRangePoints = 11000
+
+type rangeResponse struct {
+	Name    string           `json:"name"`
+	Type    string           `json:"type"`
+	Step    float64          `json:"step,omitempty"`
+	Samples []history.Sample `json:"samples"`
+}
+
+// queryRange returns recorded samples of the series, or values aligned to step if it is given.
+// Name is the series key (see metrics.SeriesKey), from and to are RFC 3339 or unix timestamps,
+// step is a duration like 15s or a number of seconds.
+func (sa *storageAware) queryRange(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if sa.hist == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	q := r.URL.Query()
+	name := q.Get("name")
+	mType := q.Get("type")
+	if name == "" || (mType != metrics.TypeGauge.String() && mType != metrics.TypeCounter.String()) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	to, err := parseTime(q.Get("to"), now)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	from, err := parseTime(q.Get("from"), to.Add(-time.Hour))
+	if err != nil || from.After(to) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	step, err := parseStep(q.Get("step"))
+	if err != nil || (step > 0 && to.Sub(from)/step > maxRangePoints) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	key := history.Key(mType, name)
+	if !sa.hist.Has(key) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	res := rangeResponse{Name: name, Type: mType}
+	if step > 0 {
+		// samples preceding the range define values at its beginning
+		res.Samples = history.Align(sa.hist.Range(key, time.Time{}, to), from, to, step)
+		res.Step = step.Seconds()
+	} else {
+		res.Samples = sa.hist.Range(key, from, to)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(res)
+}
+
+func parseTime(value string, def time.Time) (time.Time, error) {
+	if value == "" {
+		return def, nil
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Unix(0, int64(seconds*float64(time.Second))), nil
+	}
+
+	return time.Parse(time.RFC3339Nano, value)
+}
+
+func parseStep(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	step, err := time.ParseDuration(value)
+	if err != nil {
+		seconds, ferr := strconv.ParseFloat(value, 64)
+		if ferr != nil {
+			return 0, err
+		}
+		step = time.Duration(seconds * float64(time.Second))
+	}
+	if step < 0 {
+		return 0, errors.New("step must not be negative")
+	}
+
+	return step, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mixailo/go-training-metrics/internal/repository/history"
+	"github.com/mixailo/go-training-metrics/internal/repository/storage"
+)
+
+func Test_storageAware_queryRange(t *testing.T) {
+	sa := newStorageAware(storage.NewShardedStorage())
+	sa.enableHistory(history.New(10, 0))
+
+	server := httptest.NewServer(newMux(sa))
+	defer server.Close()
+
+	for _, body := range []string{
+		`{"id":"c","type":"counter","delta":1}`,
+		`{"id":"c","type":"counter","delta":2}`,
+		`{"id":"g","type":"gauge","value":1.5,"labels":{"host":"a"}}`,
+	} {
+		resp, err := resty.New().R().SetBody(body).Post(server.URL + "/update/")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode())
+	}
+	resp, err := resty.New().R().SetBody(`[{"id":"c","type":"counter","delta":3}]`).Post(server.URL + "/updates/")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode())
+
+	tests := []struct {
+		name   string
+		query  map[string]string
+		status int
+		values []float64
+	}{
+		{"counter totals", map[string]string{"name": "c", "type": "counter"}, http.StatusOK, []float64{1, 3, 6}},
+		{"labelled gauge", map[string]string{"name": `g{host="a"}`, "type": "gauge"}, http.StatusOK, []float64{1.5}},
+		{"step aligned", map[string]string{"name": "c", "type": "counter", "step": "1m"}, http.StatusOK, []float64{6}},
+		{"unknown series", map[string]string{"name": "g", "type": "gauge"}, http.StatusNotFound, nil},
+		{"unknown type", map[string]string{"name": "c", "type": "histogram"}, http.StatusBadRequest, nil},
+		{"invalid from", map[string]string{"name": "c", "type": "counter", "from": "yesterday"}, http.StatusBadRequest, nil},
+		{"too many points", map[string]string{"name": "c", "type": "counter", "step": "1ms"}, http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := resty.New().R().SetQueryParams(tt.query).Get(server.URL + "/api/v1/query_range")
+			require.NoError(t, err)
+			require.Equal(t, tt.status, resp.StatusCode())
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			var res rangeResponse
+			require.NoError(t, json.Unmarshal(resp.Body(), &res))
+			values := make([]float64, 0, len(res.Samples))
+			for _, s := range res.Samples {
+				values = append(values, s.Value)
+			}
+			assert.Equal(t, tt.values, values)
+		})
+	}
+}
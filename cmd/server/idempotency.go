@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/mixailo/go-training-metrics/internal/repository/idempotency"
+	"github.com/mixailo/go-training-metrics/internal/service/logger"
+	"github.com/mixailo/go-training-metrics/internal/service/metrics"
+)
+
+// idempotencySection is a section of snapshot data keeping results of applied requests
+const idempotencySection = "Idempotency"
+
+// maxIdempotencyKeyLen limits agent id and key remembered by server
+const maxIdempotencyKeyLen = 128
+
+// requestKey is idempotency key of agent kept in context of the request
+type requestKey struct {
+	agent, key string
+}
+
+type requestKeyCtx struct{}
+
+// keyer is implemented by storages which log idempotency key of request along with its updates
+type keyer interface {
+	keyed(agent, key string) metricsStorage
+}
+
+// keyedStorage returns storage applying updates of the request with idempotency key
+func keyedStorage(stor metricsStorage, agent, key string) metricsStorage {
+	if k, ok := stor.(keyer); ok {
+		return k.keyed(agent, key)
+	}
+
+	return stor
+}
+
+// updateStorage returns storage to apply updates of the request
+func (sa *storageAware) updateStorage(r *http.Request) metricsStorage {
+	if k, ok := r.Context().Value(requestKeyCtx{}).(requestKey); ok {
+		return keyedStorage(sa.stor, k.agent, k.key)
+	}
+
+	return sa.stor
+}
+
+// recordingWriter passes response through and keeps it to be returned for duplicates of the request
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rw *recordingWriter) WriteHeader(statusCode int) {
+	rw.status = statusCode
+	rw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (rw *recordingWriter) Write(p []byte) (int, error) {
+	rw.body.Write(p)
+	return rw.ResponseWriter.Write(p)
+}
+
+func (rw *recordingWriter) result() idempotency.Result {
+	return idempotency.Result{
+		Status:      rw.status,
+		ContentType: rw.Header().Get("Content-Type"),
+		Body:        rw.body.Bytes(),
+	}
+}
+
+// enableIdempotency makes updates with idempotency key applied once, the original result is returned for duplicates
+func (sa *storageAware) enableIdempotency(c *idempotency.Cache) {
+	sa.idem = c
+	if sa.wal != nil {
+		sa.wal.idem = c
+	}
+}
+
+// idempotent applies update with the same idempotency key of the same agent only once.
+// Requests without key are applied as usual.
+func (sa *storageAware) idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		agent, key := r.Header.Get(metrics.HeaderAgentID), r.Header.Get(metrics.HeaderIdempotencyKey)
+		if sa.idem == nil || agent == "" || key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(agent) > maxIdempotencyKeyLen || len(key) > maxIdempotencyKeyLen {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		res, found, err := sa.idem.Begin(r.Context(), agent, key)
+		if err != nil {
+			// client is gone while the original request is applied
+			return
+		}
+		if found {
+			logger.Log.Info("duplicate request, the original result is returned", zap.String("agent", agent), zap.String("key", key))
+			if res.ContentType != "" {
+				w.Header().Set("Content-Type", res.ContentType)
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(res.Status)
+			w.Write(res.Body)
+			return
+		}
+
+		rw := &recordingWriter{ResponseWriter: w, status: http.StatusOK}
+		applied := false
+		defer func() {
+			sa.idem.Finish(agent, key, rw.result(), applied)
+		}()
+		next.ServeHTTP(rw, r.WithContext(context.WithValue(r.Context(), requestKeyCtx{}, requestKey{agent: agent, key: key})))
+		// server errors leave storage untouched, so the request may be applied by retry
+		applied = rw.status < http.StatusInternalServerError
+	})
+}
+
+// snapshotData encodes storage along with results of applied requests. With write-ahead log keys are remembered
+// under its lock along with updates, which compaction holds, so both are cut at the same point. Otherwise storage
+// and results are encoded one after another, and a request applied in between may lack its key in snapshot.
+func (sa *storageAware) snapshotData(stor metricsStorage) ([]byte, error) {
+	data, err := json.Marshal(stor)
+	if err != nil || sa.idem == nil {
+		return data, err
+	}
+
+	var sections map[string]json.RawMessage
+	if err = json.Unmarshal(data, &sections); err != nil {
+		return nil, err
+	}
+	if sections[idempotencySection], err = json.Marshal(sa.idem); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(sections)
+}
+
+// load replaces storage with snapshot data, results of applied requests are replaced if snapshot keeps them
+func (sa *storageAware) load(data []byte) error {
+	if err := sa.stor.UnmarshalJSON(data); err != nil {
+		return err
+	}
+	if sa.idem == nil {
+		return nil
+	}
+
+	var sections map[string]json.RawMessage
+	if err := json.Unmarshal(data, &sections); err != nil {
+		return err
+	}
+	results, ok := sections[idempotencySection]
+	if !ok {
+		return nil
+	}
+
+	return sa.idem.UnmarshalJSON(results)
+}
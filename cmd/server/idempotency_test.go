@@ -0,0 +1,241 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mixailo/go-training-metrics/internal/repository/idempotency"
+	"github.com/mixailo/go-training-metrics/internal/repository/storage"
+	"github.com/mixailo/go-training-metrics/internal/service/metrics"
+)
+
+func newIdempotentStorageAware() *storageAware {
+	sa := newStorageAware(storage.NewShardedStorage())
+	sa.enableIdempotency(idempotency.New(10, 10))
+
+	return sa
+}
+
+func postIdempotent(t *testing.T, server *httptest.Server, path, body, agent, key string) (*http.Response, string) {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodPost, server.URL+path, strings.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	if agent != "" {
+		req.Header.Set(metrics.HeaderAgentID, agent)
+		req.Header.Set(metrics.HeaderIdempotencyKey, key)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	return resp, string(data)
+}
+
+var errStorageUnavailable = errors.New("storage is unavailable")
+
+// flakyStorage fails updates of gauges and counters while failures remain
+type flakyStorage struct {
+	metricsStorage
+	failures int
+}
+
+func (fs *flakyStorage) UpdateGauge(name string, value float64) error {
+	if fs.failures > 0 {
+		fs.failures--
+		return errStorageUnavailable
+	}
+	return fs.metricsStorage.UpdateGauge(name, value)
+}
+
+func (fs *flakyStorage) UpdateCounter(name string, value int64) error {
+	if fs.failures > 0 {
+		fs.failures--
+		return errStorageUnavailable
+	}
+	return fs.metricsStorage.UpdateCounter(name, value)
+}
+
+func Test_storageAware_idempotentFailedUpdate(t *testing.T) {
+	tests := []struct {
+		name  string
+		path  string
+		body  string
+		check func(sa *storageAware)
+	}{
+		{
+			name: "counter", path: "/update/", body: `{"id":"c","type":"counter","delta":5}`,
+			check: func(sa *storageAware) {
+				c, _ := sa.stor.GetCounter("c")
+				assert.Equal(t, int64(5), c)
+			},
+		},
+		{
+			name: "gauge", path: "/update/", body: `{"id":"g","type":"gauge","value":1.5}`,
+			check: func(sa *storageAware) {
+				g, _ := sa.stor.GetGauge("g")
+				assert.Equal(t, 1.5, g)
+			},
+		},
+		{
+			name: "counter in path", path: "/update/counter/c/5",
+			check: func(sa *storageAware) {
+				c, _ := sa.stor.GetCounter("c")
+				assert.Equal(t, int64(5), c)
+			},
+		},
+		{
+			name: "gauge in path", path: "/update/gauge/g/1.5",
+			check: func(sa *storageAware) {
+				g, _ := sa.stor.GetGauge("g")
+				assert.Equal(t, 1.5, g)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sa := newStorageAware(&flakyStorage{metricsStorage: storage.NewShardedStorage(), failures: 1})
+			sa.enableIdempotency(idempotency.New(10, 10))
+			server := httptest.NewServer(newMux(sa))
+			defer server.Close()
+
+			failed, _ := postIdempotent(t, server, tt.path, tt.body, "a", "1")
+			assert.Equal(t, http.StatusInternalServerError, failed.StatusCode, "failed update is not acknowledged")
+
+			retried, _ := postIdempotent(t, server, tt.path, tt.body, "a", "1")
+			assert.Equal(t, http.StatusOK, retried.StatusCode)
+			assert.Empty(t, retried.Header.Get("Idempotent-Replayed"), "failed update is not remembered as applied")
+			tt.check(sa)
+		})
+	}
+}
+
+func Test_storageAware_idempotent(t *testing.T) {
+	sa := newIdempotentStorageAware()
+	server := httptest.NewServer(newMux(sa))
+	defer server.Close()
+
+	update := `{"id":"c","type":"counter","delta":5}`
+	batch := `[{"id":"c","type":"counter","delta":1}]`
+
+	first, firstBody := postIdempotent(t, server, "/update/", update, "a", "1")
+	require.Equal(t, http.StatusOK, first.StatusCode)
+	dup, dupBody := postIdempotent(t, server, "/update/", update, "a", "1")
+	assert.Equal(t, http.StatusOK, dup.StatusCode)
+	assert.Equal(t, firstBody, dupBody, "the original result is returned")
+	assert.Equal(t, "application/json", dup.Header.Get("Content-Type"))
+	assert.Equal(t, "true", dup.Header.Get("Idempotent-Replayed"))
+	c, _ := sa.stor.GetCounter("c")
+	assert.Equal(t, int64(5), c, "duplicate is not applied")
+
+	// the same key of other agent and requests without key are applied
+	postIdempotent(t, server, "/update/", update, "b", "1")
+	postIdempotent(t, server, "/update/", update, "", "")
+	c, _ = sa.stor.GetCounter("c")
+	assert.Equal(t, int64(15), c)
+
+	postIdempotent(t, server, "/updates/", batch, "a", "2")
+	postIdempotent(t, server, "/updates/", batch, "a", "2")
+	c, _ = sa.stor.GetCounter("c")
+	assert.Equal(t, int64(16), c)
+
+	// rejected request is rejected again
+	bad, _ := postIdempotent(t, server, "/update/", `{"id":"c","type":"counter"}`, "a", "3")
+	assert.Equal(t, http.StatusBadRequest, bad.StatusCode)
+	bad, _ = postIdempotent(t, server, "/update/", update, "a", "3")
+	assert.Equal(t, http.StatusBadRequest, bad.StatusCode)
+
+	long, _ := postIdempotent(t, server, "/update/", update, "a", strings.Repeat("k", maxIdempotencyKeyLen+1))
+	assert.Equal(t, http.StatusBadRequest, long.StatusCode)
+}
+
+func Test_storageAware_idempotencySnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "values.json")
+	sa := newIdempotentStorageAware()
+	server := httptest.NewServer(newMux(sa))
+	defer server.Close()
+
+	postIdempotent(t, server, "/update/", `{"id":"c","type":"counter","delta":5}`, "a", "1")
+	require.NoError(t, sa.store(path))
+
+	restored := newIdempotentStorageAware()
+	_, err := restored.restore(path)
+	require.NoError(t, err)
+	c, _ := restored.stor.GetCounter("c")
+	assert.Equal(t, int64(5), c)
+	assert.Equal(t, 1, restored.idem.Len())
+
+	restoredServer := httptest.NewServer(newMux(restored))
+	defer restoredServer.Close()
+	postIdempotent(t, restoredServer, "/update/", `{"id":"c","type":"counter","delta":5}`, "a", "1")
+	c, _ = restored.stor.GetCounter("c")
+	assert.Equal(t, int64(5), c, "request applied before restart is not applied again")
+
+	// storage without idempotency reads the same snapshot
+	plain := newStorageAware(storage.NewShardedStorage())
+	_, err = plain.restore(path)
+	require.NoError(t, err)
+	c, _ = plain.stor.GetCounter("c")
+	assert.Equal(t, int64(5), c)
+}
+
+func Test_storageAware_idempotencyWAL(t *testing.T) {
+	dir := t.TempDir()
+	walDir := filepath.Join(dir, "wal")
+	path := filepath.Join(dir, "values.json")
+	update := `{"id":"c","type":"counter","delta":5}`
+
+	newSA := func(t *testing.T) *storageAware {
+		sa := newWALStorageAware(t, walDir)
+		sa.enableIdempotency(idempotency.New(10, 10))
+		return sa
+	}
+	restored := func(t *testing.T) *storageAware {
+		sa := newSA(t)
+		header, err := sa.restore(path)
+		if err != nil {
+			require.ErrorIs(t, err, os.ErrNotExist)
+		}
+		require.NoError(t, sa.replay(walDir, header.WALSegment))
+		return sa
+	}
+
+	sa := newSA(t)
+	server := httptest.NewServer(newMux(sa))
+	defer server.Close()
+	postIdempotent(t, server, "/update/", update, "a", "1")
+
+	// crash before compaction: the key is replayed from log along with the update
+	r := restored(t)
+	assert.Equal(t, 1, r.idem.Len())
+	rServer := httptest.NewServer(newMux(r))
+	defer rServer.Close()
+	resp, _ := postIdempotent(t, rServer, "/update/", update, "a", "1")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	c, _ := r.stor.GetCounter("c")
+	assert.Equal(t, int64(5), c, "duplicate is not applied after replay")
+
+	// keys of updates covered by compaction are kept in snapshot
+	require.NoError(t, sa.compact(path))
+	postIdempotent(t, server, "/updates/", `[{"id":"c","type":"counter","delta":1}]`, "a", "2")
+	r = restored(t)
+	assert.Equal(t, 2, r.idem.Len())
+	rServer2 := httptest.NewServer(newMux(r))
+	defer rServer2.Close()
+	postIdempotent(t, rServer2, "/update/", update, "a", "1")
+	postIdempotent(t, rServer2, "/updates/", `[{"id":"c","type":"counter","delta":1}]`, "a", "2")
+	c, _ = r.stor.GetCounter("c")
+	assert.Equal(t, int64(6), c)
+}
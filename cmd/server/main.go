@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
@@ -10,20 +12,37 @@ import (
 	"github.com/go-chi/chi/v5"
 	"go.uber.org/zap"
 
+	"github.com/mixailo/go-training-metrics/internal/repository/history"
+	"github.com/mixailo/go-training-metrics/internal/repository/idempotency"
+	"github.com/mixailo/go-training-metrics/internal/repository/snapshot"
 	"github.com/mixailo/go-training-metrics/internal/repository/storage"
+	"github.com/mixailo/go-training-metrics/internal/repository/wal"
+	"github.com/mixailo/go-training-metrics/internal/service/alerting"
+	"github.com/mixailo/go-training-metrics/internal/service/encryption"
 	"github.com/mixailo/go-training-metrics/internal/service/logger"
 )
 
 func newMux(sa *storageAware) *chi.Mux {
 	router := chi.NewRouter()
 
+	// signature covers body as it is sent, so it is checked before decryption and decompression
+	router.Use(sa.signed)
+	router.Use(sa.decrypted)
 	router.Use(gzipMiddleware)
 	router.Use(logger.RequestResponseLogger)
 
-	router.Post("/update/{type}/{name}/{value}", sa.updateItemValue)
+	router.With(sa.idempotent).Post("/update/{type}/{name}/{value}", sa.updateItemValue)
 	router.Get("/value/{type}/{name}", sa.getItemValue)
-	router.Post("/update/", sa.update)
+	router.With(sa.idempotent).Post("/update/", sa.update)
+	router.With(sa.idempotent).Post("/updates/", sa.updates)
 	router.Post("/value/", sa.value)
+	router.Get("/metrics", sa.prometheusMetrics)
+	router.Get("/api/v1/query_range", sa.queryRange)
+	router.Get("/api/v1/alerts", sa.listAlerts)
+	router.With(sa.adminOnly).Get("/api/v1/admin/backups", sa.listBackups)
+	router.With(sa.adminOnly).Post("/api/v1/admin/backups", sa.createBackup)
+	router.With(sa.adminOnly).Post("/api/v1/admin/backups/{name}/restore", sa.restoreBackup)
+	router.Get("/ping", sa.ping)
 	router.Get("/", sa.getAllValues)
 
 	return router
@@ -46,7 +65,7 @@ func persistenceTicker(c *config) {
 	logger.Log.Debug("init persistence ticker", zap.Int64("interval", c.storeInterval))
 
 	for range ticker.C {
-		err := sa.store(c.fileStoragePath)
+		err := persist(c)
 		if err != nil {
 			logger.Log.Error("persistence ticker error", zap.Error(err), zap.String("path", c.fileStoragePath))
 		} else {
@@ -56,14 +75,41 @@ func persistenceTicker(c *config) {
 }
 
 func shutdown(c *config) {
+	// notifications being delivered are abandoned
+	stopAlerting()
+
+	if c.databaseDSN != "" {
+		// database keeps every update already
+		logger.Log.Info("shutting down gracefully")
+		os.Exit(0)
+	}
+
 	logger.Log.Info("shutting down gracefully, let's save data to disk", zap.String("path", c.fileStoragePath))
-	err := sa.store(c.fileStoragePath)
+	err := persist(c)
 	if err != nil {
 		logger.Log.Error("graceful shutdown error", zap.Error(err))
 	}
 	os.Exit(0)
 }
 
+// persist saves snapshot of storage, with write-ahead log enabled the snapshot replaces logged updates
+func persist(c *config) error {
+	if sa.wal != nil {
+		return sa.compact(c.fileStoragePath)
+	}
+
+	return sa.store(c.fileStoragePath)
+}
+
+// persistRestored saves storage replaced by backup, so it is not overwritten by the previous snapshot and log after restart
+func persistRestored(c *config) error {
+	if c.databaseDSN != "" {
+		return nil
+	}
+
+	return persist(c)
+}
+
 func storingMiddleware(cnf *config) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -78,6 +124,25 @@ func storingMiddleware(cnf *config) func(http.Handler) http.Handler {
 
 var sa *storageAware
 
+// stopAlerting stops rules evaluation and notifications
+var stopAlerting = func() {}
+
+// newStorage opens database if it is configured, otherwise metrics are kept in memory and saved to file
+func newStorage(c *config) (metricsStorage, error) {
+	if storage.IsPostgresDSN(c.databaseDSN) {
+		return storage.NewPostgresStorage(c.databaseDSN, storage.PoolConfig{
+			MaxOpenConns:    c.dbMaxOpenConns,
+			MaxIdleConns:    c.dbMaxIdleConns,
+			ConnMaxLifetime: time.Duration(c.dbConnLifetime) * time.Second,
+		})
+	}
+	if c.databaseDSN != "" {
+		return storage.NewSQLiteStorage(c.databaseDSN)
+	}
+
+	return storage.NewShardedStorage(), nil
+}
+
 func main() {
 	// init logging
 	serverConf, err := initConfig()
@@ -91,15 +156,99 @@ func main() {
 	gracefulShutdownCatcher(&serverConf)
 
 	// init storage
-	sa = newStorageAware(storage.NewMemStorage())
-	if serverConf.doRestoreValues {
-		sa.restore(serverConf.fileStoragePath)
+	stor, err := newStorage(&serverConf)
+	if err != nil {
+		logger.Log.Fatal("cannot open storage", zap.Error(err))
+	}
+	sa = newStorageAware(stor)
+	if serverConf.key != "" {
+		sa.enableSigning([]byte(serverConf.key))
+	}
+	if serverConf.cryptoKey != "" {
+		priv, err := encryption.LoadPrivateKey(serverConf.cryptoKey)
+		if err != nil {
+			logger.Log.Fatal("cannot load private key", zap.Error(err), zap.String("path", serverConf.cryptoKey))
+		}
+		sa.enableDecryption(priv)
+	}
+	if serverConf.walDir != "" && serverConf.databaseDSN == "" {
+		policy, _ := wal.ParseSyncPolicy(serverConf.walSync)
+		log, err := wal.Open(serverConf.walDir, policy)
+		if err != nil {
+			logger.Log.Fatal("cannot open write-ahead log", zap.Error(err), zap.String("dir", serverConf.walDir))
+		}
+		sa.enableWAL(log)
+		go log.Run(context.Background(), time.Duration(serverConf.walSyncInterval)*time.Second, func(err error) {
+			logger.Log.Error("cannot sync write-ahead log", zap.Error(err))
+		})
+	}
+	if serverConf.idempotencyKeys > 0 {
+		sa.enableIdempotency(idempotency.New(serverConf.idempotencyKeys, serverConf.idempotencyAgents))
+	}
+	if serverConf.historySize > 0 {
+		sa.enableHistory(history.New(serverConf.historySize, time.Duration(serverConf.historyMaxAge)*time.Second))
+	}
+	if serverConf.backupDir != "" {
+		backups, err := snapshot.NewBackups(serverConf.backupDir, serverConf.backupKeep, time.Duration(serverConf.backupMaxAge)*time.Second)
+		if err != nil {
+			logger.Log.Fatal("cannot open backups", zap.Error(err), zap.String("dir", serverConf.backupDir))
+		}
+		sa.enableBackups(backups, func() error {
+			return persistRestored(&serverConf)
+		})
+		go backupsTicker(&serverConf)
+	}
+	if serverConf.adminAPI {
+		sa.enableAdmin()
+	}
+	if serverConf.restoreFrom != "" {
+		path := sa.backupPath(serverConf.restoreFrom)
+		if _, err = sa.replace(path); err != nil {
+			logger.Log.Fatal("cannot restore snapshot", zap.Error(err), zap.String("path", path))
+		}
+		// the snapshot replaces both the latest one and write-ahead log
+		if err = persistRestored(&serverConf); err != nil {
+			logger.Log.Fatal("cannot persist restored storage", zap.Error(err))
+		}
+		logger.Log.Info("storage restored from snapshot", zap.String("path", path))
+	} else if serverConf.doRestoreValues && serverConf.databaseDSN == "" {
+		header, err := sa.restore(serverConf.fileStoragePath)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			logger.Log.Info("no snapshot to restore", zap.String("path", serverConf.fileStoragePath))
+		case err != nil:
+			// starting with empty storage would overwrite the snapshot which may still be recovered
+			logger.Log.Fatal("cannot restore snapshot, fix or remove it to start", zap.Error(err), zap.String("path", serverConf.fileStoragePath))
+		}
+		if sa.wal != nil {
+			if err = sa.replay(serverConf.walDir, header.WALSegment); err != nil {
+				logger.Log.Fatal("cannot replay write-ahead log", zap.Error(err), zap.String("dir", serverConf.walDir))
+			}
+		}
+	} else if sa.wal != nil {
+		// updates of previous runs are not restored
+		if err = sa.wal.log.Remove(sa.wal.log.Segment() - 1); err != nil {
+			logger.Log.Fatal("cannot clear write-ahead log", zap.Error(err), zap.String("dir", serverConf.walDir))
+		}
+	}
+
+	var rules []alerting.Rule
+	if serverConf.alertRulesPath != "" {
+		rules, err = alerting.LoadRules(serverConf.alertRulesPath)
+		if err != nil {
+			logger.Log.Fatal("cannot load alert rules", zap.Error(err), zap.String("path", serverConf.alertRulesPath))
+		}
 	}
+	alertingCtx, cancel := context.WithCancel(context.Background())
+	stopAlerting = cancel
+	startAlerting(alertingCtx, sa.enableAlerting(rules), time.Duration(serverConf.alertInterval)*time.Second, newNotifier(&serverConf))
 
 	logger.Log.Info(fmt.Sprintf("Starting server at %s:%d", serverConf.endpoint.host, serverConf.endpoint.port))
 
 	chiMux := newMux(sa)
-	if serverConf.storeInterval == 0 {
+	if serverConf.databaseDSN != "" {
+		logger.Log.Info("data is stored in database")
+	} else if serverConf.storeInterval == 0 {
 		logger.Log.Info("will save data to disk immediately")
 		chiMux.Use(storingMiddleware(&serverConf))
 	} else {
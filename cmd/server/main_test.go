@@ -2,17 +2,25 @@ package main
 
 import (
 	"compress/gzip"
+	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-resty/resty/v2"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/mixailo/go-training-metrics/internal/repository/snapshot"
 	"github.com/mixailo/go-training-metrics/internal/repository/storage"
+	"github.com/mixailo/go-training-metrics/internal/service/alerting"
+	"github.com/mixailo/go-training-metrics/internal/service/metrics"
 )
 
 func Test_newStorageAware(t *testing.T) {
@@ -24,13 +32,13 @@ func Test_newStorageAware(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			assert.IsType(t, newStorageAware(storage.NewMemStorage()), &storageAware{})
+			assert.IsType(t, newStorageAware(storage.NewShardedStorage()), &storageAware{})
 		})
 	}
 }
 
 func Test_storageAware_getAllValues(t *testing.T) {
-	sa := newStorageAware(storage.NewMemStorage())
+	sa := newStorageAware(storage.NewShardedStorage())
 
 	server := httptest.NewServer(newMux(sa))
 
@@ -85,3 +93,446 @@ func Test_storageAware_getAllValues(t *testing.T) {
 		defer resp.RawResponse.Body.Close()
 	})
 }
+
+func Test_storageAware_updates(t *testing.T) {
+	sa := newStorageAware(storage.NewShardedStorage())
+
+	server := httptest.NewServer(newMux(sa))
+
+	defer server.Close()
+
+	tests := []struct {
+		name         string
+		body         string
+		status       int
+		hasSubstring string
+	}{
+		{
+			"valid batch",
+			`[{"id":"c","type":"counter","delta":2},{"id":"c","type":"counter","delta":3},{"id":"g","type":"gauge","value":1.5}]`,
+			http.StatusOK,
+			`{"id":"c","type":"counter","delta":5}`,
+		},
+		{
+			"invalid item",
+			`[{"id":"c","type":"counter","delta":2},{"id":"g","type":"gauge"}]`,
+			http.StatusBadRequest,
+			`"error":"metric is not writable"`,
+		},
+		{
+			"not an array",
+			`{"id":"c","type":"counter","delta":2}`,
+			http.StatusBadRequest,
+			"",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := resty.New().R()
+			req.Method = http.MethodPost
+			req.URL = server.URL + "/updates/"
+			req.SetHeader("Content-Type", "application/json")
+			req.SetBody(tt.body)
+
+			resp, err := req.Send()
+
+			assert.NoError(t, err, "error making HTTP request")
+			assert.Equal(t, tt.status, resp.StatusCode(), "unexpected status code")
+			if len(tt.hasSubstring) > 0 {
+				assert.Contains(t, string(resp.Body()), tt.hasSubstring)
+			}
+		})
+	}
+
+	t.Run("invalid batch is not applied", func(t *testing.T) {
+		// "c" was incremented only by the valid batch
+		v, ok := sa.stor.GetCounter("c")
+		assert.True(t, ok)
+		assert.Equal(t, int64(5), v)
+	})
+}
+
+func Test_storageAware_labels(t *testing.T) {
+	sa := newStorageAware(storage.NewShardedStorage())
+
+	server := httptest.NewServer(newMux(sa))
+
+	defer server.Close()
+
+	post := func(path, body string) *resty.Response {
+		resp, err := resty.New().R().
+			SetHeader("Content-Type", "application/json").
+			SetBody(body).
+			Post(server.URL + path)
+		require.NoError(t, err)
+		return resp
+	}
+
+	post("/update/", `{"id":"Alloc","type":"gauge","value":1}`)
+	post("/update/", `{"id":"Alloc","type":"gauge","value":2,"labels":{"host":"a"}}`)
+	post("/update/", `{"id":"Alloc","type":"gauge","value":3,"labels":{"host":"b"}}`)
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		want   string
+	}{
+		{
+			"label-less client",
+			`{"id":"Alloc","type":"gauge"}`,
+			http.StatusOK,
+			`{"id":"Alloc","type":"gauge","value":1}`,
+		},
+		{
+			"host a",
+			`{"id":"Alloc","type":"gauge","labels":{"host":"a"}}`,
+			http.StatusOK,
+			`{"id":"Alloc","type":"gauge","value":2,"labels":{"host":"a"}}`,
+		},
+		{
+			"host b",
+			`{"id":"Alloc","type":"gauge","labels":{"host":"b"}}`,
+			http.StatusOK,
+			`{"id":"Alloc","type":"gauge","value":3,"labels":{"host":"b"}}`,
+		},
+		{
+			"unknown host",
+			`{"id":"Alloc","type":"gauge","labels":{"host":"c"}}`,
+			http.StatusNotFound,
+			"",
+		},
+		{
+			"invalid label name",
+			`{"id":"Alloc","type":"gauge","labels":{"host-name":"a"}}`,
+			http.StatusBadRequest,
+			"",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := post("/value/", tt.body)
+			assert.Equal(t, tt.status, resp.StatusCode())
+			if tt.want != "" {
+				assert.JSONEq(t, tt.want, string(resp.Body()))
+			}
+		})
+	}
+
+	t.Run("id aliasing series key", func(t *testing.T) {
+		resp := post("/update/", `{"id":"Alloc{host=\"a\"}","type":"gauge","value":4}`)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode())
+		resp = post("/update/gauge/Alloc{host=%22a%22}/4", "")
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode())
+
+		v, _ := sa.stor.GetGauge(`Alloc{host="a"}`)
+		assert.Equal(t, 2.0, v)
+	})
+
+	t.Run("html page", func(t *testing.T) {
+		resp, err := resty.New().R().Get(server.URL + "/")
+		require.NoError(t, err)
+		assert.Contains(t, string(resp.Body()), "<td>Alloc</td><td>host=&#34;a&#34;</td><td>2</td>")
+	})
+}
+
+func Test_storageAware_histogram(t *testing.T) {
+	sa := newStorageAware(storage.NewShardedStorage())
+
+	server := httptest.NewServer(newMux(sa))
+
+	defer server.Close()
+
+	post := func(path, body string) *resty.Response {
+		resp, err := resty.New().R().
+			SetHeader("Content-Type", "application/json").
+			SetBody(body).
+			Post(server.URL + path)
+		require.NoError(t, err)
+		return resp
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		body   string
+		status int
+	}{
+		{"observation with bounds", "/update/", `{"id":"lat","type":"histogram","value":0.3,"histogram":{"bounds":[0.1,0.5]}}`, http.StatusOK},
+		{"observation", "/update/", `{"id":"lat","type":"histogram","value":0.05}`, http.StatusOK},
+		{"pre-bucketed", "/update/", `{"id":"lat","type":"histogram","histogram":{"bounds":[0.1,0.5],"counts":[0,0,2],"sum":2,"count":2}}`, http.StatusOK},
+		{"bounds mismatch", "/update/", `{"id":"lat","type":"histogram","histogram":{"bounds":[1],"counts":[0,1],"sum":2,"count":1}}`, http.StatusBadRequest},
+		{"inconsistent counts", "/update/", `{"id":"lat","type":"histogram","histogram":{"bounds":[0.1,0.5],"counts":[0,0,2],"sum":2,"count":1}}`, http.StatusBadRequest},
+		{"batch mismatch", "/updates/", `[{"id":"g","type":"gauge","value":1},{"id":"lat","type":"histogram","value":1,"histogram":{"bounds":[1]}}]`, http.StatusBadRequest},
+		{"infinity", "/update/histogram/lat/+Inf", "", http.StatusBadRequest},
+		{"NaN", "/update/histogram/lat/NaN", "", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.status, post(tt.path, tt.body).StatusCode())
+		})
+	}
+
+	t.Run("value", func(t *testing.T) {
+		resp := post("/value/", `{"id":"lat","type":"histogram"}`)
+		assert.Equal(t, http.StatusOK, resp.StatusCode())
+		assert.JSONEq(t, `{"id":"lat","type":"histogram","histogram":{"bounds":[0.1,0.5],"counts":[1,1,2],"sum":2.35,"count":4}}`, string(resp.Body()))
+
+		_, ok := sa.stor.GetGauge("g")
+		assert.False(t, ok, "batch must not be applied partially")
+	})
+
+	t.Run("sum overflow", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, post("/update/histogram/big/1.7e308", "").StatusCode())
+		assert.Equal(t, http.StatusBadRequest, post("/update/histogram/big/1.7e308", "").StatusCode())
+
+		// storage is still encoded for snapshots
+		_, err := sa.stor.MarshalJSON()
+		assert.NoError(t, err)
+	})
+
+	t.Run("html page", func(t *testing.T) {
+		resp, err := resty.New().R().Get(server.URL + "/")
+		require.NoError(t, err)
+		assert.Contains(t, string(resp.Body()), "<td>Histogram</td><td>lat</td><td></td><td>count=4 sum=2.35 le=0.1:1 le=0.5:2 le=+Inf:4</td>")
+	})
+
+	t.Run("prometheus", func(t *testing.T) {
+		resp, err := resty.New().R().Get(server.URL + "/metrics")
+		require.NoError(t, err)
+		assert.Contains(t, string(resp.Body()), "# TYPE lat histogram\n"+
+			"lat_bucket{le=\"0.1\"} 1\n"+
+			"lat_bucket{le=\"0.5\"} 2\n"+
+			"lat_bucket{le=\"+Inf\"} 4\n"+
+			"lat_sum 2.35\n"+
+			"lat_count 4\n")
+	})
+}
+
+func Test_storageAware_summary(t *testing.T) {
+	sa := newStorageAware(storage.NewShardedStorage())
+
+	server := httptest.NewServer(newMux(sa))
+
+	defer server.Close()
+
+	post := func(path, body string) *resty.Response {
+		resp, err := resty.New().R().
+			SetHeader("Content-Type", "application/json").
+			SetBody(body).
+			Post(server.URL + path)
+		require.NoError(t, err)
+		return resp
+	}
+
+	for i := 1; i <= 100; i++ {
+		resp := post("/update/", `{"id":"lat","type":"summary","value":`+strconv.Itoa(i)+`}`)
+		require.Equal(t, http.StatusOK, resp.StatusCode())
+	}
+	assert.Equal(t, http.StatusBadRequest, post("/update/summary/lat/+Inf", "").StatusCode())
+	assert.Equal(t, http.StatusBadRequest, post("/update/", `{"id":"lat","type":"summary","value":1e999}`).StatusCode())
+
+	tests := []struct {
+		name     string
+		body     string
+		status   int
+		quantile float64
+	}{
+		{"median", `{"id":"lat","type":"summary","quantile":0.5}`, http.StatusOK, 50},
+		{"p99", `{"id":"lat","type":"summary","quantile":0.99}`, http.StatusOK, 99},
+		{"invalid quantile", `{"id":"lat","type":"summary","quantile":2}`, http.StatusBadRequest, 0},
+		{"unknown", `{"id":"none","type":"summary","quantile":0.5}`, http.StatusNotFound, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := post("/value/", tt.body)
+			require.Equal(t, tt.status, resp.StatusCode())
+			if tt.status != http.StatusOK {
+				return
+			}
+			var m metrics.Metrics
+			require.NoError(t, json.Unmarshal(resp.Body(), &m))
+			require.NotNil(t, m.Value)
+			assert.InEpsilon(t, tt.quantile, *m.Value, metrics.DefaultSketchAccuracy)
+		})
+	}
+
+	t.Run("whole sketch", func(t *testing.T) {
+		resp := post("/value/", `{"id":"lat","type":"summary"}`)
+		require.Equal(t, http.StatusOK, resp.StatusCode())
+		var m metrics.Metrics
+		require.NoError(t, json.Unmarshal(resp.Body(), &m))
+		require.NotNil(t, m.Summary)
+		assert.Equal(t, uint64(100), m.Summary.Count)
+	})
+
+	t.Run("sum overflow", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, post("/update/summary/big/1.7e308", "").StatusCode())
+		assert.Equal(t, http.StatusBadRequest, post("/update/summary/big/1.7e308", "").StatusCode())
+
+		// storage is still encoded for snapshots
+		_, err := sa.stor.MarshalJSON()
+		assert.NoError(t, err)
+	})
+
+	t.Run("storage failure", func(t *testing.T) {
+		db, err := storage.NewSQLiteStorage(":memory:")
+		require.NoError(t, err)
+		require.NoError(t, db.Close())
+		failing := httptest.NewServer(newMux(newStorageAware(db)))
+		defer failing.Close()
+
+		resp, err := resty.New().R().Post(failing.URL + "/update/summary/lat/1")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusInternalServerError, resp.StatusCode(), "failed update is not acknowledged")
+	})
+
+	t.Run("prometheus", func(t *testing.T) {
+		resp, err := resty.New().R().Get(server.URL + "/metrics")
+		require.NoError(t, err)
+		assert.Contains(t, string(resp.Body()), "# TYPE lat summary\nlat{quantile=\"0.5\"} ")
+		assert.Contains(t, string(resp.Body()), "lat_sum 5050\nlat_count 100\n")
+	})
+}
+
+func Test_storageAware_listAlerts(t *testing.T) {
+	sa := newStorageAware(storage.NewShardedStorage())
+	sa.stor.UpdateGauge("Alloc", 200)
+	engine := sa.enableAlerting([]alerting.Rule{
+		{Name: "HighAlloc", Metric: "Alloc", Type: "gauge", Op: ">", Threshold: 100},
+	})
+
+	server := httptest.NewServer(newMux(sa))
+	defer server.Close()
+
+	resp, err := resty.New().R().Get(server.URL + "/api/v1/alerts")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	assert.JSONEq(t, `{"alerts":[]}`, string(resp.Body()))
+
+	engine.Evaluate(time.Now())
+	resp, err = resty.New().R().Get(server.URL + "/api/v1/alerts")
+	require.NoError(t, err)
+	assert.Contains(t, string(resp.Body()), `"rule":"HighAlloc"`)
+	assert.Contains(t, string(resp.Body()), `"state":"firing"`)
+}
+
+func Test_storageAware_alertingConcurrently(t *testing.T) {
+	sa := newStorageAware(storage.NewShardedStorage())
+	engine := sa.enableAlerting([]alerting.Rule{
+		{Name: "HighAlloc", Metric: "Alloc", Type: "gauge", Op: ">", Threshold: 100},
+		{Name: "ManyPolls", Metric: "PollCount", Type: "counter", Op: ">", Threshold: 100},
+	})
+
+	server := httptest.NewServer(newMux(sa))
+	defer server.Close()
+
+	// run with -race, rules are evaluated in background while handlers update storage
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			engine.Evaluate(time.Now())
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		_, err := resty.New().R().Post(server.URL + "/update/gauge/Alloc/" + strconv.Itoa(i*10))
+		require.NoError(t, err)
+		_, err = resty.New().R().Post(server.URL + "/update/counter/PollCount/10")
+		require.NoError(t, err)
+	}
+	<-done
+
+	engine.Evaluate(time.Now())
+	assert.Len(t, engine.Alerts(), 2)
+}
+
+func Test_storageAware_ping(t *testing.T) {
+	db, err := storage.NewSQLiteStorage(":memory:")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		stor   metricsStorage
+		close  bool
+		status int
+	}{
+		{"memory storage", storage.NewShardedStorage(), false, http.StatusOK},
+		{"database", db, false, http.StatusOK},
+		{"database is closed", db, true, http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.close {
+				require.NoError(t, db.Close())
+			}
+			server := httptest.NewServer(newMux(newStorageAware(tt.stor)))
+			defer server.Close()
+
+			resp, err := resty.New().R().Get(server.URL + "/ping")
+			require.NoError(t, err)
+			assert.Equal(t, tt.status, resp.StatusCode())
+		})
+	}
+}
+
+func Test_storageAware_restore(t *testing.T) {
+	dir := t.TempDir()
+
+	t.Run("store and restore", func(t *testing.T) {
+		path := filepath.Join(dir, "values.json")
+		sa := newStorageAware(storage.NewShardedStorage())
+		sa.stor.UpdateCounter("c", 3)
+		require.NoError(t, sa.store(path))
+
+		restored := newStorageAware(storage.NewShardedStorage())
+		header, err := restored.restore(path)
+		require.NoError(t, err)
+		assert.Equal(t, snapshot.Version, header.Version)
+		counter, _ := restored.stor.GetCounter("c")
+		assert.Equal(t, int64(3), counter)
+	})
+
+	t.Run("legacy snapshot is upgraded", func(t *testing.T) {
+		path := filepath.Join(dir, "legacy.json")
+		require.NoError(t, os.WriteFile(path, []byte(`{"Gauges":{"g":1.5},"Counters":{"c":3}}`), 0666))
+
+		restored := newStorageAware(storage.NewShardedStorage())
+		header, err := restored.restore(path)
+		require.NoError(t, err)
+		assert.Equal(t, snapshot.Version, header.Version)
+		gauge, _ := restored.stor.GetGauge("g")
+		assert.Equal(t, 1.5, gauge)
+
+		header, _, err = snapshot.Read(path)
+		require.NoError(t, err)
+		assert.Equal(t, snapshot.Version, header.Version)
+	})
+
+	t.Run("empty legacy snapshot", func(t *testing.T) {
+		path := filepath.Join(dir, "empty.json")
+		require.NoError(t, os.WriteFile(path, nil, 0666))
+
+		restored := newStorageAware(storage.NewShardedStorage())
+		header, err := restored.restore(path)
+		require.NoError(t, err)
+		assert.Equal(t, snapshot.Version, header.Version)
+		assert.Empty(t, restored.stor.Gauges())
+	})
+
+	t.Run("corrupt snapshot", func(t *testing.T) {
+		path := filepath.Join(dir, "corrupt.json")
+		require.NoError(t, os.WriteFile(path, []byte(`{"Gauges":{"g":`), 0666))
+
+		_, err := newStorageAware(storage.NewShardedStorage()).restore(path)
+		assert.ErrorIs(t, err, snapshot.ErrFormat)
+	})
+
+	t.Run("missing snapshot", func(t *testing.T) {
+		_, err := newStorageAware(storage.NewShardedStorage()).restore(filepath.Join(dir, "missing.json"))
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	})
+}
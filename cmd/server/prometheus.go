@@ -0,0 +1,223 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/mixailo/go-training-metrics/internal/service/logger"
+	"github.com/mixailo/go-training-metrics/internal/service/metrics"
+)
+
+const (
+	prometheusContentType  = "text/plain; version=0.0.4; charset=utf-8"
+	openMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+type promSample struct {
+	name   string
+	labels string
+	value  string
+}
+
+type promFamily struct {
+	name    string
+	mType   metrics.Type
+	samples []promSample
+}
+
+// prometheusMetrics renders all stored metrics in Prometheus text format
+// or in OpenMetrics format if client asks for it
+func (sa *storageAware) prometheusMetrics(w http.ResponseWriter, r *http.Request) {
+	openMetrics := strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text")
+
+	families := sa.promFamilies(openMetrics)
+
+	var sb strings.Builder
+	for _, f := range families {
+		sb.WriteString("# TYPE " + f.name + " " + f.mType.String() + "\n")
+		for _, s := range f.samples {
+			sb.WriteString(s.name + s.labels + " " + s.value + "\n")
+		}
+	}
+	if openMetrics {
+		sb.WriteString("# EOF\n")
+		w.Header().Set("Content-Type", openMetricsContentType)
+	} else {
+		w.Header().Set("Content-Type", prometheusContentType)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, sb.String())
+}
+
+func (sa *storageAware) promFamilies(openMetrics bool) []promFamily {
+	byName := make(map[string]*promFamily)
+	seen := make(map[string]bool)
+
+	add := func(key string, mType metrics.Type, samples func(name string, labels map[string]string) []promSample) {
+		id, labels := metrics.ParseSeriesKey(key)
+		name := prometheusName(id)
+		if mType == metrics.TypeCounter && openMetrics {
+			// OpenMetrics counter family has no _total suffix while its sample has
+			name = strings.TrimSuffix(name, "_total")
+		}
+
+		f, ok := byName[name]
+		if !ok {
+			f = &promFamily{name: name, mType: mType}
+			byName[name] = f
+		} else if f.mType != mType {
+			logger.Log.Warn("metric name clashes with a metric of another type", zap.String("id", id), zap.String("name", name))
+			return
+		}
+		series := name + prometheusLabels(labels)
+		if seen[series] {
+			logger.Log.Warn("duplicate metric name after sanitisation", zap.String("id", id), zap.String("name", name))
+			return
+		}
+		seen[series] = true
+		f.samples = append(f.samples, samples(name, labels)...)
+	}
+
+	// sorted keys make clash resolution deterministic
+	gauges := sa.stor.Gauges()
+	for _, key := range sortedKeys(gauges) {
+		add(key, metrics.TypeGauge, func(name string, labels map[string]string) []promSample {
+			return []promSample{{name: name, labels: prometheusLabels(labels), value: promFloat(gauges[key])}}
+		})
+	}
+	counters := sa.stor.Counters()
+	for _, key := range sortedKeys(counters) {
+		add(key, metrics.TypeCounter, func(name string, labels map[string]string) []promSample {
+			if openMetrics {
+				name += "_total"
+			}
+			return []promSample{{name: name, labels: prometheusLabels(labels), value: strconv.FormatInt(counters[key], 10)}}
+		})
+	}
+	histograms := sa.stor.Histograms()
+	for _, key := range sortedKeys(histograms) {
+		add(key, metrics.TypeHistogram, func(name string, labels map[string]string) []promSample {
+			return histogramSamples(name, labels, histograms[key])
+		})
+	}
+	summaries := sa.stor.Summaries()
+	for _, key := range sortedKeys(summaries) {
+		add(key, metrics.TypeSummary, func(name string, labels map[string]string) []promSample {
+			return summarySamples(name, labels, summaries[key])
+		})
+	}
+
+	result := make([]promFamily, 0, len(byName))
+	for _, f := range byName {
+		result = append(result, *f)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].name < result[j].name
+	})
+
+	return result
+}
+
+// histogramSamples renders cumulative buckets, sum and count of histogram
+func histogramSamples(name string, labels map[string]string, h metrics.Histogram) []promSample {
+	samples := make([]promSample, 0, len(h.Counts)+2)
+
+	bucketLabels := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		bucketLabels[k] = v
+	}
+	for i, c := range h.Cumulative() {
+		bucketLabels["le"] = "+Inf"
+		if i < len(h.Bounds) {
+			bucketLabels["le"] = promFloat(h.Bounds[i])
+		}
+		samples = append(samples, promSample{name: name + "_bucket", labels: prometheusLabels(bucketLabels), value: strconv.FormatUint(c, 10)})
+	}
+
+	return append(samples,
+		promSample{name: name + "_sum", labels: prometheusLabels(labels), value: promFloat(h.Sum)},
+		promSample{name: name + "_count", labels: prometheusLabels(labels), value: strconv.FormatUint(h.Count, 10)},
+	)
+}
+
+// summarySamples renders estimated quantiles, sum and count of summary
+func summarySamples(name string, labels map[string]string, s metrics.Sketch) []promSample {
+	samples := make([]promSample, 0, len(summaryQuantiles)+2)
+
+	quantileLabels := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		quantileLabels[k] = v
+	}
+	for _, q := range summaryQuantiles {
+		v, err := s.Quantile(q)
+		if err != nil {
+			break
+		}
+		quantileLabels["quantile"] = promFloat(q)
+		samples = append(samples, promSample{name: name, labels: prometheusLabels(quantileLabels), value: promFloat(v)})
+	}
+
+	return append(samples,
+		promSample{name: name + "_sum", labels: prometheusLabels(labels), value: promFloat(s.Sum)},
+		promSample{name: name + "_count", labels: prometheusLabels(labels), value: strconv.FormatUint(s.Count, 10)},
+	)
+}
+
+func promFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// prometheusName converts metric id to a valid Prometheus metric name
+func prometheusName(id string) string {
+	var sb strings.Builder
+	for i, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_', c == ':':
+			sb.WriteRune(c)
+		case c >= '0' && c <= '9':
+			if i == 0 {
+				sb.WriteRune('_')
+			}
+			sb.WriteRune(c)
+		default:
+			sb.WriteRune('_')
+		}
+	}
+	if sb.Len() == 0 {
+		return "_"
+	}
+
+	return sb.String()
+}
+
+// prometheusLabels renders labels as {name="value",...} with Prometheus escaping
+func prometheusLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(labels))
+	for _, name := range sortedKeys(labels) {
+		pairs = append(pairs, name+`="`+labelValueEscaper.Replace(labels[name])+`"`)
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mixailo/go-training-metrics/internal/repository/storage"
+	"github.com/mixailo/go-training-metrics/internal/service/metrics"
+)
+
+func Test_prometheusName(t *testing.T) {
+	tests := []struct {
+		id   string
+		want string
+	}{
+		{"Alloc", "Alloc"},
+		{"http_requests_total", "http_requests_total"},
+		{"job:latency", "job:latency"},
+		{"cpu.load-1m", "cpu_load_1m"},
+		{"1xx", "_1xx"},
+		{"метрика", "_______"},
+		{"", "_"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			assert.Equal(t, tt.want, prometheusName(tt.id))
+		})
+	}
+}
+
+func Test_storageAware_prometheusMetrics(t *testing.T) {
+	stor := storage.NewShardedStorage()
+	stor.UpdateGauge("Alloc", 1.5)
+	stor.UpdateGauge("cpu.load", 0.25)
+	stor.UpdateCounter("PollCount", 10)
+	stor.UpdateCounter("Alloc", 3)
+
+	server := httptest.NewServer(newMux(newStorageAware(stor)))
+	defer server.Close()
+
+	t.Run("text format", func(t *testing.T) {
+		resp, err := resty.New().R().Get(server.URL + "/metrics")
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode())
+		assert.Equal(t, prometheusContentType, resp.Header().Get("Content-Type"))
+		assert.Equal(t, "# TYPE Alloc gauge\n"+
+			"Alloc 1.5\n"+
+			"# TYPE PollCount counter\n"+
+			"PollCount 10\n"+
+			"# TYPE cpu_load gauge\n"+
+			"cpu_load 0.25\n", string(resp.Body()))
+	})
+
+	t.Run("openmetrics format", func(t *testing.T) {
+		resp, err := resty.New().R().
+			SetHeader("Accept", "application/openmetrics-text; version=1.0.0").
+			Get(server.URL + "/metrics")
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode())
+		assert.Equal(t, openMetricsContentType, resp.Header().Get("Content-Type"))
+		assert.Contains(t, string(resp.Body()), "# TYPE PollCount counter\nPollCount_total 10\n")
+		assert.Contains(t, string(resp.Body()), "# EOF\n")
+	})
+}
+
+func Test_storageAware_prometheusLabels(t *testing.T) {
+	stor := storage.NewShardedStorage()
+	stor.UpdateGauge(metrics.SeriesKey("Alloc", map[string]string{"host": "a"}), 1)
+	stor.UpdateGauge(metrics.SeriesKey("Alloc", map[string]string{"host": `b"\`}), 2)
+
+	server := httptest.NewServer(newMux(newStorageAware(stor)))
+	defer server.Close()
+
+	resp, err := resty.New().R().Get(server.URL + "/metrics")
+	require.NoError(t, err)
+
+	assert.Equal(t, "# TYPE Alloc gauge\n"+
+		"Alloc{host=\"a\"} 1\n"+
+		"Alloc{host=\"b\\\"\\\\\"} 2\n", string(resp.Body()))
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/mixailo/go-training-metrics/internal/service/logger"
+	"github.com/mixailo/go-training-metrics/internal/service/signature"
+)
+
+// signingWriter holds response until it is complete, so its signature is sent in header before body
+type signingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (sw *signingWriter) WriteHeader(statusCode int) {
+	sw.status = statusCode
+}
+
+func (sw *signingWriter) Write(p []byte) (int, error) {
+	return sw.body.Write(p)
+}
+
+// enableSigning makes server reject requests which are not signed with key and sign its responses
+func (sa *storageAware) enableSigning(key []byte) {
+	sa.signKey = key
+}
+
+// signed verifies signature of request body as it is received, before it is decompressed, and signs response body
+// as it is sent. Requests with body must be signed, only GET and HEAD requests may be sent without signature.
+func (sa *storageAware) signed(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if sa.signKey == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		hash := r.Header.Get(signature.Header)
+		if hash != "" || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+			body, err := io.ReadAll(r.Body)
+			r.Body.Close()
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if !signature.Verify(sa.signKey, body, hash) {
+				logger.Log.Warn("request signature mismatch", zap.String("uri", r.RequestURI), zap.String("remote", r.RemoteAddr))
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		sw := &signingWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+
+		w.Header().Set(signature.Header, signature.Sign(sa.signKey, sw.body.Bytes()))
+		w.WriteHeader(sw.status)
+		w.Write(sw.body.Bytes())
+	})
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mixailo/go-training-metrics/internal/repository/storage"
+	"github.com/mixailo/go-training-metrics/internal/service/signature"
+)
+
+func Test_storageAware_signed(t *testing.T) {
+	key := []byte("secret")
+	sa := newStorageAware(storage.NewShardedStorage())
+	sa.enableSigning(key)
+	server := httptest.NewServer(newMux(sa))
+	defer server.Close()
+
+	var gzipped bytes.Buffer
+	zw := gzip.NewWriter(&gzipped)
+	_, err := zw.Write([]byte(`{"id":"c","type":"counter","delta":5}`))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       []byte
+		hash       string
+		wantStatus int
+	}{
+		{
+			name:       "signed compressed update",
+			method:     http.MethodPost,
+			path:       "/update/",
+			body:       gzipped.Bytes(),
+			hash:       signature.Sign(key, gzipped.Bytes()),
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "unsigned update",
+			method:     http.MethodPost,
+			path:       "/update/",
+			body:       gzipped.Bytes(),
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "signed with other key",
+			method:     http.MethodPost,
+			path:       "/update/",
+			body:       gzipped.Bytes(),
+			hash:       signature.Sign([]byte("other"), gzipped.Bytes()),
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "unsigned read",
+			method:     http.MethodGet,
+			path:       "/value/counter/c",
+			wantStatus: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, server.URL+tt.path, bytes.NewReader(tt.body))
+			require.NoError(t, err)
+			if tt.body != nil {
+				req.Header.Set("Content-Type", "application/json")
+				req.Header.Set("Content-Encoding", "gzip")
+			}
+			// response is signed as it is sent, so it is not decompressed by transport
+			req.Header.Set("Accept-Encoding", "gzip")
+			if tt.hash != "" {
+				req.Header.Set(signature.Header, tt.hash)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+			if tt.wantStatus == http.StatusOK {
+				assert.True(t, signature.Verify(key, body, resp.Header.Get(signature.Header)), "response is signed")
+			}
+		})
+	}
+
+	c, _ := sa.stor.GetCounter("c")
+	assert.Equal(t, int64(5), c, "only signed update is applied")
+}
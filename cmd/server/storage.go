@@ -1,41 +1,104 @@
 package main
 
 import (
+	"context"
+	"crypto/rsa"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/go-chi/chi/v5"
+	"github.com/mixailo/go-training-metrics/internal/repository/history"
+	"github.com/mixailo/go-training-metrics/internal/repository/idempotency"
+	"github.com/mixailo/go-training-metrics/internal/repository/snapshot"
+	"github.com/mixailo/go-training-metrics/internal/service/alerting"
 	"github.com/mixailo/go-training-metrics/internal/service/logger"
 	"github.com/mixailo/go-training-metrics/internal/service/metrics"
 	"go.uber.org/zap"
+	"html"
 	"io"
+	"math"
 	"net/http"
-	"os"
 	"strconv"
+	"strings"
 )
 
 type metricsStorage interface {
-	UpdateGauge(name string, value float64)
-	UpdateCounter(name string, value int64)
+	UpdateGauge(name string, value float64) error
+	UpdateCounter(name string, value int64) error
 	GetGauge(name string) (val float64, ok bool)
 	GetCounter(name string) (val int64, ok bool)
 	Gauges() map[string]float64
 	Counters() map[string]int64
+	ObserveHistogram(name string, value float64, bounds []float64) error
+	MergeHistogram(name string, h metrics.Histogram) error
+	GetHistogram(name string) (val metrics.Histogram, ok bool)
+	Histograms() map[string]metrics.Histogram
+	ObserveSummary(name string, value float64) error
+	MergeSummary(name string, s metrics.Sketch) error
+	GetSummary(name string) (val metrics.Sketch, ok bool)
+	Summaries() map[string]metrics.Sketch
+	UpdateBatch(batch []metrics.Metrics) error
 
 	MarshalJSON() ([]byte, error)
 	UnmarshalJSON([]byte) error
 }
 
+// summaryQuantiles are rendered for summaries on html page and in Prometheus exposition
+var summaryQuantiles = []float64{0.5, 0.9, 0.99}
+
+// pinger is implemented by storages which depend on database connection
+type pinger interface {
+	Ping(ctx context.Context) error
+}
+
 type storageAware struct {
-	stor metricsStorage
+	stor   metricsStorage
+	db     pinger
+	wal    *loggingStorage
+	hist   *history.History
+	alerts *alerting.Engine
+	idem   *idempotency.Cache
+
+	signKey []byte
+	privKey *rsa.PrivateKey
+
+	backups  *snapshot.Backups
+	restored func() error
+	admin    *adminRequests
 }
 
 func newStorageAware(metricsStorage metricsStorage) *storageAware {
-	return &storageAware{stor: metricsStorage}
+	sa := &storageAware{stor: metricsStorage}
+	if db, ok := metricsStorage.(pinger); ok {
+		sa.db = db
+	}
+
+	return sa
+}
+
+// ping reports database connectivity, storage without database is always available
+func (sa *storageAware) ping(w http.ResponseWriter, r *http.Request) {
+	if sa.db != nil {
+		if err := sa.db.Ping(r.Context()); err != nil {
+			logger.Log.Error("database is not available", zap.Error(err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
 }
 
 func (sa *storageAware) updateItemValue(w http.ResponseWriter, r *http.Request) {
 	mName := chi.URLParam(r, "name")
 	mValue := chi.URLParam(r, "value")
 	mType := chi.URLParam(r, "type")
+	stor := sa.updateStorage(r)
+
+	if !metrics.IsValidID(mName) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
 
 	switch mType {
 	case metrics.TypeCounter.String():
@@ -46,8 +109,12 @@ func (sa *storageAware) updateItemValue(w http.ResponseWriter, r *http.Request)
 			return
 		}
 
+		if err = stor.UpdateCounter(mName, convertedValue); err != nil {
+			logger.Log.Error("error updating counter", zap.Error(err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
 		w.WriteHeader(http.StatusOK)
-		sa.stor.UpdateCounter(mName, convertedValue)
 	case metrics.TypeGauge.String():
 		// gauge type replaces stored value
 		convertedValue, err := strconv.ParseFloat(mValue, 64)
@@ -55,8 +122,43 @@ func (sa *storageAware) updateItemValue(w http.ResponseWriter, r *http.Request)
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
+		if err = stor.UpdateGauge(mName, convertedValue); err != nil {
+			logger.Log.Error("error updating gauge", zap.Error(err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	case metrics.TypeHistogram.String():
+		// histogram type observes the value, infinity and NaN would break its sum
+		convertedValue, err := strconv.ParseFloat(mValue, 64)
+		if err != nil || math.IsNaN(convertedValue) || math.IsInf(convertedValue, 0) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if err = stor.ObserveHistogram(mName, convertedValue, nil); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	case metrics.TypeSummary.String():
+		// summary type observes the value, infinity and NaN cannot be indexed
+		convertedValue, err := strconv.ParseFloat(mValue, 64)
+		if err != nil || math.IsNaN(convertedValue) || math.IsInf(convertedValue, 0) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		err = stor.ObserveSummary(mName, convertedValue)
+		if isIncompatibleError(err) {
+			// sum of the summary would overflow
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			logger.Log.Error("error updating summary", zap.Error(err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
 		w.WriteHeader(http.StatusOK)
-		sa.stor.UpdateGauge(mName, convertedValue)
 	default:
 		// unknown type
 		w.WriteHeader(http.StatusBadRequest)
@@ -82,27 +184,64 @@ func (sa *storageAware) value(w http.ResponseWriter, r *http.Request) {
 	}
 	switch reqData.MType {
 	case metrics.TypeCounter.String():
-		stored, ok := sa.stor.GetCounter(reqData.ID)
+		stored, ok := sa.stor.GetCounter(reqData.SeriesKey())
 		if !ok {
 			stored = 0
 		}
 		resData = metrics.Metrics{
-			ID:    reqData.ID,
-			MType: reqData.MType,
-			Delta: &stored,
+			ID:     reqData.ID,
+			MType:  reqData.MType,
+			Delta:  &stored,
+			Labels: reqData.Labels,
 		}
 	case metrics.TypeGauge.String():
-		stored, ok := sa.stor.GetGauge(reqData.ID)
+		stored, ok := sa.stor.GetGauge(reqData.SeriesKey())
 		if ok {
 			resData = metrics.Metrics{
-				ID:    reqData.ID,
-				MType: reqData.MType,
-				Value: &stored,
+				ID:     reqData.ID,
+				MType:  reqData.MType,
+				Value:  &stored,
+				Labels: reqData.Labels,
 			}
 		} else {
 			w.WriteHeader(http.StatusNotFound)
 			return
 		}
+	case metrics.TypeHistogram.String():
+		stored, ok := sa.stor.GetHistogram(reqData.SeriesKey())
+		if ok {
+			resData = metrics.Metrics{
+				ID:        reqData.ID,
+				MType:     reqData.MType,
+				Labels:    reqData.Labels,
+				Histogram: &stored,
+			}
+		} else {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+	case metrics.TypeSummary.String():
+		stored, ok := sa.stor.GetSummary(reqData.SeriesKey())
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		resData = metrics.Metrics{
+			ID:     reqData.ID,
+			MType:  reqData.MType,
+			Labels: reqData.Labels,
+		}
+		if reqData.Quantile == nil {
+			resData.Summary = &stored
+		} else {
+			v, err := stored.Quantile(*reqData.Quantile)
+			if err != nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			resData.Quantile = reqData.Quantile
+			resData.Value = &v
+		}
 	default:
 		// unknown type
 		logger.Log.Info("unknown type", zap.String("type", reqData.MType))
@@ -135,13 +274,55 @@ func (sa *storageAware) update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	stor := sa.updateStorage(r)
 	switch data.MType {
 	case metrics.TypeCounter.String():
 		// counter type increments stored value
-		sa.stor.UpdateCounter(data.ID, *data.Delta)
+		if err = stor.UpdateCounter(data.SeriesKey(), *data.Delta); err != nil {
+			logger.Log.Error("error updating counter", zap.Error(err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
 	case metrics.TypeGauge.String():
 		// gauge type updates stored value
-		sa.stor.UpdateGauge(data.ID, *data.Value)
+		if err = stor.UpdateGauge(data.SeriesKey(), *data.Value); err != nil {
+			logger.Log.Error("error updating gauge", zap.Error(err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	case metrics.TypeHistogram.String():
+		// histogram type observes the value or merges pre-bucketed histogram
+		if data.Value != nil {
+			var bounds []float64
+			if data.Histogram != nil {
+				bounds = data.Histogram.Bounds
+			}
+			err = stor.ObserveHistogram(data.SeriesKey(), *data.Value, bounds)
+		} else {
+			err = stor.MergeHistogram(data.SeriesKey(), *data.Histogram)
+		}
+		if err != nil {
+			logger.Log.Warn("error updating histogram", zap.Error(err))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	case metrics.TypeSummary.String():
+		// summary type observes the value or merges the sketch
+		if data.Value != nil {
+			err = stor.ObserveSummary(data.SeriesKey(), *data.Value)
+		} else {
+			err = stor.MergeSummary(data.SeriesKey(), *data.Summary)
+		}
+		if isIncompatibleError(err) {
+			logger.Log.Warn("error updating summary", zap.Error(err))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			logger.Log.Error("error updating summary", zap.Error(err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
 	default:
 		w.WriteHeader(http.StatusBadRequest)
 		return
@@ -153,6 +334,101 @@ func (sa *storageAware) update(w http.ResponseWriter, r *http.Request) {
 	enc.Encode(data)
 }
 
+type batchItemResult struct {
+	metrics.Metrics
+	Error string `json:"error,omitempty"`
+}
+
+func (sa *storageAware) updates(w http.ResponseWriter, r *http.Request) {
+	var batch []metrics.Metrics
+
+	w.Header().Set("Content-Type", "application/json")
+	dec := json.NewDecoder(r.Body)
+	defer r.Body.Close()
+	err := dec.Decode(&batch)
+
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		logger.Log.Warn("error decoding", zap.Error(err))
+		return
+	}
+
+	results := make([]batchItemResult, len(batch))
+	writable := true
+	for i, item := range batch {
+		results[i].Metrics = item
+		if !item.IsWritable() {
+			results[i].Error = "metric is not writable"
+			writable = false
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	if !writable {
+		// nothing is applied unless every item of the batch is valid
+		logger.Log.Warn("error batch not writable")
+		w.WriteHeader(http.StatusBadRequest)
+		enc.Encode(results)
+		return
+	}
+
+	err = sa.updateStorage(r).UpdateBatch(batch)
+	if isIncompatibleError(err) {
+		logger.Log.Warn("error updating batch", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		logger.Log.Error("error updating batch", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	for i, item := range batch {
+		results[i].Metrics = sa.stored(item)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	enc.Encode(results)
+}
+
+// isIncompatibleError checks if update failed because it does not fit stored histogram or summary
+func isIncompatibleError(err error) bool {
+	return errors.Is(err, metrics.ErrBucketsMismatch) ||
+		errors.Is(err, metrics.ErrInvalidValue) ||
+		errors.Is(err, metrics.ErrInvalidBuckets) ||
+		errors.Is(err, metrics.ErrInvalidCounts) ||
+		errors.Is(err, metrics.ErrSketchMismatch) ||
+		errors.Is(err, metrics.ErrInvalidSketch) ||
+		errors.Is(err, metrics.ErrInvalidAccuracy)
+}
+
+// stored returns current state of the metric identified by the given one
+func (sa *storageAware) stored(m metrics.Metrics) metrics.Metrics {
+	res := metrics.Metrics{ID: m.ID, MType: m.MType, Labels: m.Labels}
+
+	switch m.MType {
+	case metrics.TypeCounter.String():
+		if v, ok := sa.stor.GetCounter(m.SeriesKey()); ok {
+			res.Delta = &v
+		}
+	case metrics.TypeGauge.String():
+		if v, ok := sa.stor.GetGauge(m.SeriesKey()); ok {
+			res.Value = &v
+		}
+	case metrics.TypeHistogram.String():
+		if v, ok := sa.stor.GetHistogram(m.SeriesKey()); ok {
+			res.Histogram = &v
+		}
+	case metrics.TypeSummary.String():
+		if v, ok := sa.stor.GetSummary(m.SeriesKey()); ok {
+			res.Summary = &v
+		}
+	}
+
+	return res
+}
+
 func (sa *storageAware) getItemValue(w http.ResponseWriter, r *http.Request) {
 	mName := chi.URLParam(r, "name")
 	mType := chi.URLParam(r, "type")
@@ -178,6 +454,43 @@ func (sa *storageAware) getItemValue(w http.ResponseWriter, r *http.Request) {
 			w.WriteHeader(http.StatusNotFound)
 			return
 		}
+	case metrics.TypeHistogram.String():
+		v, ok := sa.stor.GetHistogram(mName)
+		if ok {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(v)
+			return
+		} else {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+	case metrics.TypeSummary.String():
+		// median unless other quantile is requested
+		q := 0.5
+		if qs := r.URL.Query().Get("quantile"); qs != "" {
+			var err error
+			q, err = strconv.ParseFloat(qs, 64)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+		s, ok := sa.stor.GetSummary(mName)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		v, err := s.Quantile(q)
+		if errors.Is(err, metrics.ErrInvalidQuantile) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		} else if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, strconv.FormatFloat(v, 'f', -1, 64))
 	}
 }
 
@@ -187,46 +500,99 @@ func (sa *storageAware) getAllValues(w http.ResponseWriter, r *http.Request) {
 	head := `<html><head><title>All Metrics</title></head><body><table>`
 	io.WriteString(w, head)
 	for k, v := range sa.stor.Gauges() {
-		io.WriteString(w, "<tr><td>Gauge</td><td>"+k+"</td><td>"+strconv.FormatFloat(v, 'f', -1, 64)+"</td></tr>")
+		io.WriteString(w, "<tr><td>Gauge</td>"+seriesCells(k)+"<td>"+strconv.FormatFloat(v, 'f', -1, 64)+"</td></tr>")
 	}
 	for k, v := range sa.stor.Counters() {
-		io.WriteString(w, "<tr><td>Counter</td><td>"+k+"</td><td>"+strconv.FormatInt(v, 10)+"</td></tr>")
+		io.WriteString(w, "<tr><td>Counter</td>"+seriesCells(k)+"<td>"+strconv.FormatInt(v, 10)+"</td></tr>")
+	}
+	for k, v := range sa.stor.Histograms() {
+		io.WriteString(w, "<tr><td>Histogram</td>"+seriesCells(k)+"<td>"+html.EscapeString(histogramText(v))+"</td></tr>")
+	}
+	for k, v := range sa.stor.Summaries() {
+		io.WriteString(w, "<tr><td>Summary</td>"+seriesCells(k)+"<td>"+html.EscapeString(summaryText(v))+"</td></tr>")
 	}
 	foot := `</table></body></html>`
 
 	io.WriteString(w, foot)
 }
 
-func (sa *storageAware) store(path string) error {
-	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
-	if err != nil {
-		return err
+// seriesCells renders metric name and labels of a series key as html table cells
+func seriesCells(key string) string {
+	id, labels := metrics.ParseSeriesKey(key)
+
+	pairs := make([]string, 0, len(labels))
+	for _, name := range sortedKeys(labels) {
+		pairs = append(pairs, name+"="+strconv.Quote(labels[name]))
+	}
+
+	return "<td>" + html.EscapeString(id) + "</td><td>" + html.EscapeString(strings.Join(pairs, ", ")) + "</td>"
+}
+
+// histogramText renders histogram as count, sum and cumulative bucket counts
+func histogramText(h metrics.Histogram) string {
+	var sb strings.Builder
+	sb.WriteString("count=" + strconv.FormatUint(h.Count, 10))
+	sb.WriteString(" sum=" + strconv.FormatFloat(h.Sum, 'f', -1, 64))
+	for i, c := range h.Cumulative() {
+		le := "+Inf"
+		if i < len(h.Bounds) {
+			le = strconv.FormatFloat(h.Bounds[i], 'f', -1, 64)
+		}
+		sb.WriteString(" le=" + le + ":" + strconv.FormatUint(c, 10))
 	}
-	defer file.Close()
 
-	encoder := json.NewEncoder(file)
-	err = encoder.Encode(sa.stor)
+	return sb.String()
+}
 
+// summaryText renders summary as count, sum and estimated quantiles
+func summaryText(s metrics.Sketch) string {
+	var sb strings.Builder
+	sb.WriteString("count=" + strconv.FormatUint(s.Count, 10))
+	sb.WriteString(" sum=" + strconv.FormatFloat(s.Sum, 'f', -1, 64))
+	for _, q := range summaryQuantiles {
+		v, err := s.Quantile(q)
+		if err != nil {
+			break
+		}
+		sb.WriteString(" q" + strconv.FormatFloat(q, 'f', -1, 64) + "=" + strconv.FormatFloat(v, 'f', -1, 64))
+	}
+
+	return sb.String()
+}
+
+func (sa *storageAware) store(path string) error {
+	data, err := sa.snapshotData(sa.stor)
 	if err != nil {
 		return err
 	}
+	if _, err = snapshot.Write(path, data, 0); err != nil {
+		return err
+	}
+
 	logger.Log.Debug("store", zap.String("path", path))
 	return nil
 }
 
-func (sa *storageAware) restore(path string) error {
-	file, err := os.OpenFile(path, os.O_RDONLY|os.O_CREATE, 0666)
+// restore loads storage from snapshot at path, legacy snapshot is rewritten in the current format.
+// It returns header of the snapshot, errors wrapping os.ErrNotExist mean there is no snapshot yet.
+func (sa *storageAware) restore(path string) (snapshot.Header, error) {
+	header, data, err := snapshot.Read(path)
 	if err != nil {
-		return err
+		return header, err
+	}
+	if err = sa.load(data); err != nil {
+		return header, err
 	}
-	defer file.Close()
 
-	decoder := json.NewDecoder(file)
-	err = decoder.Decode(&sa.stor)
-	if err != nil {
-		return err
+	if header.Version < snapshot.Version {
+		upgraded, err := snapshot.Write(path, data, header.WALSegment)
+		if err != nil {
+			return header, fmt.Errorf("cannot upgrade legacy snapshot: %w", err)
+		}
+		logger.Log.Info("legacy snapshot upgraded", zap.String("path", path), zap.Int("version", upgraded.Version))
+		header = upgraded
 	}
 
-	logger.Log.Debug("restore", zap.String("path", path), zap.Int("len gauges", len(sa.stor.Gauges())), zap.Int("len counters", len(sa.stor.Counters())))
-	return nil
+	logger.Log.Debug("restore", zap.String("path", path), zap.Int("len gauges", len(sa.stor.Gauges())), zap.Int("len counters", len(sa.stor.Counters())), zap.Int("len histograms", len(sa.stor.Histograms())), zap.Int("len summaries", len(sa.stor.Summaries())))
+	return header, nil
 }
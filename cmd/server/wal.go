@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/mixailo/go-training-metrics/internal/repository/idempotency"
+	"github.com/mixailo/go-training-metrics/internal/repository/snapshot"
+	"github.com/mixailo/go-training-metrics/internal/repository/wal"
+	"github.com/mixailo/go-training-metrics/internal/service/logger"
+	"github.com/mixailo/go-training-metrics/internal/service/metrics"
+)
+
+// loggingStorage appends every successful update to write-ahead log before it is acknowledged.
+// Concurrent updates of the same gauge may be logged in order different from the applied one,
+// as the order of concurrent requests is not defined anyway.
+type loggingStorage struct {
+	metricsStorage
+	log  *wal.Log
+	idem *idempotency.Cache
+
+	// agent and key identify request of updates logged by storage returned by keyed
+	agent, key string
+
+	// updates hold it shared, compaction holds it exclusively to cut snapshot and log at the same point
+	mu *sync.RWMutex
+}
+
+func newLoggingStorage(stor metricsStorage, log *wal.Log) *loggingStorage {
+	return &loggingStorage{metricsStorage: stor, log: log, mu: &sync.RWMutex{}}
+}
+
+// keyed returns storage logging updates along with idempotency key of the request. The key is remembered as applied
+// under the same lock as the update, so compaction captures both of them or neither.
+func (ls *loggingStorage) keyed(agent, key string) metricsStorage {
+	if ls.idem == nil {
+		return ls
+	}
+
+	k := *ls
+	k.agent, k.key = agent, key
+
+	return &k
+}
+
+func (ls *loggingStorage) UpdateGauge(name string, value float64) error {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+
+	if err := ls.metricsStorage.UpdateGauge(name, value); err != nil {
+		return err
+	}
+
+	return ls.record(wal.Entry{Op: wal.OpGauge, Key: name, Value: &value})
+}
+
+func (ls *loggingStorage) UpdateCounter(name string, value int64) error {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+
+	if err := ls.metricsStorage.UpdateCounter(name, value); err != nil {
+		return err
+	}
+
+	return ls.record(wal.Entry{Op: wal.OpCounter, Key: name, Delta: &value})
+}
+
+func (ls *loggingStorage) ObserveHistogram(name string, value float64, bounds []float64) error {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+
+	if err := ls.metricsStorage.ObserveHistogram(name, value, bounds); err != nil {
+		return err
+	}
+
+	return ls.record(wal.Entry{Op: wal.OpObserveHistogram, Key: name, Value: &value, Bounds: bounds})
+}
+
+func (ls *loggingStorage) MergeHistogram(name string, h metrics.Histogram) error {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+
+	if err := ls.metricsStorage.MergeHistogram(name, h); err != nil {
+		return err
+	}
+
+	return ls.record(wal.Entry{Op: wal.OpMergeHistogram, Key: name, Histogram: &h})
+}
+
+func (ls *loggingStorage) ObserveSummary(name string, value float64) error {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+
+	if err := ls.metricsStorage.ObserveSummary(name, value); err != nil {
+		return err
+	}
+
+	return ls.record(wal.Entry{Op: wal.OpObserveSummary, Key: name, Value: &value})
+}
+
+func (ls *loggingStorage) MergeSummary(name string, s metrics.Sketch) error {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+
+	if err := ls.metricsStorage.MergeSummary(name, s); err != nil {
+		return err
+	}
+
+	return ls.record(wal.Entry{Op: wal.OpMergeSummary, Key: name, Summary: &s})
+}
+
+func (ls *loggingStorage) UpdateBatch(batch []metrics.Metrics) error {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+
+	if err := ls.metricsStorage.UpdateBatch(batch); err != nil {
+		return err
+	}
+
+	return ls.record(wal.Entry{Op: wal.OpBatch, Batch: batch})
+}
+
+// record logs entry of applied update, idempotency key of the update is logged and remembered along with it
+func (ls *loggingStorage) record(entry wal.Entry) error {
+	if ls.key != "" {
+		entry.Agent, entry.IdempotencyKey = ls.agent, ls.key
+		ls.idem.Applied(ls.agent, ls.key)
+	}
+
+	return ls.log.Append(entry)
+}
+
+// apply replays logged entries on the wrapped storage, idempotency keys of them are remembered as applied
+func (ls *loggingStorage) apply(entries []wal.Entry) error {
+	for _, e := range entries {
+		var err error
+		switch e.Op {
+		case wal.OpGauge:
+			err = ls.metricsStorage.UpdateGauge(e.Key, *e.Value)
+		case wal.OpCounter:
+			err = ls.metricsStorage.UpdateCounter(e.Key, *e.Delta)
+		case wal.OpObserveHistogram:
+			err = ls.metricsStorage.ObserveHistogram(e.Key, *e.Value, e.Bounds)
+		case wal.OpMergeHistogram:
+			err = ls.metricsStorage.MergeHistogram(e.Key, *e.Histogram)
+		case wal.OpObserveSummary:
+			err = ls.metricsStorage.ObserveSummary(e.Key, *e.Value)
+		case wal.OpMergeSummary:
+			err = ls.metricsStorage.MergeSummary(e.Key, *e.Summary)
+		case wal.OpBatch:
+			err = ls.metricsStorage.UpdateBatch(e.Batch)
+		default:
+			err = fmt.Errorf("unknown operation %s", e.Op)
+		}
+		if err != nil {
+			return err
+		}
+		if e.IdempotencyKey != "" && ls.idem != nil {
+			ls.idem.Applied(e.Agent, e.IdempotencyKey)
+		}
+	}
+
+	return nil
+}
+
+// enableWAL makes storage log updates to be replayed after restart
+func (sa *storageAware) enableWAL(log *wal.Log) {
+	sa.wal = newLoggingStorage(sa.stor, log)
+	sa.wal.idem = sa.idem
+	sa.stor = sa.wal
+}
+
+// replay applies log records which are not covered by the restored snapshot
+func (sa *storageAware) replay(dir string, covered uint64) error {
+	records, err := wal.Replay(dir, covered, sa.wal.apply)
+	logger.Log.Info("write-ahead log replayed", zap.Uint64("after segment", covered), zap.Int("records", records))
+
+	return err
+}
+
+// compact writes snapshot of storage to path and removes log segments it covers
+func (sa *storageAware) compact(path string) error {
+	sa.wal.mu.Lock()
+	data, err := sa.snapshotData(sa.wal.metricsStorage)
+	var segment uint64
+	if err == nil {
+		segment, err = sa.wal.log.Rotate()
+	}
+	sa.wal.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// log segments are removed only when snapshot replacing them is completely written
+	if _, err = snapshot.Write(path, data, segment); err != nil {
+		return err
+	}
+	logger.Log.Debug("compact", zap.String("path", path), zap.Uint64("segment", segment))
+
+	return sa.wal.log.Remove(segment)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mixailo/go-training-metrics/internal/repository/storage"
+	"github.com/mixailo/go-training-metrics/internal/repository/wal"
+	"github.com/mixailo/go-training-metrics/internal/service/metrics"
+)
+
+func newWALStorageAware(t *testing.T, dir string) *storageAware {
+	t.Helper()
+
+	log, err := wal.Open(dir, wal.SyncAlways)
+	require.NoError(t, err)
+	t.Cleanup(func() { log.Close() })
+
+	sa := newStorageAware(storage.NewShardedStorage())
+	sa.enableWAL(log)
+
+	return sa
+}
+
+func Test_storageAware_wal(t *testing.T) {
+	dir := t.TempDir()
+	walDir := filepath.Join(dir, "wal")
+	path := filepath.Join(dir, "values.json")
+
+	delta := int64(10)
+	sa := newWALStorageAware(t, walDir)
+	sa.stor.UpdateCounter("c", 1)
+	sa.stor.UpdateGauge("g", 1)
+	require.NoError(t, sa.stor.ObserveHistogram("h", 0.3, []float64{0.5}))
+	sa.stor.ObserveSummary("s", 1)
+
+	restored := func(t *testing.T) *storageAware {
+		restored := newWALStorageAware(t, walDir)
+		header, err := restored.restore(path)
+		if !errors.Is(err, os.ErrNotExist) {
+			require.NoError(t, err)
+		}
+		require.NoError(t, restored.replay(walDir, header.WALSegment))
+		return restored
+	}
+
+	t.Run("crash before compaction", func(t *testing.T) {
+		r := restored(t)
+		counter, _ := r.stor.GetCounter("c")
+		assert.Equal(t, int64(1), counter)
+		h, ok := r.stor.GetHistogram("h")
+		require.True(t, ok)
+		assert.Equal(t, uint64(1), h.Count)
+	})
+
+	require.NoError(t, sa.compact(path))
+	sa.stor.UpdateCounter("c", 2)
+	sa.stor.UpdateGauge("g", 2)
+	require.NoError(t, sa.stor.UpdateBatch([]metrics.Metrics{
+		{ID: "c", MType: metrics.TypeCounter.String(), Delta: &delta},
+	}))
+
+	t.Run("snapshot and log after it", func(t *testing.T) {
+		r := restored(t)
+		counter, _ := r.stor.GetCounter("c")
+		assert.Equal(t, int64(13), counter)
+		gauge, _ := r.stor.GetGauge("g")
+		assert.Equal(t, 2.0, gauge)
+		s, ok := r.stor.GetSummary("s")
+		require.True(t, ok)
+		assert.Equal(t, uint64(1), s.Count)
+	})
+
+	t.Run("compaction removes covered segments", func(t *testing.T) {
+		require.NoError(t, sa.compact(path))
+		segments, err := wal.Segments(walDir)
+		require.NoError(t, err)
+		for _, segment := range segments {
+			assert.Greater(t, segment, sa.wal.log.Segment()-1)
+		}
+
+		r := restored(t)
+		counter, _ := r.stor.GetCounter("c")
+		assert.Equal(t, int64(13), counter)
+	})
+}
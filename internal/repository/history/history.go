@@ -0,0 +1,152 @@
+package history
+
+import (
+	"sync"
+	"time"
+)
+
+// Sample is a value of a series at a moment
+type Sample struct {
+	Time  time.Time `json:"t"`
+	Value float64   `json:"v"`
+}
+
+// ring keeps the latest samples of a series in insertion order
+type ring struct {
+	samples []Sample
+	head    int // index of the oldest sample
+	size    int
+}
+
+func newRing(capacity int) *ring {
+	return &ring{samples: make([]Sample, capacity)}
+}
+
+func (r *ring) push(s Sample) {
+	if r.size < len(r.samples) {
+		r.samples[(r.head+r.size)%len(r.samples)] = s
+		r.size++
+		return
+	}
+	// buffer is full, overwrite the oldest sample
+	r.samples[r.head] = s
+	r.head = (r.head + 1) % len(r.samples)
+}
+
+func (r *ring) at(i int) Sample {
+	return r.samples[(r.head+i)%len(r.samples)]
+}
+
+// dropBefore removes samples older than t
+func (r *ring) dropBefore(t time.Time) {
+	for r.size > 0 && r.at(0).Time.Before(t) {
+		r.head = (r.head + 1) % len(r.samples)
+		r.size--
+	}
+}
+
+// History keeps timestamped samples per series, bounded both by count and by age
+type History struct {
+	mu         sync.RWMutex
+	series     map[string]*ring
+	maxSamples int
+	maxAge     time.Duration
+	now        func() time.Time
+}
+
+// New creates history keeping at most maxSamples samples per series not older than maxAge,
+// zero maxAge means samples are limited by count only
+func New(maxSamples int, maxAge time.Duration) *History {
+	if maxSamples < 1 {
+		maxSamples = 1
+	}
+
+	return &History{
+		series:     make(map[string]*ring),
+		maxSamples: maxSamples,
+		maxAge:     maxAge,
+		now:        time.Now,
+	}
+}
+
+// Key identifies series of the given metric type, as different types share names
+func Key(mType string, seriesKey string) string {
+	return mType + ":" + seriesKey
+}
+
+// Record appends current value of the series
+func (h *History) Record(key string, value float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := h.now()
+	r, ok := h.series[key]
+	if !ok {
+		r = newRing(h.maxSamples)
+		h.series[key] = r
+	}
+	r.push(Sample{Time: now, Value: value})
+	if h.maxAge > 0 {
+		r.dropBefore(now.Add(-h.maxAge))
+	}
+}
+
+// Range returns samples of the series recorded within [from, to]
+func (h *History) Range(key string, from, to time.Time) []Sample {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	result := make([]Sample, 0)
+	r, ok := h.series[key]
+	if !ok {
+		return result
+	}
+
+	if h.maxAge > 0 {
+		if oldest := h.now().Add(-h.maxAge); from.Before(oldest) {
+			from = oldest
+		}
+	}
+	for i := 0; i < r.size; i++ {
+		s := r.at(i)
+		if s.Time.Before(from) {
+			continue
+		}
+		if s.Time.After(to) {
+			break
+		}
+		result = append(result, s)
+	}
+
+	return result
+}
+
+// Has checks if any samples of the series were recorded
+func (h *History) Has(key string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	_, ok := h.series[key]
+	return ok
+}
+
+// Align returns series values at from, from+step, ... up to to. Value at a moment is the value
+// of the latest sample at or before it, moments preceding the first sample are skipped.
+func Align(samples []Sample, from, to time.Time, step time.Duration) []Sample {
+	result := make([]Sample, 0)
+	if step <= 0 {
+		return result
+	}
+
+	i := -1
+	for t := from; !t.After(to); t = t.Add(step) {
+		for i+1 < len(samples) && !samples[i+1].Time.After(t) {
+			i++
+		}
+		if i >= 0 {
+			result = append(result, Sample{Time: t, Value: samples[i].Value})
+		}
+	}
+
+	return result
+}
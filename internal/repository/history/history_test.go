@@ -0,0 +1,93 @@
+package history
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func newTestHistory(maxSamples int, maxAge time.Duration) (*History, *fakeClock) {
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	h := New(maxSamples, maxAge)
+	h.now = clock.now
+
+	return h, clock
+}
+
+func TestHistory_RetentionByCount(t *testing.T) {
+	h, clock := newTestHistory(3, 0)
+	for i := 1; i <= 5; i++ {
+		h.Record("gauge:g", float64(i))
+		clock.t = clock.t.Add(time.Second)
+	}
+
+	samples := h.Range("gauge:g", time.Time{}, clock.t)
+	assert.Equal(t, []Sample{
+		{Time: time.Unix(1002, 0), Value: 3},
+		{Time: time.Unix(1003, 0), Value: 4},
+		{Time: time.Unix(1004, 0), Value: 5},
+	}, samples)
+}
+
+func TestHistory_RetentionByAge(t *testing.T) {
+	h, clock := newTestHistory(100, 10*time.Second)
+	for i := 0; i < 20; i++ {
+		h.Record("gauge:g", float64(i))
+		clock.t = clock.t.Add(time.Second)
+	}
+
+	// now is 1020, samples at 1010..1019 are kept
+	samples := h.Range("gauge:g", time.Time{}, clock.t)
+	assert.Len(t, samples, 10)
+	assert.Equal(t, 10.0, samples[0].Value)
+
+	// samples expire even without new records
+	clock.t = clock.t.Add(5 * time.Second)
+	assert.Len(t, h.Range("gauge:g", time.Time{}, clock.t), 5)
+}
+
+func TestHistory_Range(t *testing.T) {
+	h, clock := newTestHistory(100, 0)
+	for i := 0; i < 10; i++ {
+		h.Record("gauge:g", float64(i))
+		clock.t = clock.t.Add(time.Second)
+	}
+
+	samples := h.Range("gauge:g", time.Unix(1003, 0), time.Unix(1005, 0))
+	assert.Equal(t, []Sample{
+		{Time: time.Unix(1003, 0), Value: 3},
+		{Time: time.Unix(1004, 0), Value: 4},
+		{Time: time.Unix(1005, 0), Value: 5},
+	}, samples)
+
+	assert.Empty(t, h.Range("gauge:none", time.Time{}, clock.t))
+	assert.False(t, h.Has("gauge:none"))
+	assert.True(t, h.Has("gauge:g"))
+}
+
+func TestAlign(t *testing.T) {
+	samples := []Sample{
+		{Time: time.Unix(10, 0), Value: 1},
+		{Time: time.Unix(12, 0), Value: 2},
+		{Time: time.Unix(13, 0), Value: 3},
+	}
+
+	got := Align(samples, time.Unix(8, 0), time.Unix(16, 0), 2*time.Second)
+	assert.Equal(t, []Sample{
+		{Time: time.Unix(10, 0), Value: 1},
+		{Time: time.Unix(12, 0), Value: 2},
+		{Time: time.Unix(14, 0), Value: 3},
+		{Time: time.Unix(16, 0), Value: 3},
+	}, got)
+
+	assert.Empty(t, Align(samples, time.Unix(8, 0), time.Unix(16, 0), 0))
+}
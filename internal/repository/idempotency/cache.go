@@ -0,0 +1,218 @@
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// Result is a response to the request which is returned again for its duplicates
+type Result struct {
+	Status      int    `json:"status"`
+	ContentType string `json:"contentType,omitempty"`
+	Body        []byte `json:"body,omitempty"`
+}
+
+// keyed is a result of request kept in snapshot
+type keyed struct {
+	Key string `json:"key"`
+	Result
+}
+
+type agentKeys struct {
+	results map[string]Result
+	order   []string // keys of results, the oldest first
+	used    uint64   // tick of the last access, the least recently used agent is evicted
+}
+
+type flight struct {
+	done chan struct{}
+}
+
+// Cache remembers results of recently applied requests by their idempotency keys. It keeps at most perAgent keys
+// of every agent and at most maxAgents agents, the oldest keys and the least recently seen agents are evicted.
+type Cache struct {
+	perAgent  int
+	maxAgents int
+
+	mu       sync.Mutex
+	agents   map[string]*agentKeys
+	inflight map[[2]string]*flight // requests being applied by agent and key
+	tick     uint64
+}
+
+// New returns empty cache, zero maxAgents means no limit of agents
+func New(perAgent, maxAgents int) *Cache {
+	return &Cache{
+		perAgent:  perAgent,
+		maxAgents: maxAgents,
+		agents:    make(map[string]*agentKeys),
+		inflight:  make(map[[2]string]*flight),
+	}
+}
+
+// Begin returns result of already applied request with the key. Otherwise the request is reserved
+// and the caller must Finish it, concurrent duplicates wait for it until ctx is done.
+func (c *Cache) Begin(ctx context.Context, agent, key string) (Result, bool, error) {
+	id := [2]string{agent, key}
+	for {
+		c.mu.Lock()
+		// request applied but not finished yet is waited for, so its duplicates get the original result
+		f, busy := c.inflight[id]
+		if !busy {
+			if res, ok := c.lookup(agent, key); ok {
+				c.mu.Unlock()
+				return res, true, nil
+			}
+			c.inflight[id] = &flight{done: make(chan struct{})}
+			c.mu.Unlock()
+			return Result{}, false, nil
+		}
+		c.mu.Unlock()
+
+		select {
+		case <-f.done:
+			// the result is cached unless the request failed, then this one is applied
+		case <-ctx.Done():
+			return Result{}, false, ctx.Err()
+		}
+	}
+}
+
+// Finish releases request reserved by Begin, result is remembered only if the request is applied,
+// otherwise its duplicate is applied again
+func (c *Cache) Finish(agent, key string, res Result, applied bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	id := [2]string{agent, key}
+	if applied {
+		c.put(agent, key, res)
+	}
+	if f, ok := c.inflight[id]; ok {
+		delete(c.inflight, id)
+		close(f.done)
+	}
+}
+
+// Applied remembers key of request which is applied but not finished yet, so snapshot taken meanwhile keeps the key
+// along with the update. Until Finish stores the result, and for keys replayed from log, the result is an empty
+// successful response.
+func (c *Cache) Applied(agent, key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.put(agent, key, Result{Status: http.StatusOK})
+}
+
+// Len returns number of remembered results
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := 0
+	for _, a := range c.agents {
+		n += len(a.order)
+	}
+
+	return n
+}
+
+func (c *Cache) lookup(agent, key string) (Result, bool) {
+	a, ok := c.agents[agent]
+	if !ok {
+		return Result{}, false
+	}
+	res, ok := a.results[key]
+	if ok {
+		c.tick++
+		a.used = c.tick
+	}
+
+	return res, ok
+}
+
+func (c *Cache) put(agent, key string, res Result) {
+	if c.perAgent <= 0 {
+		return
+	}
+
+	a, ok := c.agents[agent]
+	if !ok {
+		a = &agentKeys{results: make(map[string]Result)}
+		c.agents[agent] = a
+	}
+	c.tick++
+	a.used = c.tick
+
+	if _, ok = a.results[key]; !ok {
+		a.order = append(a.order, key)
+	}
+	a.results[key] = res
+	for len(a.order) > c.perAgent {
+		delete(a.results, a.order[0])
+		a.order = a.order[1:]
+	}
+
+	if c.maxAgents > 0 && len(c.agents) > c.maxAgents {
+		c.evictAgent()
+	}
+}
+
+// evictAgent removes keys of the least recently seen agent
+func (c *Cache) evictAgent() {
+	var (
+		oldest string
+		used   uint64
+	)
+	for name, a := range c.agents {
+		if oldest == "" || a.used < used {
+			oldest, used = name, a.used
+		}
+	}
+	delete(c.agents, oldest)
+}
+
+// MarshalJSON encodes remembered results of every agent, the oldest first
+func (c *Cache) MarshalJSON() ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	encoded := make(map[string][]keyed, len(c.agents))
+	for name, a := range c.agents {
+		results := make([]keyed, 0, len(a.order))
+		for _, key := range a.order {
+			results = append(results, keyed{Key: key, Result: a.results[key]})
+		}
+		encoded[name] = results
+	}
+
+	return json.Marshal(encoded)
+}
+
+// UnmarshalJSON replaces remembered results with decoded ones, limits of the cache are applied to them
+func (c *Cache) UnmarshalJSON(data []byte) error {
+	var decoded map[string][]keyed
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.agents = make(map[string]*agentKeys)
+	names := make([]string, 0, len(decoded))
+	for name := range decoded {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, k := range decoded[name] {
+			c.put(name, k.Key, k.Result)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,144 @@
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func applied(t *testing.T, c *Cache, agent, key string, status int) {
+	t.Helper()
+
+	_, found, err := c.Begin(context.Background(), agent, key)
+	require.NoError(t, err)
+	require.False(t, found)
+	c.Finish(agent, key, Result{Status: status, Body: []byte(key)}, true)
+}
+
+func TestCache_Begin(t *testing.T) {
+	c := New(10, 0)
+	applied(t, c, "a", "1", 200)
+
+	res, found, err := c.Begin(context.Background(), "a", "1")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, Result{Status: 200, Body: []byte("1")}, res)
+
+	// keys of agents are independent
+	_, found, err = c.Begin(context.Background(), "b", "1")
+	require.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestCache_NotApplied(t *testing.T) {
+	c := New(10, 0)
+	_, found, err := c.Begin(context.Background(), "a", "1")
+	require.NoError(t, err)
+	require.False(t, found)
+	c.Finish("a", "1", Result{Status: 500}, false)
+
+	_, found, err = c.Begin(context.Background(), "a", "1")
+	require.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestCache_Applied(t *testing.T) {
+	c := New(10, 0)
+	_, found, err := c.Begin(context.Background(), "a", "1")
+	require.NoError(t, err)
+	require.False(t, found)
+	c.Applied("a", "1")
+	assert.Equal(t, 1, c.Len())
+
+	// duplicate waits for the result of applied request
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, _, err = c.Begin(ctx, "a", "1")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	c.Finish("a", "1", Result{Status: 500}, false)
+	res, found, err := c.Begin(context.Background(), "a", "1")
+	require.NoError(t, err)
+	assert.True(t, found, "applied key is kept though request failed")
+	assert.Equal(t, Result{Status: 200}, res)
+}
+
+func TestCache_Concurrent(t *testing.T) {
+	c := New(10, 0)
+	_, found, err := c.Begin(context.Background(), "a", "1")
+	require.NoError(t, err)
+	require.False(t, found)
+
+	type begun struct {
+		res   Result
+		found bool
+	}
+	duplicate := make(chan begun)
+	go func() {
+		res, found, _ := c.Begin(context.Background(), "a", "1")
+		duplicate <- begun{res, found}
+	}()
+
+	select {
+	case <-duplicate:
+		t.Fatal("duplicate is not waiting for the original request")
+	case <-time.After(50 * time.Millisecond):
+	}
+	c.Finish("a", "1", Result{Status: 200}, true)
+	d := <-duplicate
+	assert.True(t, d.found)
+	assert.Equal(t, 200, d.res.Status)
+
+	// waiting is interrupted with context
+	_, _, err = c.Begin(context.Background(), "a", "2")
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, _, err = c.Begin(ctx, "a", "2")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestCache_Limits(t *testing.T) {
+	c := New(2, 2)
+	applied(t, c, "a", "1", 200)
+	applied(t, c, "a", "2", 200)
+	applied(t, c, "a", "3", 200)
+	assert.Equal(t, 2, c.Len())
+
+	_, found, _ := c.Begin(context.Background(), "a", "1")
+	assert.False(t, found, "the oldest key is evicted")
+	c.Finish("a", "1", Result{}, false)
+
+	applied(t, c, "b", "1", 200)
+	// agent a is seen after b
+	_, found, _ = c.Begin(context.Background(), "a", "3")
+	require.True(t, found)
+	applied(t, c, "c", "1", 200)
+
+	_, found, _ = c.Begin(context.Background(), "b", "1")
+	assert.False(t, found, "the least recently seen agent is evicted")
+	_, found, _ = c.Begin(context.Background(), "a", "3")
+	assert.True(t, found)
+}
+
+func TestCache_JSON(t *testing.T) {
+	c := New(10, 0)
+	applied(t, c, "a", "1", 200)
+	applied(t, c, "a", "2", 400)
+	applied(t, c, "b", "1", 200)
+
+	data, err := json.Marshal(c)
+	require.NoError(t, err)
+
+	restored := New(1, 0)
+	require.NoError(t, json.Unmarshal(data, restored))
+	assert.Equal(t, 2, restored.Len())
+	res, found, err := restored.Begin(context.Background(), "a", "2")
+	require.NoError(t, err)
+	require.True(t, found, "the latest key is kept")
+	assert.Equal(t, Result{Status: 400, Body: []byte("2")}, res)
+}
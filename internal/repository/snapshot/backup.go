@@ -0,0 +1,141 @@
+package snapshot
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var ErrBackupNotFound = errors.New("backup not found")
+
+const (
+	backupPrefix     = "snapshot-"
+	backupExt        = ".json"
+	backupTimeFormat = "20060102T150405.000000000Z"
+)
+
+// Backup is a snapshot kept in backup directory
+type Backup struct {
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+	Size      int64     `json:"size"`
+}
+
+// Backups keeps timestamped snapshots in a directory, the oldest ones are removed
+// when there are more than keep of them or they are older than maxAge.
+// The latest backup is never removed.
+type Backups struct {
+	dir    string
+	keep   int           // zero means no limit
+	maxAge time.Duration // zero means no limit
+	now    func() time.Time
+}
+
+func NewBackups(dir string, keep int, maxAge time.Duration) (*Backups, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &Backups{dir: dir, keep: keep, maxAge: maxAge, now: time.Now}, nil
+}
+
+// Save writes storage data as a new backup and removes backups which are out of retention
+func (b *Backups) Save(data []byte) (Backup, error) {
+	createdAt := b.now().UTC()
+	name := backupPrefix + createdAt.Format(backupTimeFormat) + backupExt
+	if _, err := Write(filepath.Join(b.dir, name), data, 0); err != nil {
+		return Backup{}, err
+	}
+
+	backup, err := b.backup(name)
+	if err != nil {
+		return backup, err
+	}
+
+	return backup, b.Prune()
+}
+
+// List returns backups, the latest first
+func (b *Backups) List() ([]Backup, error) {
+	files, err := os.ReadDir(b.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	backups := make([]Backup, 0, len(files))
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		backup, err := b.backup(f.Name())
+		if errors.Is(err, ErrBackupNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		backups = append(backups, backup)
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].CreatedAt.After(backups[j].CreatedAt)
+	})
+
+	return backups, nil
+}
+
+// Prune removes backups which are out of retention
+func (b *Backups) Prune() error {
+	backups, err := b.List()
+	if err != nil {
+		return err
+	}
+
+	now := b.now()
+	for i, backup := range backups {
+		if i == 0 {
+			continue
+		}
+		tooMany := b.keep > 0 && i >= b.keep
+		tooOld := b.maxAge > 0 && now.Sub(backup.CreatedAt) > b.maxAge
+		if tooMany || tooOld {
+			if err = os.Remove(filepath.Join(b.dir, backup.Name)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Path returns path to the backup with given name
+func (b *Backups) Path(name string) (string, error) {
+	if _, err := b.backup(name); err != nil {
+		return "", err
+	}
+
+	return filepath.Join(b.dir, name), nil
+}
+
+// backup describes file of backup directory, names of other files are reported as ErrBackupNotFound
+func (b *Backups) backup(name string) (Backup, error) {
+	if name != filepath.Base(name) || !strings.HasPrefix(name, backupPrefix) || !strings.HasSuffix(name, backupExt) {
+		return Backup{}, ErrBackupNotFound
+	}
+	createdAt, err := time.Parse(backupTimeFormat, strings.TrimSuffix(strings.TrimPrefix(name, backupPrefix), backupExt))
+	if err != nil {
+		return Backup{}, ErrBackupNotFound
+	}
+
+	info, err := os.Stat(filepath.Join(b.dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return Backup{}, ErrBackupNotFound
+	}
+	if err != nil {
+		return Backup{}, err
+	}
+
+	return Backup{Name: name, CreatedAt: createdAt, Size: info.Size()}, nil
+}
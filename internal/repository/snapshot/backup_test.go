@@ -0,0 +1,110 @@
+package snapshot
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func newTestBackups(t *testing.T, keep int, maxAge time.Duration) (*Backups, *fakeClock) {
+	t.Helper()
+
+	b, err := NewBackups(filepath.Join(t.TempDir(), "backups"), keep, maxAge)
+	require.NoError(t, err)
+	clock := &fakeClock{t: time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC)}
+	b.now = clock.now
+
+	return b, clock
+}
+
+func names(backups []Backup) []string {
+	result := make([]string, 0, len(backups))
+	for _, b := range backups {
+		result = append(result, b.Name)
+	}
+
+	return result
+}
+
+func TestBackups_Save(t *testing.T) {
+	b, clock := newTestBackups(t, 0, 0)
+
+	first, err := b.Save([]byte(data))
+	require.NoError(t, err)
+	assert.Equal(t, "snapshot-20241001T120000.000000000Z.json", first.Name)
+	assert.Equal(t, clock.t, first.CreatedAt)
+	assert.Positive(t, first.Size)
+
+	clock.t = clock.t.Add(time.Minute)
+	second, err := b.Save([]byte(data))
+	require.NoError(t, err)
+
+	list, err := b.List()
+	require.NoError(t, err)
+	assert.Equal(t, []string{second.Name, first.Name}, names(list))
+
+	path, err := b.Path(first.Name)
+	require.NoError(t, err)
+	_, restored, err := Read(path)
+	require.NoError(t, err)
+	assert.JSONEq(t, data, string(restored))
+}
+
+func TestBackups_Retention(t *testing.T) {
+	tests := []struct {
+		name   string
+		keep   int
+		maxAge time.Duration
+		want   int
+	}{
+		{name: "unlimited", want: 5},
+		{name: "by count", keep: 2, want: 2},
+		{name: "by age", maxAge: 150 * time.Minute, want: 3},
+		{name: "by count and age", keep: 2, maxAge: 150 * time.Minute, want: 2},
+		{name: "the latest is kept", maxAge: time.Second, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, clock := newTestBackups(t, tt.keep, tt.maxAge)
+			for i := 0; i < 5; i++ {
+				_, err := b.Save([]byte(data))
+				require.NoError(t, err)
+				clock.t = clock.t.Add(time.Hour)
+			}
+
+			list, err := b.List()
+			require.NoError(t, err)
+			assert.Len(t, list, tt.want)
+			assert.Equal(t, "snapshot-20241001T160000.000000000Z.json", list[0].Name)
+		})
+	}
+}
+
+func TestBackups_Path(t *testing.T) {
+	b, _ := newTestBackups(t, 0, 0)
+	backup, err := b.Save([]byte(data))
+	require.NoError(t, err)
+
+	_, err = b.Path(backup.Name)
+	assert.NoError(t, err)
+
+	for _, name := range []string{
+		"snapshot-20201001T120000.000000000Z.json",
+		"../" + backup.Name,
+		"values.json",
+	} {
+		_, err = b.Path(name)
+		assert.ErrorIs(t, err, ErrBackupNotFound, name)
+	}
+}
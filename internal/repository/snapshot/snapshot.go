@@ -0,0 +1,177 @@
+package snapshot
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Version of snapshot format written by Write
+const Version = 1
+
+var (
+	ErrFormat   = errors.New("snapshot is not a valid JSON document")
+	ErrVersion  = errors.New("unsupported snapshot version")
+	ErrChecksum = errors.New("snapshot checksum mismatch")
+	ErrCounts   = errors.New("snapshot metric counts mismatch")
+)
+
+// Header describes storage data kept in snapshot.
+// Legacy snapshots, which are bare storage data, are read with zero Version.
+type Header struct {
+	Version    int            `json:"version"`
+	CreatedAt  time.Time      `json:"createdAt"`
+	Counts     map[string]int `json:"counts"`               // number of metrics in every section of data
+	WALSegment uint64         `json:"walSegment,omitempty"` // the last write-ahead log segment covered by data
+	SHA256     string         `json:"sha256"`               // hex encoded checksum of data
+}
+
+type file struct {
+	Header
+	Data json.RawMessage `json:"data"`
+}
+
+// Write saves storage data with header to path. Data is written to a temporary file renamed to path
+// when it is completely written, so path always keeps either previous or new snapshot.
+func Write(path string, data []byte, walSegment uint64) (Header, error) {
+	// data is compacted and escaped when it is embedded into file, so checksum is calculated for that form
+	data, err := json.Marshal(json.RawMessage(data))
+	if err != nil {
+		return Header{}, fmt.Errorf("%w: %w", ErrFormat, err)
+	}
+	counts, err := count(data)
+	if err != nil {
+		return Header{}, err
+	}
+	sum := sha256.Sum256(data)
+	header := Header{
+		Version:    Version,
+		CreatedAt:  time.Now().UTC(),
+		Counts:     counts,
+		WALSegment: walSegment,
+		SHA256:     hex.EncodeToString(sum[:]),
+	}
+
+	encoded, err := json.Marshal(file{Header: header, Data: data})
+	if err != nil {
+		return Header{}, err
+	}
+
+	return header, WriteFileAtomic(path, encoded)
+}
+
+// Read loads snapshot from path and verifies its data against header.
+// Empty file is a legacy snapshot without metrics, legacy server created it before the first store.
+func Read(path string) (Header, []byte, error) {
+	encoded, err := os.ReadFile(path)
+	if err != nil {
+		return Header{}, nil, err
+	}
+	if len(encoded) == 0 {
+		return decodeLegacy([]byte("{}"))
+	}
+
+	return Decode(encoded)
+}
+
+// Decode parses and verifies snapshot, legacy snapshot is returned as is
+func Decode(encoded []byte) (Header, []byte, error) {
+	var f file
+	if err := json.Unmarshal(encoded, &f); err != nil {
+		return Header{}, nil, fmt.Errorf("%w: %w", ErrFormat, err)
+	}
+
+	if f.Version == 0 && f.Data == nil {
+		return decodeLegacy(encoded)
+	}
+	if f.Version != Version {
+		return f.Header, nil, fmt.Errorf("%w %d", ErrVersion, f.Version)
+	}
+
+	sum := sha256.Sum256(f.Data)
+	if hex.EncodeToString(sum[:]) != f.SHA256 {
+		return f.Header, nil, ErrChecksum
+	}
+	counts, err := count(f.Data)
+	if err != nil {
+		return f.Header, nil, err
+	}
+	if len(counts) != len(f.Counts) {
+		return f.Header, nil, ErrCounts
+	}
+	for section, n := range counts {
+		if f.Counts[section] != n {
+			return f.Header, nil, fmt.Errorf("%w: %s", ErrCounts, section)
+		}
+	}
+
+	return f.Header, f.Data, nil
+}
+
+// decodeLegacy reads bare storage data like {"Gauges":{...},"Counters":{...}},
+// which may keep number of covered write-ahead log segment in WALSegment
+func decodeLegacy(encoded []byte) (Header, []byte, error) {
+	var legacy struct {
+		WALSegment uint64 `json:"WALSegment"`
+	}
+	if err := json.Unmarshal(encoded, &legacy); err != nil {
+		return Header{}, nil, fmt.Errorf("%w: %w", ErrFormat, err)
+	}
+	counts, err := count(encoded)
+	if err != nil {
+		return Header{}, nil, err
+	}
+
+	return Header{Counts: counts, WALSegment: legacy.WALSegment}, encoded, nil
+}
+
+// count returns number of metrics in every section of storage data, values which are not objects are skipped
+func count(data []byte) (map[string]int, error) {
+	var sections map[string]json.RawMessage
+	if err := json.Unmarshal(data, &sections); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrFormat, err)
+	}
+
+	counts := make(map[string]int, len(sections))
+	for name, section := range sections {
+		if !bytes.HasPrefix(bytes.TrimSpace(section), []byte{'{'}) {
+			continue
+		}
+		var metrics map[string]json.RawMessage
+		if err := json.Unmarshal(section, &metrics); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrFormat, err)
+		}
+		counts[name] = len(metrics)
+	}
+
+	return counts, nil
+}
+
+// WriteFileAtomic writes data to a temporary file and renames it to path, so path always holds complete data
+func WriteFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
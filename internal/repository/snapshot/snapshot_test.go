@@ -0,0 +1,86 @@
+package snapshot
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const data = `{"Gauges":{"g":1.5,"a<b":2},"Counters":{"c":3}}`
+
+func TestWriteRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "values.json")
+
+	written, err := Write(path, []byte(data), 7)
+	require.NoError(t, err)
+	assert.Equal(t, Version, written.Version)
+	assert.Equal(t, map[string]int{"Gauges": 2, "Counters": 1}, written.Counts)
+	assert.False(t, written.CreatedAt.IsZero())
+
+	header, restored, err := Read(path)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(7), header.WALSegment)
+	assert.Equal(t, written.SHA256, header.SHA256)
+	assert.JSONEq(t, data, string(restored))
+
+	files, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Len(t, files, 1, "temporary file is left")
+}
+
+func TestDecode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "values.json")
+	_, err := Write(path, []byte(data), 0)
+	require.NoError(t, err)
+	valid, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		encoded string
+		wantErr error
+	}{
+		{name: "valid", encoded: string(valid)},
+		{name: "tampered data", encoded: strings.Replace(string(valid), `"c":3`, `"c":4`, 1), wantErr: ErrChecksum},
+		{name: "tampered counts", encoded: strings.Replace(string(valid), `"Counters":1`, `"Counters":2`, 1), wantErr: ErrCounts},
+		{name: "future version", encoded: strings.Replace(string(valid), `"version":1`, `"version":2`, 1), wantErr: ErrVersion},
+		{name: "truncated", encoded: string(valid[:len(valid)/2]), wantErr: ErrFormat},
+		{name: "empty", encoded: "", wantErr: ErrFormat},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := Decode([]byte(tt.encoded))
+			if tt.wantErr == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDecode_Legacy(t *testing.T) {
+	legacy := `{"Gauges":{"g":1},"Counters":{},"WALSegment":3}`
+
+	header, restored, err := Decode([]byte(legacy))
+	require.NoError(t, err)
+	assert.Equal(t, 0, header.Version)
+	assert.Equal(t, uint64(3), header.WALSegment)
+	assert.Equal(t, map[string]int{"Gauges": 1, "Counters": 0}, header.Counts)
+	assert.Equal(t, legacy, string(restored))
+}
+
+func TestRead_EmptyLegacy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "values.json")
+	require.NoError(t, os.WriteFile(path, nil, 0666))
+
+	header, restored, err := Read(path)
+	require.NoError(t, err)
+	assert.Equal(t, 0, header.Version)
+	assert.Empty(t, header.Counts)
+	assert.JSONEq(t, "{}", string(restored))
+}
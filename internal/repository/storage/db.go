@@ -0,0 +1,643 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mixailo/go-training-metrics/internal/service/logger"
+	"github.com/mixailo/go-training-metrics/internal/service/metrics"
+)
+
+// queryTimeout limits a single attempt of storage operation
+const queryTimeout = 5 * time.Second
+
+// defaultRetryDelays are pauses before repeated attempts of operation failed with transient error
+var defaultRetryDelays = []time.Duration{time.Second, 3 * time.Second, 5 * time.Second}
+
+// errRowInserted means that row which was missing when transaction read it has been inserted by concurrent one,
+// so the transaction is repeated to change the inserted row
+var errRowInserted = errors.New("row has been inserted by concurrent transaction")
+
+// commitError is a failure of sent commit. Transaction may have been committed before connection was lost,
+// so it is never repeated: counter deltas would be added twice.
+type commitError struct {
+	err error
+}
+
+func (e *commitError) Error() string {
+	return "commit: " + e.err.Error()
+}
+
+func (e *commitError) Unwrap() error {
+	return e.err
+}
+
+// Dialect hides differences of SQL databases from DBStorage
+type Dialect interface {
+	// DriverName is a name of database/sql driver
+	DriverName() string
+	// Rebind replaces ? placeholders of query with ones of the database
+	Rebind(query string) string
+	// Migrations create and change schema, they are applied in order of versions
+	Migrations() []Migration
+	// IsTransient tells if operation failed with err is worth retrying
+	IsTransient(err error) bool
+	// ForUpdate makes select query lock the rows it reads until the end of transaction
+	ForUpdate(query string) string
+}
+
+// Migration is a versioned change of database schema
+type Migration struct {
+	Version    int
+	Statements []string
+}
+
+// PoolConfig sets up connection pool, zero values keep database/sql defaults
+type PoolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+// DBStorage keeps metrics in SQL database by their series keys.
+// Histograms and summaries are stored as JSON documents.
+// Methods without error in result log failed queries.
+type DBStorage struct {
+	db          *sql.DB
+	dialect     Dialect
+	retryDelays []time.Duration
+}
+
+// NewDBStorage opens database and applies migrations which were not applied yet
+func NewDBStorage(dialect Dialect, dsn string, pool PoolConfig) (*DBStorage, error) {
+	db, err := sql.Open(dialect.DriverName(), dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(pool.MaxOpenConns)
+	db.SetMaxIdleConns(pool.MaxIdleConns)
+	db.SetConnMaxLifetime(pool.ConnMaxLifetime)
+
+	s := &DBStorage{db: db, dialect: dialect, retryDelays: defaultRetryDelays}
+	if err = s.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *DBStorage) Close() error {
+	return s.db.Close()
+}
+
+// Ping checks connection to database
+func (s *DBStorage) Ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	return s.db.PingContext(ctx)
+}
+
+// migrate applies migrations of dialect with versions greater than the recorded one
+func (s *DBStorage) migrate() error {
+	err := s.do(func(ctx context.Context) error {
+		_, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, m := range s.dialect.Migrations() {
+		err = s.inTx(func(ctx context.Context, tx *sql.Tx) error {
+			var applied bool
+			err := tx.QueryRowContext(ctx, s.dialect.Rebind(`SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = ?)`), m.Version).Scan(&applied)
+			if err != nil || applied {
+				return err
+			}
+
+			for _, statement := range m.Statements {
+				if _, err = tx.ExecContext(ctx, statement); err != nil {
+					return err
+				}
+			}
+			_, err = tx.ExecContext(ctx, s.dialect.Rebind(`INSERT INTO schema_migrations (version) VALUES (?)`), m.Version)
+			if err == nil {
+				logger.Log.Info("migration applied", zap.Int("version", m.Version))
+			}
+
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *DBStorage) UpdateGauge(name string, value float64) error {
+	return s.do(func(ctx context.Context) error {
+		return s.updateGauge(ctx, s.db, name, value)
+	})
+}
+
+// UpdateCounter adds value to counter in a transaction, so failed statement can be repeated
+// unlike the implicit commit of a single one
+func (s *DBStorage) UpdateCounter(name string, value int64) error {
+	return s.inTx(func(ctx context.Context, tx *sql.Tx) error {
+		return s.updateCounter(ctx, tx, name, value)
+	})
+}
+
+func (s *DBStorage) GetGauge(name string) (val float64, ok bool) {
+	err := s.do(func(ctx context.Context) error {
+		return s.db.QueryRowContext(ctx, s.dialect.Rebind(`SELECT value FROM gauges WHERE name = ?`), name).Scan(&val)
+	})
+
+	return val, found(err, "gauge", name)
+}
+
+func (s *DBStorage) GetCounter(name string) (val int64, ok bool) {
+	err := s.do(func(ctx context.Context) error {
+		return s.db.QueryRowContext(ctx, s.dialect.Rebind(`SELECT value FROM counters WHERE name = ?`), name).Scan(&val)
+	})
+
+	return val, found(err, "counter", name)
+}
+
+// found tells if a single row query succeeded, logging errors other than missing row
+func found(err error, mType, name string) bool {
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		logger.Log.Error("cannot get metric", zap.String("type", mType), zap.String("name", name), zap.Error(err))
+	}
+
+	return err == nil
+}
+
+// ObserveHistogram adds an observation to histogram, bounds are used if histogram does not exist yet
+func (s *DBStorage) ObserveHistogram(name string, value float64, bounds []float64) error {
+	return s.inTx(func(ctx context.Context, tx *sql.Tx) error {
+		stored, err := s.getHistogram(ctx, tx, name, true)
+		if err != nil {
+			return err
+		}
+		h, err := metrics.ObserveHistogram(stored, value, bounds)
+		if err != nil {
+			return err
+		}
+
+		return s.putDocument(ctx, tx, "histograms", name, h, stored != nil)
+	})
+}
+
+// MergeHistogram adds pre-bucketed observations to histogram
+func (s *DBStorage) MergeHistogram(name string, other metrics.Histogram) error {
+	return s.inTx(func(ctx context.Context, tx *sql.Tx) error {
+		stored, err := s.getHistogram(ctx, tx, name, true)
+		if err != nil {
+			return err
+		}
+		h, err := metrics.MergeHistogram(stored, other)
+		if err != nil {
+			return err
+		}
+
+		return s.putDocument(ctx, tx, "histograms", name, h, stored != nil)
+	})
+}
+
+func (s *DBStorage) GetHistogram(name string) (val metrics.Histogram, ok bool) {
+	var h *metrics.Histogram
+	err := s.do(func(ctx context.Context) (err error) {
+		h, err = s.getHistogram(ctx, s.db, name, false)
+		return err
+	})
+	if err != nil {
+		logger.Log.Error("cannot get histogram", zap.String("name", name), zap.Error(err))
+	}
+	if err != nil || h == nil {
+		return val, false
+	}
+
+	return *h, true
+}
+
+// ObserveSummary adds an observation to summary
+func (s *DBStorage) ObserveSummary(name string, value float64) error {
+	return s.inTx(func(ctx context.Context, tx *sql.Tx) error {
+		stored, err := s.getSummary(ctx, tx, name, true)
+		if err != nil {
+			return err
+		}
+		sk, err := metrics.ObserveSummary(stored, value)
+		if err != nil {
+			return err
+		}
+
+		return s.putDocument(ctx, tx, "summaries", name, sk, stored != nil)
+	})
+}
+
+// MergeSummary merges sketch into summary
+func (s *DBStorage) MergeSummary(name string, other metrics.Sketch) error {
+	return s.inTx(func(ctx context.Context, tx *sql.Tx) error {
+		stored, err := s.getSummary(ctx, tx, name, true)
+		if err != nil {
+			return err
+		}
+		merged, err := metrics.MergeSummary(stored, other)
+		if err != nil {
+			return err
+		}
+
+		return s.putDocument(ctx, tx, "summaries", name, merged, stored != nil)
+	})
+}
+
+func (s *DBStorage) GetSummary(name string) (val metrics.Sketch, ok bool) {
+	var sk *metrics.Sketch
+	err := s.do(func(ctx context.Context) (err error) {
+		sk, err = s.getSummary(ctx, s.db, name, false)
+		return err
+	})
+	if err != nil {
+		logger.Log.Error("cannot get summary", zap.String("name", name), zap.Error(err))
+	}
+	if err != nil || sk == nil {
+		return val, false
+	}
+
+	return *sk, true
+}
+
+// UpdateBatch applies all metrics of the batch in a single transaction
+func (s *DBStorage) UpdateBatch(batch []metrics.Metrics) error {
+	for _, item := range batch {
+		if !item.IsWritable() {
+			return ErrNotWritable
+		}
+	}
+
+	return s.inTx(func(ctx context.Context, tx *sql.Tx) error {
+		for _, item := range batch {
+			if err := s.applyItem(ctx, tx, item); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (s *DBStorage) applyItem(ctx context.Context, tx *sql.Tx, item metrics.Metrics) error {
+	key := item.SeriesKey()
+	switch item.MType {
+	case metrics.TypeCounter.String():
+		return s.updateCounter(ctx, tx, key, *item.Delta)
+	case metrics.TypeGauge.String():
+		return s.updateGauge(ctx, tx, key, *item.Value)
+	case metrics.TypeHistogram.String():
+		stored, err := s.getHistogram(ctx, tx, key, true)
+		if err != nil {
+			return err
+		}
+		h, err := item.ApplyHistogram(stored)
+		if err != nil {
+			return err
+		}
+		return s.putDocument(ctx, tx, "histograms", key, h, stored != nil)
+	case metrics.TypeSummary.String():
+		stored, err := s.getSummary(ctx, tx, key, true)
+		if err != nil {
+			return err
+		}
+		sk, err := item.ApplySummary(stored)
+		if err != nil {
+			return err
+		}
+		return s.putDocument(ctx, tx, "summaries", key, sk, stored != nil)
+	}
+
+	return ErrNotWritable
+}
+
+func (s *DBStorage) Gauges() map[string]float64 {
+	m, err := s.load()
+	if err != nil {
+		logger.Log.Error("cannot load gauges", zap.Error(err))
+	}
+
+	return m.gauges
+}
+
+func (s *DBStorage) Counters() map[string]int64 {
+	m, err := s.load()
+	if err != nil {
+		logger.Log.Error("cannot load counters", zap.Error(err))
+	}
+
+	return m.counters
+}
+
+func (s *DBStorage) Histograms() map[string]metrics.Histogram {
+	m, err := s.load()
+	if err != nil {
+		logger.Log.Error("cannot load histograms", zap.Error(err))
+	}
+
+	return m.histograms
+}
+
+func (s *DBStorage) Summaries() map[string]metrics.Sketch {
+	m, err := s.load()
+	if err != nil {
+		logger.Log.Error("cannot load summaries", zap.Error(err))
+	}
+
+	return m.summaries
+}
+
+// MarshalJSON encodes contents of database in the same format as MemStorage snapshot
+func (s *DBStorage) MarshalJSON() ([]byte, error) {
+	m, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return m.MarshalJSON()
+}
+
+// UnmarshalJSON replaces contents of database with MemStorage snapshot
+func (s *DBStorage) UnmarshalJSON(data []byte) error {
+	decoded := NewMemStorage()
+	if err := json.Unmarshal(data, decoded); err != nil {
+		return err
+	}
+
+	return s.inTx(func(ctx context.Context, tx *sql.Tx) error {
+		for _, table := range []string{"gauges", "counters", "histograms", "summaries"} {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM `+table); err != nil {
+				return err
+			}
+		}
+		for k, v := range decoded.gauges {
+			if err := s.updateGauge(ctx, tx, k, v); err != nil {
+				return err
+			}
+		}
+		for k, v := range decoded.counters {
+			if err := s.updateCounter(ctx, tx, k, v); err != nil {
+				return err
+			}
+		}
+		for k, v := range decoded.histograms {
+			if err := s.putDocument(ctx, tx, "histograms", k, v, false); err != nil {
+				return err
+			}
+		}
+		for k, v := range decoded.summaries {
+			if err := s.putDocument(ctx, tx, "summaries", k, v, false); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// load reads all metrics into memory within a single transaction
+func (s *DBStorage) load() (*MemStorage, error) {
+	var m *MemStorage
+	err := s.inTx(func(ctx context.Context, tx *sql.Tx) error {
+		m = NewMemStorage()
+		err := scanRows(ctx, tx, `SELECT name, value FROM gauges`, func(rows *sql.Rows) error {
+			var (
+				name  string
+				value float64
+			)
+			if err := rows.Scan(&name, &value); err != nil {
+				return err
+			}
+			m.gauges[name] = value
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		err = scanRows(ctx, tx, `SELECT name, value FROM counters`, func(rows *sql.Rows) error {
+			var (
+				name  string
+				value int64
+			)
+			if err := rows.Scan(&name, &value); err != nil {
+				return err
+			}
+			m.counters[name] = value
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		err = scanRows(ctx, tx, `SELECT name, data FROM histograms`, func(rows *sql.Rows) error {
+			var (
+				name string
+				data []byte
+				h    metrics.Histogram
+			)
+			if err := rows.Scan(&name, &data); err != nil {
+				return err
+			}
+			if err := json.Unmarshal(data, &h); err != nil {
+				return err
+			}
+			m.histograms[name] = h
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		return scanRows(ctx, tx, `SELECT name, data FROM summaries`, func(rows *sql.Rows) error {
+			var (
+				name string
+				data []byte
+				sk   metrics.Sketch
+			)
+			if err := rows.Scan(&name, &data); err != nil {
+				return err
+			}
+			if err := json.Unmarshal(data, &sk); err != nil {
+				return err
+			}
+			m.summaries[name] = sk
+			return nil
+		})
+	})
+	if err != nil {
+		return NewMemStorage(), err
+	}
+
+	return m, nil
+}
+
+// do runs fn with timeout, repeating it after a pause while it fails with transient error
+func (s *DBStorage) do(fn func(ctx context.Context) error) error {
+	for attempt := 0; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+		err := fn(ctx)
+		cancel()
+
+		if err == nil || attempt >= len(s.retryDelays) || !s.isTransient(err) {
+			return err
+		}
+		logger.Log.Info("transient database error, will retry", zap.Duration("delay", s.retryDelays[attempt]), zap.Error(err))
+		time.Sleep(s.retryDelays[attempt])
+	}
+}
+
+func (s *DBStorage) isTransient(err error) bool {
+	var commitErr *commitError
+	if errors.As(err, &commitErr) {
+		return false
+	}
+
+	return errors.Is(err, driver.ErrBadConn) || errors.Is(err, errRowInserted) || s.dialect.IsTransient(err)
+}
+
+// inTx runs fn in a transaction, which is committed if fn succeeds. Transaction failed before commit
+// is retried as a whole, failed commit is not.
+func (s *DBStorage) inTx(fn func(ctx context.Context, tx *sql.Tx) error) error {
+	return s.do(func(ctx context.Context) error {
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if err = fn(ctx, tx); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if err = tx.Commit(); err != nil {
+			return &commitError{err: err}
+		}
+
+		return nil
+	})
+}
+
+// querier is implemented by both sql.DB and sql.Tx
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func (s *DBStorage) updateGauge(ctx context.Context, q querier, name string, value float64) error {
+	_, err := q.ExecContext(ctx,
+		s.dialect.Rebind(`INSERT INTO gauges (name, value) VALUES (?, ?) ON CONFLICT (name) DO UPDATE SET value = excluded.value`),
+		name, value)
+
+	return err
+}
+
+func (s *DBStorage) updateCounter(ctx context.Context, q querier, name string, value int64) error {
+	_, err := q.ExecContext(ctx,
+		s.dialect.Rebind(`INSERT INTO counters (name, value) VALUES (?, ?) ON CONFLICT (name) DO UPDATE SET value = counters.value + excluded.value`),
+		name, value)
+
+	return err
+}
+
+// putDocument writes JSON encoded value into histograms or summaries table. Row read as missing is inserted
+// only if it is still missing, otherwise the change of concurrent transaction would be overwritten.
+func (s *DBStorage) putDocument(ctx context.Context, q querier, table, name string, value any, exists bool) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	if exists {
+		_, err = q.ExecContext(ctx, s.dialect.Rebind(`UPDATE `+table+` SET data = ? WHERE name = ?`), string(data), name)
+		return err
+	}
+
+	res, err := q.ExecContext(ctx,
+		s.dialect.Rebind(`INSERT INTO `+table+` (name, data) VALUES (?, ?) ON CONFLICT (name) DO NOTHING`),
+		name, string(data))
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return errors.Join(errRowInserted, err)
+	}
+
+	return nil
+}
+
+// getDocument decodes JSON document from histograms or summaries table, it reports false if there is no such row.
+// Row read for update is locked until the end of transaction, so concurrent changes of it are not lost.
+func (s *DBStorage) getDocument(ctx context.Context, q querier, table, name string, value any, forUpdate bool) (bool, error) {
+	query := `SELECT data FROM ` + table + ` WHERE name = ?`
+	if forUpdate {
+		query = s.dialect.ForUpdate(query)
+	}
+
+	var data []byte
+	err := q.QueryRowContext(ctx, s.dialect.Rebind(query), name).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, json.Unmarshal(data, value)
+}
+
+func (s *DBStorage) getHistogram(ctx context.Context, q querier, name string, forUpdate bool) (*metrics.Histogram, error) {
+	var h metrics.Histogram
+	ok, err := s.getDocument(ctx, q, "histograms", name, &h, forUpdate)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	return &h, nil
+}
+
+func (s *DBStorage) getSummary(ctx context.Context, q querier, name string, forUpdate bool) (*metrics.Sketch, error) {
+	var sk metrics.Sketch
+	ok, err := s.getDocument(ctx, q, "summaries", name, &sk, forUpdate)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	return &sk, nil
+}
+
+func scanRows(ctx context.Context, q querier, query string, scan func(rows *sql.Rows) error) error {
+	rows, err := q.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err = scan(rows); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
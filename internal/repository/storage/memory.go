@@ -1,48 +1,229 @@
 package storage
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"errors"
 
+	"github.com/mixailo/go-training-metrics/internal/service/metrics"
+)
+
+var ErrNotWritable = errors.New("metric is not writable")
+
+// MemStorage keeps metrics by their series keys (see metrics.SeriesKey),
+// so metrics without labels are stored by their names
 type MemStorage struct {
-	gauges   map[string]float64
-	counters map[string]int64
+	gauges     map[string]float64
+	counters   map[string]int64
+	histograms map[string]metrics.Histogram
+	summaries  map[string]metrics.Sketch
 }
 
 func (m *MemStorage) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
-		Gauges   map[string]float64 `json:"Gauges"`
-		Counters map[string]int64   `json:"Counters"`
+		Gauges     map[string]float64           `json:"Gauges"`
+		Counters   map[string]int64             `json:"Counters"`
+		Histograms map[string]metrics.Histogram `json:"Histograms,omitempty"`
+		Summaries  map[string]metrics.Sketch    `json:"Summaries,omitempty"`
 	}{
-		Gauges:   m.Gauges(),
-		Counters: m.Counters(),
+		Gauges:     m.Gauges(),
+		Counters:   m.Counters(),
+		Histograms: m.Histograms(),
+		Summaries:  m.Summaries(),
 	})
 }
 
 func (m *MemStorage) UnmarshalJSON(data []byte) error {
 	encoded := struct {
-		Gauges   map[string]float64 `json:"Gauges"`
-		Counters map[string]int64   `json:"Counters"`
+		Gauges     map[string]float64           `json:"Gauges"`
+		Counters   map[string]int64             `json:"Counters"`
+		Histograms map[string]metrics.Histogram `json:"Histograms"`
+		Summaries  map[string]metrics.Sketch    `json:"Summaries"`
 	}{}
 	err := json.Unmarshal(data, &encoded)
 	if err != nil {
 		return err
 	}
+	for _, h := range encoded.Histograms {
+		if err = h.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, s := range encoded.Summaries {
+		if err = s.Validate(); err != nil {
+			return err
+		}
+	}
+
+	// snapshots may lack some of the sections
 	m.gauges = encoded.Gauges
+	if m.gauges == nil {
+		m.gauges = make(map[string]float64)
+	}
 	m.counters = encoded.Counters
+	if m.counters == nil {
+		m.counters = make(map[string]int64)
+	}
+	m.histograms = encoded.Histograms
+	if m.histograms == nil {
+		m.histograms = make(map[string]metrics.Histogram)
+	}
+	m.summaries = encoded.Summaries
+	if m.summaries == nil {
+		m.summaries = make(map[string]metrics.Sketch)
+	}
 
 	return nil
 }
 
-func (m *MemStorage) UpdateGauge(name string, value float64) {
+func (m *MemStorage) UpdateGauge(name string, value float64) error {
 	m.gauges[name] = value
+	return nil
 }
 
-func (m *MemStorage) UpdateCounter(name string, value int64) {
+func (m *MemStorage) UpdateCounter(name string, value int64) error {
 	oldValue, ok := m.counters[name]
 	if !ok {
 		m.counters[name] = value
 	} else {
 		m.counters[name] = oldValue + value
 	}
+	return nil
+}
+
+// ObserveHistogram adds an observation to histogram, bounds are used if histogram does not exist yet
+func (m *MemStorage) ObserveHistogram(name string, value float64, bounds []float64) error {
+	h, err := metrics.ObserveHistogram(m.histogram(name), value, bounds)
+	if err != nil {
+		return err
+	}
+	m.histograms[name] = h
+
+	return nil
+}
+
+// MergeHistogram adds pre-bucketed observations to histogram
+func (m *MemStorage) MergeHistogram(name string, other metrics.Histogram) error {
+	h, err := metrics.MergeHistogram(m.histogram(name), other)
+	if err != nil {
+		return err
+	}
+	m.histograms[name] = h
+
+	return nil
+}
+
+func (m *MemStorage) histogram(name string) *metrics.Histogram {
+	if h, ok := m.histograms[name]; ok {
+		return &h
+	}
+
+	return nil
+}
+
+// ObserveSummary adds an observation to summary
+func (m *MemStorage) ObserveSummary(name string, value float64) error {
+	s, err := metrics.ObserveSummary(m.summary(name), value)
+	if err != nil {
+		return err
+	}
+	m.summaries[name] = s
+
+	return nil
+}
+
+// MergeSummary merges sketch into summary
+func (m *MemStorage) MergeSummary(name string, other metrics.Sketch) error {
+	s, err := metrics.MergeSummary(m.summary(name), other)
+	if err != nil {
+		return err
+	}
+	m.summaries[name] = s
+
+	return nil
+}
+
+func (m *MemStorage) summary(name string) *metrics.Sketch {
+	if s, ok := m.summaries[name]; ok {
+		return &s
+	}
+
+	return nil
+}
+
+// UpdateBatch applies all metrics of the batch or none of them if any metric is not writable
+func (m *MemStorage) UpdateBatch(batch []metrics.Metrics) error {
+	prepared, err := m.prepareBatch(batch)
+	if err != nil {
+		return err
+	}
+	m.applyBatch(prepared)
+
+	return nil
+}
+
+// preparedBatch is a batch checked against the storage, ready to be applied
+type preparedBatch struct {
+	items      []metrics.Metrics
+	histograms map[string]metrics.Histogram
+	summaries  map[string]metrics.Sketch
+}
+
+// prepareBatch validates the batch and computes new states of histograms and summaries,
+// as they may be incompatible with stored ones, so that nothing is applied on error
+func (m *MemStorage) prepareBatch(batch []metrics.Metrics) (preparedBatch, error) {
+	prepared := preparedBatch{
+		items:      batch,
+		histograms: make(map[string]metrics.Histogram),
+		summaries:  make(map[string]metrics.Sketch),
+	}
+	for _, item := range batch {
+		if !item.IsWritable() {
+			return prepared, ErrNotWritable
+		}
+		switch item.MType {
+		case metrics.TypeHistogram.String():
+			key := item.SeriesKey()
+			current := m.histogram(key)
+			if h, ok := prepared.histograms[key]; ok {
+				current = &h
+			}
+			h, err := item.ApplyHistogram(current)
+			if err != nil {
+				return prepared, err
+			}
+			prepared.histograms[key] = h
+		case metrics.TypeSummary.String():
+			key := item.SeriesKey()
+			current := m.summary(key)
+			if s, ok := prepared.summaries[key]; ok {
+				current = &s
+			}
+			s, err := item.ApplySummary(current)
+			if err != nil {
+				return prepared, err
+			}
+			prepared.summaries[key] = s
+		}
+	}
+
+	return prepared, nil
+}
+
+func (m *MemStorage) applyBatch(prepared preparedBatch) {
+	for _, item := range prepared.items {
+		switch item.MType {
+		case metrics.TypeCounter.String():
+			m.UpdateCounter(item.SeriesKey(), *item.Delta)
+		case metrics.TypeGauge.String():
+			m.UpdateGauge(item.SeriesKey(), *item.Value)
+		}
+	}
+	for key, h := range prepared.histograms {
+		m.histograms[key] = h
+	}
+	for key, s := range prepared.summaries {
+		m.summaries[key] = s
+	}
 }
 
 func (m *MemStorage) GetGauge(name string) (val float64, ok bool) {
@@ -55,8 +236,31 @@ func (m *MemStorage) GetCounter(name string) (val int64, ok bool) {
 	return
 }
 
+// GetHistogram returns a copy of stored histogram
+func (m *MemStorage) GetHistogram(name string) (val metrics.Histogram, ok bool) {
+	val, ok = m.histograms[name]
+	if ok {
+		val = val.Copy()
+	}
+	return
+}
+
+// GetSummary returns a copy of stored summary sketch
+func (m *MemStorage) GetSummary(name string) (val metrics.Sketch, ok bool) {
+	val, ok = m.summaries[name]
+	if ok {
+		val = val.Copy()
+	}
+	return
+}
+
 func NewMemStorage() *MemStorage {
-	return &MemStorage{gauges: make(map[string]float64), counters: make(map[string]int64)}
+	return &MemStorage{
+		gauges:     make(map[string]float64),
+		counters:   make(map[string]int64),
+		histograms: make(map[string]metrics.Histogram),
+		summaries:  make(map[string]metrics.Sketch),
+	}
 }
 
 func (m *MemStorage) Gauges() map[string]float64 {
@@ -66,3 +270,11 @@ func (m *MemStorage) Gauges() map[string]float64 {
 func (m *MemStorage) Counters() map[string]int64 {
 	return m.counters
 }
+
+func (m *MemStorage) Histograms() map[string]metrics.Histogram {
+	return m.histograms
+}
+
+func (m *MemStorage) Summaries() map[string]metrics.Sketch {
+	return m.summaries
+}
@@ -2,7 +2,10 @@ package storage
 
 import (
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"testing"
+
+	"github.com/mixailo/go-training-metrics/internal/service/metrics"
 )
 
 var storage *MemStorage
@@ -115,3 +118,100 @@ func TestNewStorage(t *testing.T) {
 	localStorage := NewMemStorage()
 	assert.IsType(t, &MemStorage{}, localStorage)
 }
+
+func TestMemStorage_UpdateBatch(t *testing.T) {
+	delta := int64(5)
+	value := 2.5
+
+	t.Run("valid batch", func(t *testing.T) {
+		localStorage := NewMemStorage()
+		err := localStorage.UpdateBatch([]metrics.Metrics{
+			{ID: "batch_counter", MType: metrics.TypeCounter.String(), Delta: &delta},
+			{ID: "batch_counter", MType: metrics.TypeCounter.String(), Delta: &delta},
+			{ID: "batch_gauge", MType: metrics.TypeGauge.String(), Value: &value},
+		})
+		assert.NoError(t, err)
+
+		counter, ok := localStorage.GetCounter("batch_counter")
+		assert.True(t, ok)
+		assert.Equal(t, int64(10), counter)
+
+		gauge, ok := localStorage.GetGauge("batch_gauge")
+		assert.True(t, ok)
+		assert.Equal(t, value, gauge)
+	})
+
+	t.Run("invalid item rejects whole batch", func(t *testing.T) {
+		localStorage := NewMemStorage()
+		err := localStorage.UpdateBatch([]metrics.Metrics{
+			{ID: "batch_counter", MType: metrics.TypeCounter.String(), Delta: &delta},
+			{ID: "batch_gauge", MType: metrics.TypeGauge.String()},
+		})
+		assert.ErrorIs(t, err, ErrNotWritable)
+
+		_, ok := localStorage.GetCounter("batch_counter")
+		assert.False(t, ok)
+	})
+}
+
+func TestMemStorage_Histograms(t *testing.T) {
+	localStorage := NewMemStorage()
+
+	require.NoError(t, localStorage.ObserveHistogram("latency", 0.3, []float64{0.1, 0.5}))
+	require.NoError(t, localStorage.ObserveHistogram("latency", 0.7, nil))
+	assert.ErrorIs(t, localStorage.ObserveHistogram("latency", 0.7, []float64{1}), metrics.ErrBucketsMismatch)
+	require.NoError(t, localStorage.MergeHistogram("latency", metrics.Histogram{
+		Bounds: []float64{0.1, 0.5},
+		Counts: []uint64{2, 0, 0},
+		Sum:    0.1,
+		Count:  2,
+	}))
+
+	h, ok := localStorage.GetHistogram("latency")
+	require.True(t, ok)
+	assert.Equal(t, []uint64{2, 1, 1}, h.Counts)
+	assert.Equal(t, uint64(4), h.Count)
+
+	t.Run("snapshot round trip", func(t *testing.T) {
+		data, err := localStorage.MarshalJSON()
+		require.NoError(t, err)
+
+		restored := NewMemStorage()
+		require.NoError(t, restored.UnmarshalJSON(data))
+		rh, ok := restored.GetHistogram("latency")
+		require.True(t, ok)
+		assert.Equal(t, h, rh)
+	})
+
+	t.Run("legacy snapshot", func(t *testing.T) {
+		restored := NewMemStorage()
+		require.NoError(t, restored.UnmarshalJSON([]byte(`{"Gauges":{"g":1}}`)))
+		restored.UpdateCounter("c", 1)
+		require.NoError(t, restored.ObserveHistogram("h", 1, nil))
+	})
+}
+
+func TestMemStorage_Summaries(t *testing.T) {
+	localStorage := NewMemStorage()
+
+	localStorage.ObserveSummary("latency", 1)
+	localStorage.ObserveSummary("latency", 2)
+
+	other, err := metrics.NewSketch(metrics.DefaultSketchAccuracy)
+	require.NoError(t, err)
+	other.Observe(3)
+	require.NoError(t, localStorage.MergeSummary("latency", other))
+
+	s, ok := localStorage.GetSummary("latency")
+	require.True(t, ok)
+	assert.Equal(t, uint64(3), s.Count)
+	assert.Equal(t, 6.0, s.Sum)
+
+	data, err := localStorage.MarshalJSON()
+	require.NoError(t, err)
+	restored := NewMemStorage()
+	require.NoError(t, restored.UnmarshalJSON(data))
+	rs, ok := restored.GetSummary("latency")
+	require.True(t, ok)
+	assert.Equal(t, s, rs)
+}
@@ -0,0 +1,308 @@
+package storage
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/mixailo/go-training-metrics/internal/service/metrics"
+)
+
+// DefaultShards is a number of shards of storage created by NewShardedStorage
+const DefaultShards = 32
+
+type shard struct {
+	mu   sync.RWMutex
+	stor *MemStorage
+}
+
+// ShardedStorage is a concurrency-safe storage. Metrics are spread over shards
+// by hash of their series key, each shard is guarded by its own lock,
+// so updates of different metrics rarely wait for each other.
+type ShardedStorage struct {
+	shards []*shard
+}
+
+func NewShardedStorage() *ShardedStorage {
+	return NewShardedStorageN(DefaultShards)
+}
+
+// NewShardedStorageN creates storage with n shards
+func NewShardedStorageN(n int) *ShardedStorage {
+	if n < 1 {
+		n = 1
+	}
+
+	s := &ShardedStorage{shards: make([]*shard, n)}
+	for i := range s.shards {
+		s.shards[i] = &shard{stor: NewMemStorage()}
+	}
+
+	return s
+}
+
+// index returns shard of the series key, it is FNV-1a hash computed without allocations
+func (s *ShardedStorage) index(name string) int {
+	const (
+		offset32 = 2166136261
+		prime32  = 16777619
+	)
+	h := uint32(offset32)
+	for i := 0; i < len(name); i++ {
+		h ^= uint32(name[i])
+		h *= prime32
+	}
+
+	return int(h % uint32(len(s.shards)))
+}
+
+func (s *ShardedStorage) shard(name string) *shard {
+	return s.shards[s.index(name)]
+}
+
+func (s *ShardedStorage) UpdateGauge(name string, value float64) error {
+	sh := s.shard(name)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	return sh.stor.UpdateGauge(name, value)
+}
+
+func (s *ShardedStorage) UpdateCounter(name string, value int64) error {
+	sh := s.shard(name)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	return sh.stor.UpdateCounter(name, value)
+}
+
+func (s *ShardedStorage) GetGauge(name string) (float64, bool) {
+	sh := s.shard(name)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	return sh.stor.GetGauge(name)
+}
+
+func (s *ShardedStorage) GetCounter(name string) (int64, bool) {
+	sh := s.shard(name)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	return sh.stor.GetCounter(name)
+}
+
+func (s *ShardedStorage) ObserveHistogram(name string, value float64, bounds []float64) error {
+	sh := s.shard(name)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	return sh.stor.ObserveHistogram(name, value, bounds)
+}
+
+func (s *ShardedStorage) MergeHistogram(name string, other metrics.Histogram) error {
+	sh := s.shard(name)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	return sh.stor.MergeHistogram(name, other)
+}
+
+func (s *ShardedStorage) GetHistogram(name string) (metrics.Histogram, bool) {
+	sh := s.shard(name)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	return sh.stor.GetHistogram(name)
+}
+
+func (s *ShardedStorage) ObserveSummary(name string, value float64) error {
+	sh := s.shard(name)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	return sh.stor.ObserveSummary(name, value)
+}
+
+func (s *ShardedStorage) MergeSummary(name string, other metrics.Sketch) error {
+	sh := s.shard(name)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	return sh.stor.MergeSummary(name, other)
+}
+
+func (s *ShardedStorage) GetSummary(name string) (metrics.Sketch, bool) {
+	sh := s.shard(name)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	return sh.stor.GetSummary(name)
+}
+
+// UpdateBatch applies all metrics of the batch or none of them. Shards touched by the batch
+// are locked together in ascending order, so concurrent batches cannot deadlock.
+func (s *ShardedStorage) UpdateBatch(batch []metrics.Metrics) error {
+	groups := make(map[int][]metrics.Metrics)
+	for _, item := range batch {
+		if !item.IsWritable() {
+			return ErrNotWritable
+		}
+		i := s.index(item.SeriesKey())
+		groups[i] = append(groups[i], item)
+	}
+
+	locked := make([]int, 0, len(groups))
+	for i := range s.shards {
+		if _, ok := groups[i]; ok {
+			s.shards[i].mu.Lock()
+			locked = append(locked, i)
+		}
+	}
+	defer func() {
+		for _, i := range locked {
+			s.shards[i].mu.Unlock()
+		}
+	}()
+
+	prepared := make([]preparedBatch, 0, len(locked))
+	for _, i := range locked {
+		p, err := s.shards[i].stor.prepareBatch(groups[i])
+		if err != nil {
+			return err
+		}
+		prepared = append(prepared, p)
+	}
+	for n, i := range locked {
+		s.shards[i].stor.applyBatch(prepared[n])
+	}
+
+	return nil
+}
+
+// Gauges returns a copy of all gauges
+func (s *ShardedStorage) Gauges() map[string]float64 {
+	result := make(map[string]float64)
+	for _, sh := range s.shards {
+		sh.mu.RLock()
+		for k, v := range sh.stor.Gauges() {
+			result[k] = v
+		}
+		sh.mu.RUnlock()
+	}
+
+	return result
+}
+
+// Counters returns a copy of all counters
+func (s *ShardedStorage) Counters() map[string]int64 {
+	result := make(map[string]int64)
+	for _, sh := range s.shards {
+		sh.mu.RLock()
+		for k, v := range sh.stor.Counters() {
+			result[k] = v
+		}
+		sh.mu.RUnlock()
+	}
+
+	return result
+}
+
+// Histograms returns a copy of all histograms
+func (s *ShardedStorage) Histograms() map[string]metrics.Histogram {
+	result := make(map[string]metrics.Histogram)
+	for _, sh := range s.shards {
+		sh.mu.RLock()
+		for k, v := range sh.stor.Histograms() {
+			result[k] = v.Copy()
+		}
+		sh.mu.RUnlock()
+	}
+
+	return result
+}
+
+// Summaries returns a copy of all summaries
+func (s *ShardedStorage) Summaries() map[string]metrics.Sketch {
+	result := make(map[string]metrics.Sketch)
+	for _, sh := range s.shards {
+		sh.mu.RLock()
+		for k, v := range sh.stor.Summaries() {
+			result[k] = v.Copy()
+		}
+		sh.mu.RUnlock()
+	}
+
+	return result
+}
+
+// snapshot copies all shards into a single MemStorage, all shards are locked at once
+// so the copy is consistent
+func (s *ShardedStorage) snapshot() *MemStorage {
+	for _, sh := range s.shards {
+		sh.mu.RLock()
+	}
+	defer func() {
+		for _, sh := range s.shards {
+			sh.mu.RUnlock()
+		}
+	}()
+
+	result := NewMemStorage()
+	for _, sh := range s.shards {
+		for k, v := range sh.stor.gauges {
+			result.gauges[k] = v
+		}
+		for k, v := range sh.stor.counters {
+			result.counters[k] = v
+		}
+		for k, v := range sh.stor.histograms {
+			result.histograms[k] = v.Copy()
+		}
+		for k, v := range sh.stor.summaries {
+			result.summaries[k] = v.Copy()
+		}
+	}
+
+	return result
+}
+
+func (s *ShardedStorage) MarshalJSON() ([]byte, error) {
+	return s.snapshot().MarshalJSON()
+}
+
+// UnmarshalJSON replaces contents of the storage with decoded snapshot
+func (s *ShardedStorage) UnmarshalJSON(data []byte) error {
+	decoded := NewMemStorage()
+	if err := json.Unmarshal(data, decoded); err != nil {
+		return err
+	}
+
+	fresh := make([]*MemStorage, len(s.shards))
+	for i := range fresh {
+		fresh[i] = NewMemStorage()
+	}
+	for k, v := range decoded.gauges {
+		fresh[s.index(k)].gauges[k] = v
+	}
+	for k, v := range decoded.counters {
+		fresh[s.index(k)].counters[k] = v
+	}
+	for k, v := range decoded.histograms {
+		fresh[s.index(k)].histograms[k] = v
+	}
+	for k, v := range decoded.summaries {
+		fresh[s.index(k)].summaries[k] = v
+	}
+
+	for _, sh := range s.shards {
+		sh.mu.Lock()
+	}
+	for i, sh := range s.shards {
+		sh.stor = fresh[i]
+	}
+	for _, sh := range s.shards {
+		sh.mu.Unlock()
+	}
+
+	return nil
+}
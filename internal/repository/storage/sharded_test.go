@@ -0,0 +1,266 @@
+package storage
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mixailo/go-training-metrics/internal/service/metrics"
+)
+
+func TestShardedStorage_Values(t *testing.T) {
+	localStorage := NewShardedStorageN(4)
+
+	localStorage.UpdateCounter("c", 1)
+	localStorage.UpdateCounter("c", 2)
+	localStorage.UpdateGauge("g", 1.5)
+	require.NoError(t, localStorage.ObserveHistogram("h", 0.3, []float64{0.1, 0.5}))
+	localStorage.ObserveSummary("s", 1)
+
+	counter, ok := localStorage.GetCounter("c")
+	assert.True(t, ok)
+	assert.Equal(t, int64(3), counter)
+
+	gauge, ok := localStorage.GetGauge("g")
+	assert.True(t, ok)
+	assert.Equal(t, 1.5, gauge)
+
+	_, ok = localStorage.GetGauge("missing")
+	assert.False(t, ok)
+
+	h, ok := localStorage.GetHistogram("h")
+	require.True(t, ok)
+	assert.Equal(t, []uint64{0, 1, 0}, h.Counts)
+
+	s, ok := localStorage.GetSummary("s")
+	require.True(t, ok)
+	assert.Equal(t, uint64(1), s.Count)
+
+	assert.Equal(t, map[string]int64{"c": 3}, localStorage.Counters())
+	assert.Equal(t, map[string]float64{"g": 1.5}, localStorage.Gauges())
+	assert.Len(t, localStorage.Histograms(), 1)
+	assert.Len(t, localStorage.Summaries(), 1)
+}
+
+func TestShardedStorage_ReturnsCopies(t *testing.T) {
+	localStorage := NewShardedStorageN(4)
+	require.NoError(t, localStorage.ObserveHistogram("h", 0.3, []float64{0.1, 0.5}))
+
+	localStorage.Histograms()["h"].Counts[1] = 100
+	h, _ := localStorage.GetHistogram("h")
+	h.Counts[1] = 100
+
+	h, _ = localStorage.GetHistogram("h")
+	assert.Equal(t, []uint64{0, 1, 0}, h.Counts)
+
+	localStorage.Counters()["c"] = 1
+	_, ok := localStorage.GetCounter("c")
+	assert.False(t, ok)
+}
+
+func TestShardedStorage_UpdateBatch(t *testing.T) {
+	delta := int64(5)
+	value := 2.5
+
+	t.Run("valid batch", func(t *testing.T) {
+		localStorage := NewShardedStorageN(4)
+		batch := make([]metrics.Metrics, 0)
+		for i := 0; i < 10; i++ {
+			batch = append(batch,
+				metrics.Metrics{ID: fmt.Sprintf("counter%d", i), MType: metrics.TypeCounter.String(), Delta: &delta},
+				metrics.Metrics{ID: fmt.Sprintf("gauge%d", i), MType: metrics.TypeGauge.String(), Value: &value},
+			)
+		}
+		require.NoError(t, localStorage.UpdateBatch(batch))
+
+		assert.Len(t, localStorage.Counters(), 10)
+		assert.Len(t, localStorage.Gauges(), 10)
+	})
+
+	t.Run("failing item rejects whole batch", func(t *testing.T) {
+		localStorage := NewShardedStorageN(4)
+		require.NoError(t, localStorage.ObserveHistogram("h", 0.3, []float64{0.1, 0.5}))
+
+		batch := make([]metrics.Metrics, 0)
+		for i := 0; i < 10; i++ {
+			batch = append(batch, metrics.Metrics{ID: fmt.Sprintf("counter%d", i), MType: metrics.TypeCounter.String(), Delta: &delta})
+		}
+		// buckets do not match stored histogram, which is found only while preparing the batch
+		batch = append(batch, metrics.Metrics{
+			ID:        "h",
+			MType:     metrics.TypeHistogram.String(),
+			Histogram: &metrics.Histogram{Bounds: []float64{1}, Counts: []uint64{1, 0}, Sum: 0.5, Count: 1},
+		})
+		assert.Error(t, localStorage.UpdateBatch(batch))
+		assert.Empty(t, localStorage.Counters())
+	})
+
+	t.Run("invalid item", func(t *testing.T) {
+		localStorage := NewShardedStorageN(4)
+		err := localStorage.UpdateBatch([]metrics.Metrics{
+			{ID: "batch_counter", MType: metrics.TypeCounter.String(), Delta: &delta},
+			{ID: "batch_gauge", MType: metrics.TypeGauge.String()},
+		})
+		assert.ErrorIs(t, err, ErrNotWritable)
+		assert.Empty(t, localStorage.Counters())
+	})
+}
+
+func TestShardedStorage_Snapshot(t *testing.T) {
+	localStorage := NewShardedStorageN(4)
+	for i := 0; i < 20; i++ {
+		localStorage.UpdateCounter(fmt.Sprintf("c%d", i), int64(i))
+		localStorage.UpdateGauge(fmt.Sprintf("g%d", i), float64(i))
+	}
+	require.NoError(t, localStorage.ObserveHistogram("h", 0.3, nil))
+	localStorage.ObserveSummary("s", 1)
+
+	data, err := localStorage.MarshalJSON()
+	require.NoError(t, err)
+
+	t.Run("sharded", func(t *testing.T) {
+		restored := NewShardedStorageN(7)
+		restored.UpdateGauge("stale", 1)
+		require.NoError(t, restored.UnmarshalJSON(data))
+
+		assert.Equal(t, localStorage.Counters(), restored.Counters())
+		assert.Equal(t, localStorage.Gauges(), restored.Gauges())
+		assert.Equal(t, localStorage.Histograms(), restored.Histograms())
+		assert.Equal(t, localStorage.Summaries(), restored.Summaries())
+	})
+
+	t.Run("compatible with MemStorage", func(t *testing.T) {
+		restored := NewMemStorage()
+		require.NoError(t, restored.UnmarshalJSON(data))
+		assert.Equal(t, localStorage.Counters(), restored.Counters())
+	})
+
+	t.Run("broken", func(t *testing.T) {
+		restored := NewShardedStorageN(4)
+		restored.UpdateGauge("kept", 1)
+		assert.Error(t, restored.UnmarshalJSON([]byte(`{"Gauges":`)))
+
+		_, ok := restored.GetGauge("kept")
+		assert.True(t, ok)
+	})
+}
+
+// TestShardedStorage_Concurrent is meant to be run with -race
+func TestShardedStorage_Concurrent(t *testing.T) {
+	localStorage := NewShardedStorage()
+	const workers = 8
+	const iterations = 200
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			delta := int64(1)
+			value := float64(w)
+			for i := 0; i < iterations; i++ {
+				name := fmt.Sprintf("metric%d", i%16)
+				localStorage.UpdateCounter(name, 1)
+				localStorage.UpdateGauge(name, value)
+				_ = localStorage.ObserveHistogram(name, value, nil)
+				localStorage.ObserveSummary(name, value)
+				_ = localStorage.UpdateBatch([]metrics.Metrics{
+					{ID: "batch", MType: metrics.TypeCounter.String(), Delta: &delta},
+					{ID: name, MType: metrics.TypeGauge.String(), Value: &value},
+				})
+
+				localStorage.GetCounter(name)
+				localStorage.GetHistogram(name)
+				localStorage.Counters()
+				localStorage.Summaries()
+				if i%50 == 0 {
+					_, err := localStorage.MarshalJSON()
+					assert.NoError(t, err)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	counter, _ := localStorage.GetCounter("batch")
+	assert.Equal(t, int64(workers*iterations), counter)
+
+	var total int64
+	for name, v := range localStorage.Counters() {
+		if name != "batch" {
+			total += v
+		}
+	}
+	assert.Equal(t, int64(workers*iterations), total)
+}
+
+// lockedStorage is MemStorage guarded by a single mutex, a baseline for benchmarks
+type lockedStorage struct {
+	mu sync.Mutex
+	*MemStorage
+}
+
+func (l *lockedStorage) UpdateCounter(name string, value int64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.MemStorage.UpdateCounter(name, value)
+}
+
+func (l *lockedStorage) GetCounter(name string) (int64, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.MemStorage.GetCounter(name)
+}
+
+type counterStorage interface {
+	UpdateCounter(name string, value int64) error
+	GetCounter(name string) (int64, bool)
+}
+
+func benchmarkStorages() map[string]func() counterStorage {
+	return map[string]func() counterStorage{
+		"single lock": func() counterStorage { return &lockedStorage{MemStorage: NewMemStorage()} },
+		"sharded":     func() counterStorage { return NewShardedStorage() },
+	}
+}
+
+var benchmarkNames = func() []string {
+	names := make([]string, 256)
+	for i := range names {
+		names[i] = fmt.Sprintf("metric%d", i)
+	}
+	return names
+}()
+
+func BenchmarkStorage_Serial(b *testing.B) {
+	for name, create := range benchmarkStorages() {
+		b.Run(name, func(b *testing.B) {
+			stor := create()
+			for i := 0; i < b.N; i++ {
+				key := benchmarkNames[i%len(benchmarkNames)]
+				stor.UpdateCounter(key, 1)
+				stor.GetCounter(key)
+			}
+		})
+	}
+}
+
+func BenchmarkStorage_Parallel(b *testing.B) {
+	for name, create := range benchmarkStorages() {
+		b.Run(name, func(b *testing.B) {
+			stor := create()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					key := benchmarkNames[i%len(benchmarkNames)]
+					stor.UpdateCounter(key, 1)
+					stor.GetCounter(key)
+					i++
+				}
+			})
+		})
+	}
+}
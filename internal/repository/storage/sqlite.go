@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"errors"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+type sqliteDialect struct{}
+
+// SQLite is a dialect of embedded SQLite database
+var SQLite Dialect = sqliteDialect{}
+
+// NewSQLiteStorage opens database by dsn (a file path or file: URI)
+func NewSQLiteStorage(dsn string) (*DBStorage, error) {
+	// SQLite allows a single writer, and every connection to :memory: is a separate database,
+	// so the only connection is kept open
+	return NewDBStorage(SQLite, dsn, PoolConfig{MaxOpenConns: 1, MaxIdleConns: 1})
+}
+
+func (sqliteDialect) DriverName() string {
+	return "sqlite"
+}
+
+func (sqliteDialect) Rebind(query string) string {
+	return query
+}
+
+func (sqliteDialect) Migrations() []Migration {
+	return []Migration{
+		{
+			// tables may exist already, they were created before migrations were introduced
+			Version: 1,
+			Statements: []string{
+				`CREATE TABLE IF NOT EXISTS gauges (name TEXT PRIMARY KEY, value REAL NOT NULL)`,
+				`CREATE TABLE IF NOT EXISTS counters (name TEXT PRIMARY KEY, value INTEGER NOT NULL)`,
+				`CREATE TABLE IF NOT EXISTS histograms (name TEXT PRIMARY KEY, data TEXT NOT NULL)`,
+				`CREATE TABLE IF NOT EXISTS summaries (name TEXT PRIMARY KEY, data TEXT NOT NULL)`,
+			},
+		},
+	}
+}
+
+// IsTransient reports database locked by another connection or process
+func (sqliteDialect) IsTransient(err error) bool {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	// extended result codes keep primary one in the lowest byte
+	code := sqliteErr.Code() & 0xff
+
+	return code == sqlite3.SQLITE_BUSY || code == sqlite3.SQLITE_LOCKED
+}
+
+// ForUpdate keeps query as is: SQLite has no row locks, writing transactions are serialized by database lock
+func (sqliteDialect) ForUpdate(query string) string {
+	return query
+}
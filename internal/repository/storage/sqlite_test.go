@@ -0,0 +1,180 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mixailo/go-training-metrics/internal/service/metrics"
+)
+
+func newTestSQLiteStorage(t *testing.T) *DBStorage {
+	t.Helper()
+
+	s, err := NewSQLiteStorage(":memory:")
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+
+	return s
+}
+
+func TestSQLiteStorage_Values(t *testing.T) {
+	localStorage := newTestSQLiteStorage(t)
+
+	localStorage.UpdateCounter("c", 1)
+	localStorage.UpdateCounter("c", 2)
+	localStorage.UpdateGauge("g", 1)
+	localStorage.UpdateGauge("g", 1.5)
+
+	counter, ok := localStorage.GetCounter("c")
+	assert.True(t, ok)
+	assert.Equal(t, int64(3), counter)
+
+	gauge, ok := localStorage.GetGauge("g")
+	assert.True(t, ok)
+	assert.Equal(t, 1.5, gauge)
+
+	_, ok = localStorage.GetGauge("missing")
+	assert.False(t, ok)
+	_, ok = localStorage.GetCounter("missing")
+	assert.False(t, ok)
+
+	assert.Equal(t, map[string]int64{"c": 3}, localStorage.Counters())
+	assert.Equal(t, map[string]float64{"g": 1.5}, localStorage.Gauges())
+}
+
+func TestSQLiteStorage_Histograms(t *testing.T) {
+	localStorage := newTestSQLiteStorage(t)
+
+	require.NoError(t, localStorage.ObserveHistogram("latency", 0.3, []float64{0.1, 0.5}))
+	require.NoError(t, localStorage.ObserveHistogram("latency", 0.7, nil))
+	assert.ErrorIs(t, localStorage.ObserveHistogram("latency", 0.7, []float64{1}), metrics.ErrBucketsMismatch)
+	require.NoError(t, localStorage.MergeHistogram("latency", metrics.Histogram{
+		Bounds: []float64{0.1, 0.5},
+		Counts: []uint64{2, 0, 0},
+		Sum:    0.1,
+		Count:  2,
+	}))
+
+	h, ok := localStorage.GetHistogram("latency")
+	require.True(t, ok)
+	assert.Equal(t, []uint64{2, 1, 1}, h.Counts)
+	assert.Equal(t, uint64(4), h.Count)
+	assert.Len(t, localStorage.Histograms(), 1)
+
+	_, ok = localStorage.GetHistogram("missing")
+	assert.False(t, ok)
+}
+
+func TestSQLiteStorage_Summaries(t *testing.T) {
+	localStorage := newTestSQLiteStorage(t)
+
+	localStorage.ObserveSummary("latency", 1)
+	localStorage.ObserveSummary("latency", 2)
+
+	other, err := metrics.NewSketch(metrics.DefaultSketchAccuracy)
+	require.NoError(t, err)
+	other.Observe(3)
+	require.NoError(t, localStorage.MergeSummary("latency", other))
+
+	s, ok := localStorage.GetSummary("latency")
+	require.True(t, ok)
+	assert.Equal(t, uint64(3), s.Count)
+	assert.Equal(t, 6.0, s.Sum)
+	assert.Len(t, localStorage.Summaries(), 1)
+}
+
+func TestSQLiteStorage_UpdateBatch(t *testing.T) {
+	delta := int64(5)
+	value := 2.5
+
+	t.Run("valid batch", func(t *testing.T) {
+		localStorage := newTestSQLiteStorage(t)
+		err := localStorage.UpdateBatch([]metrics.Metrics{
+			{ID: "batch_counter", MType: metrics.TypeCounter.String(), Delta: &delta},
+			{ID: "batch_counter", MType: metrics.TypeCounter.String(), Delta: &delta},
+			{ID: "batch_gauge", MType: metrics.TypeGauge.String(), Value: &value, Labels: map[string]string{"host": "a"}},
+			{ID: "batch_summary", MType: metrics.TypeSummary.String(), Value: &value},
+		})
+		assert.NoError(t, err)
+
+		counter, ok := localStorage.GetCounter("batch_counter")
+		assert.True(t, ok)
+		assert.Equal(t, int64(10), counter)
+
+		gauge, ok := localStorage.GetGauge(`batch_gauge{host="a"}`)
+		assert.True(t, ok)
+		assert.Equal(t, value, gauge)
+
+		_, ok = localStorage.GetSummary("batch_summary")
+		assert.True(t, ok)
+	})
+
+	t.Run("failing item rolls back whole batch", func(t *testing.T) {
+		localStorage := newTestSQLiteStorage(t)
+		require.NoError(t, localStorage.ObserveHistogram("h", 0.3, []float64{0.1, 0.5}))
+
+		err := localStorage.UpdateBatch([]metrics.Metrics{
+			{ID: "batch_counter", MType: metrics.TypeCounter.String(), Delta: &delta},
+			{
+				ID:        "h",
+				MType:     metrics.TypeHistogram.String(),
+				Histogram: &metrics.Histogram{Bounds: []float64{1}, Counts: []uint64{1, 0}, Sum: 0.5, Count: 1},
+			},
+		})
+		assert.Error(t, err)
+
+		_, ok := localStorage.GetCounter("batch_counter")
+		assert.False(t, ok)
+	})
+
+	t.Run("invalid item", func(t *testing.T) {
+		localStorage := newTestSQLiteStorage(t)
+		err := localStorage.UpdateBatch([]metrics.Metrics{
+			{ID: "batch_gauge", MType: metrics.TypeGauge.String()},
+		})
+		assert.ErrorIs(t, err, ErrNotWritable)
+	})
+}
+
+func TestSQLiteStorage_Snapshot(t *testing.T) {
+	memory := NewMemStorage()
+	memory.UpdateCounter("c", 3)
+	memory.UpdateGauge("g", 1.5)
+	require.NoError(t, memory.ObserveHistogram("h", 0.3, nil))
+	memory.ObserveSummary("s", 1)
+	data, err := memory.MarshalJSON()
+	require.NoError(t, err)
+
+	localStorage := newTestSQLiteStorage(t)
+	localStorage.UpdateGauge("stale", 1)
+	require.NoError(t, localStorage.UnmarshalJSON(data))
+
+	assert.Equal(t, memory.Counters(), localStorage.Counters())
+	assert.Equal(t, memory.Gauges(), localStorage.Gauges())
+	assert.Equal(t, memory.Histograms(), localStorage.Histograms())
+	assert.Len(t, localStorage.Summaries(), 1)
+
+	encoded, err := localStorage.MarshalJSON()
+	require.NoError(t, err)
+	assert.JSONEq(t, string(data), string(encoded))
+}
+
+func TestSQLiteStorage_Durable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.db")
+
+	localStorage, err := NewSQLiteStorage(path)
+	require.NoError(t, err)
+	localStorage.UpdateCounter("c", 3)
+	require.NoError(t, localStorage.Close())
+
+	reopened, err := NewSQLiteStorage(path)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	counter, ok := reopened.GetCounter("c")
+	assert.True(t, ok)
+	assert.Equal(t, int64(3), counter)
+}
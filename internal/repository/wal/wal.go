@@ -0,0 +1,341 @@
+package wal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mixailo/go-training-metrics/internal/service/metrics"
+)
+
+// Operations of storage kept in log
+const (
+	OpGauge            = "gauge"
+	OpCounter          = "counter"
+	OpObserveHistogram = "observe_histogram"
+	OpMergeHistogram   = "merge_histogram"
+	OpObserveSummary   = "observe_summary"
+	OpMergeSummary     = "merge_summary"
+	OpBatch            = "batch"
+)
+
+// Entry is a single storage operation, Key is a series key of the metric.
+// Agent and IdempotencyKey identify request of the update if it was sent with idempotency key.
+type Entry struct {
+	Op        string             `json:"op"`
+	Key       string             `json:"key,omitempty"`
+	Value     *float64           `json:"value,omitempty"`
+	Delta     *int64             `json:"delta,omitempty"`
+	Bounds    []float64          `json:"bounds,omitempty"`
+	Histogram *metrics.Histogram `json:"histogram,omitempty"`
+	Summary   *metrics.Sketch    `json:"summary,omitempty"`
+	Batch     []metrics.Metrics  `json:"batch,omitempty"`
+
+	Agent          string `json:"agent,omitempty"`
+	IdempotencyKey string `json:"idempotencyKey,omitempty"`
+}
+
+// SyncPolicy tells when appended entries are flushed to disk
+type SyncPolicy string
+
+const (
+	SyncAlways   SyncPolicy = "always"   // every append waits for fsync
+	SyncInterval SyncPolicy = "interval" // fsync is done periodically by Run
+	SyncNever    SyncPolicy = "never"    // flushing is left to operating system
+)
+
+func ParseSyncPolicy(s string) (SyncPolicy, error) {
+	switch p := SyncPolicy(s); p {
+	case SyncAlways, SyncInterval, SyncNever:
+		return p, nil
+	}
+
+	return "", fmt.Errorf("unknown sync policy %s", s)
+}
+
+// ErrCorrupt means a record in the middle of a segment cannot be read
+var ErrCorrupt = errors.New("write-ahead log is corrupt")
+
+const segmentExt = ".wal"
+
+// Log is an append-only log of storage operations split into numbered segment files.
+// Every record is a line of CRC32 of JSON encoded entries, a tab and the JSON itself.
+type Log struct {
+	mu      sync.Mutex
+	dir     string
+	policy  SyncPolicy
+	segment uint64
+	file    *os.File
+	dirty   bool // there are appended records which were not synced
+}
+
+// Open creates dir if needed and starts a new segment following existing ones,
+// so records of previous runs stay untouched until they are removed after compaction
+func Open(dir string, policy SyncPolicy) (*Log, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	segments, err := Segments(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Log{dir: dir, policy: policy}
+	next := uint64(1)
+	if len(segments) > 0 {
+		next = segments[len(segments)-1] + 1
+	}
+	if err = l.openSegment(next); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+func segmentPath(dir string, segment uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d%s", segment, segmentExt))
+}
+
+func (l *Log) openSegment(segment uint64) error {
+	file, err := os.OpenFile(segmentPath(l.dir, segment), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	l.file = file
+	l.segment = segment
+
+	return nil
+}
+
+// Append writes entries as a single record, they are replayed all together or not at all
+func (l *Log) Append(entries ...Entry) error {
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	record := make([]byte, 0, len(data)+10)
+	record = append(record, fmt.Sprintf("%08x\t", crc32.ChecksumIEEE(data))...)
+	record = append(record, data...)
+	record = append(record, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, err = l.file.Write(record); err != nil {
+		return err
+	}
+	if l.policy == SyncAlways {
+		return l.file.Sync()
+	}
+	l.dirty = true
+
+	return nil
+}
+
+// Sync flushes appended records to disk
+func (l *Log) Sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.sync()
+}
+
+func (l *Log) sync() error {
+	if !l.dirty {
+		return nil
+	}
+	l.dirty = false
+
+	return l.file.Sync()
+}
+
+// Run syncs log every interval until context is done, it does nothing unless policy is SyncInterval
+func (l *Log) Run(ctx context.Context, interval time.Duration, onError func(err error)) {
+	if l.policy != SyncInterval {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := l.Sync(); err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}
+}
+
+// Rotate closes current segment and starts the next one, it returns number of the closed segment
+func (l *Log) Rotate() (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	closed := l.segment
+	if err := l.file.Sync(); err != nil {
+		return closed, err
+	}
+	l.dirty = false
+	if err := l.file.Close(); err != nil {
+		return closed, err
+	}
+
+	return closed, l.openSegment(closed + 1)
+}
+
+// Remove deletes segments up to the given one, which are covered by a snapshot
+func (l *Log) Remove(upTo uint64) error {
+	segments, err := Segments(l.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, segment := range segments {
+		if segment > upTo {
+			break
+		}
+		if err = os.Remove(segmentPath(l.dir, segment)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Segment returns number of the segment being written
+func (l *Log) Segment() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.segment
+}
+
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.sync(); err != nil {
+		l.file.Close()
+		return err
+	}
+
+	return l.file.Close()
+}
+
+// Segments returns numbers of segments in dir in ascending order
+func Segments(dir string) ([]uint64, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	segments := make([]uint64, 0, len(files))
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		segment, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, segment)
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i] < segments[j] })
+
+	return segments, nil
+}
+
+// Replay passes records of segments following the given one to apply in order.
+// A broken record at the end of a segment is a write interrupted by crash and is skipped,
+// a broken record followed by valid ones fails replay with ErrCorrupt.
+func Replay(dir string, after uint64, apply func(entries []Entry) error) (records int, err error) {
+	segments, err := Segments(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, segment := range segments {
+		if segment <= after {
+			continue
+		}
+		n, err := replaySegment(segmentPath(dir, segment), apply)
+		records += n
+		if err != nil {
+			return records, fmt.Errorf("segment %d: %w", segment, err)
+		}
+	}
+
+	return records, nil
+}
+
+func replaySegment(path string, apply func(entries []Entry) error) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	var (
+		records int
+		broken  bool
+	)
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) == 0 && err != nil {
+			break
+		}
+
+		entries, ok := decodeRecord(line)
+		if !ok {
+			broken = true
+			continue
+		}
+		if broken {
+			return records, ErrCorrupt
+		}
+		if err := apply(entries); err != nil {
+			return records, err
+		}
+		records++
+	}
+
+	return records, nil
+}
+
+func decodeRecord(line []byte) ([]Entry, bool) {
+	if len(line) == 0 || line[len(line)-1] != '\n' {
+		return nil, false
+	}
+	sum, data, found := bytes.Cut(bytes.TrimSuffix(line, []byte{'\n'}), []byte{'\t'})
+	if !found {
+		return nil, false
+	}
+	expected, err := strconv.ParseUint(string(sum), 16, 32)
+	if err != nil || uint32(expected) != crc32.ChecksumIEEE(data) {
+		return nil, false
+	}
+
+	var entries []Entry
+	if err = json.Unmarshal(data, &entries); err != nil {
+		return nil, false
+	}
+
+	return entries, true
+}
@@ -0,0 +1,140 @@
+package wal
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func gauge(key string, v float64) Entry {
+	return Entry{Op: OpGauge, Key: key, Value: &v}
+}
+
+func counter(key string, d int64) Entry {
+	return Entry{Op: OpCounter, Key: key, Delta: &d}
+}
+
+func replayAll(t *testing.T, dir string, after uint64) ([][]Entry, error) {
+	t.Helper()
+
+	var records [][]Entry
+	_, err := Replay(dir, after, func(entries []Entry) error {
+		records = append(records, entries)
+		return nil
+	})
+
+	return records, err
+}
+
+func TestLog_AppendReplay(t *testing.T) {
+	for _, policy := range []SyncPolicy{SyncAlways, SyncInterval, SyncNever} {
+		t.Run(string(policy), func(t *testing.T) {
+			dir := t.TempDir()
+			l, err := Open(dir, policy)
+			require.NoError(t, err)
+
+			require.NoError(t, l.Append(gauge("g", 1.5)))
+			require.NoError(t, l.Append(counter("c", 1), counter("c", 2)))
+			require.NoError(t, l.Close())
+
+			records, err := replayAll(t, dir, 0)
+			require.NoError(t, err)
+			assert.Equal(t, [][]Entry{{gauge("g", 1.5)}, {counter("c", 1), counter("c", 2)}}, records)
+		})
+	}
+}
+
+func TestLog_Segments(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir, SyncNever)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), l.Segment())
+
+	require.NoError(t, l.Append(counter("c", 1)))
+	closed, err := l.Rotate()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), closed)
+	require.NoError(t, l.Append(counter("c", 2)))
+
+	records, err := replayAll(t, dir, closed)
+	require.NoError(t, err)
+	assert.Equal(t, [][]Entry{{counter("c", 2)}}, records)
+
+	require.NoError(t, l.Remove(closed))
+	segments, err := Segments(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{2}, segments)
+	require.NoError(t, l.Close())
+
+	t.Run("reopened log starts a new segment", func(t *testing.T) {
+		l, err := Open(dir, SyncNever)
+		require.NoError(t, err)
+		defer l.Close()
+		assert.Equal(t, uint64(3), l.Segment())
+
+		records, err := replayAll(t, dir, 0)
+		require.NoError(t, err)
+		assert.Equal(t, [][]Entry{{counter("c", 2)}}, records)
+	})
+}
+
+func TestReplay_Broken(t *testing.T) {
+	write := func(t *testing.T, tail string) string {
+		dir := t.TempDir()
+		l, err := Open(dir, SyncAlways)
+		require.NoError(t, err)
+		require.NoError(t, l.Append(counter("c", 1)))
+		require.NoError(t, l.Close())
+
+		f, err := os.OpenFile(segmentPath(dir, 1), os.O_APPEND|os.O_WRONLY, 0644)
+		require.NoError(t, err)
+		_, err = f.WriteString(tail)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+
+		return dir
+	}
+
+	tests := []struct {
+		name    string
+		tail    string
+		records int
+		wantErr error
+	}{
+		{name: "interrupted write", tail: `00000000	[{"op":"cou`, records: 1},
+		{name: "wrong checksum at the end", tail: "00000000\t[]\n", records: 1},
+		{name: "broken record in the middle", tail: "00000000\t[]\n" + validRecord(t), records: 1, wantErr: ErrCorrupt},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := replayAll(t, write(t, tt.tail), 0)
+			assert.ErrorIs(t, err, tt.wantErr)
+			assert.Len(t, records, tt.records)
+		})
+	}
+}
+
+// validRecord returns a record as it is written by Append
+func validRecord(t *testing.T) string {
+	dir := t.TempDir()
+	l, err := Open(dir, SyncNever)
+	require.NoError(t, err)
+	require.NoError(t, l.Append(counter("c", 2)))
+	require.NoError(t, l.Close())
+
+	data, err := os.ReadFile(segmentPath(dir, 1))
+	require.NoError(t, err)
+
+	return string(data)
+}
+
+func TestParseSyncPolicy(t *testing.T) {
+	p, err := ParseSyncPolicy("always")
+	assert.NoError(t, err)
+	assert.Equal(t, SyncAlways, p)
+
+	_, err = ParseSyncPolicy("sometimes")
+	assert.Error(t, err)
+}
@@ -0,0 +1,168 @@
+package alerting
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mixailo/go-training-metrics/internal/service/metrics"
+)
+
+type State string
+
+const (
+	StateInactive State = "inactive"
+	StatePending  State = "pending"
+	StateFiring   State = "firing"
+	StateResolved State = "resolved"
+)
+
+// Alert is a state of a rule
+type Alert struct {
+	Rule       string            `json:"rule"`
+	Metric     string            `json:"metric"`
+	Type       string            `json:"type"`
+	Labels     map[string]string `json:"labels"`
+	State      State             `json:"state"`
+	Value      float64           `json:"value"`
+	Threshold  float64           `json:"threshold"`
+	Op         string            `json:"op"`
+	ActiveAt   time.Time         `json:"activeAt"`
+	FiredAt    *time.Time        `json:"firedAt,omitempty"`
+	ResolvedAt *time.Time        `json:"resolvedAt,omitempty"`
+}
+
+// Storage provides current metric values
+type Storage interface {
+	GetGauge(name string) (val float64, ok bool)
+	GetCounter(name string) (val int64, ok bool)
+}
+
+// Engine evaluates rules against storage and tracks state of their alerts
+type Engine struct {
+	mu     sync.RWMutex
+	rules  []Rule
+	stor   Storage
+	alerts map[string]*Alert // by rule name
+}
+
+func NewEngine(rules []Rule, stor Storage) *Engine {
+	return &Engine{
+		rules:  rules,
+		stor:   stor,
+		alerts: make(map[string]*Alert),
+	}
+}
+
+// Evaluate checks every rule at the given moment and returns alerts which changed their state
+func (e *Engine) Evaluate(now time.Time) []Alert {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	changed := make([]Alert, 0)
+	for i := range e.rules {
+		rule := &e.rules[i]
+		value, ok := e.value(rule)
+		active := ok && comparisons[rule.Op](value, rule.Threshold)
+
+		alert, tracked := e.alerts[rule.Name]
+		switch {
+		case active && (!tracked || alert.State == StateResolved):
+			alert = &Alert{
+				Rule:      rule.Name,
+				Metric:    rule.Metric,
+				Type:      rule.Type,
+				Labels:    rule.alertLabels(),
+				State:     StatePending,
+				Threshold: rule.Threshold,
+				Op:        rule.Op,
+				ActiveAt:  now,
+			}
+			e.alerts[rule.Name] = alert
+			if rule.For == 0 {
+				fire(alert, now)
+			}
+			alert.Value = value
+			changed = append(changed, alert.copy())
+		case active:
+			alert.Value = value
+			if alert.State == StatePending && now.Sub(alert.ActiveAt) >= time.Duration(rule.For) {
+				fire(alert, now)
+				changed = append(changed, alert.copy())
+			}
+		case tracked && alert.State == StatePending:
+			// condition did not hold long enough
+			delete(e.alerts, rule.Name)
+		case tracked && alert.State == StateFiring:
+			alert.State = StateResolved
+			alert.ResolvedAt = &now
+			if ok {
+				alert.Value = value
+			}
+			changed = append(changed, alert.copy())
+		}
+	}
+
+	return changed
+}
+
+func fire(alert *Alert, now time.Time) {
+	alert.State = StateFiring
+	alert.FiredAt = &now
+}
+
+func (e *Engine) value(rule *Rule) (float64, bool) {
+	if rule.Type == metrics.TypeCounter.String() {
+		v, ok := e.stor.GetCounter(rule.series())
+		return float64(v), ok
+	}
+
+	return e.stor.GetGauge(rule.series())
+}
+
+// Alerts returns pending and firing alerts sorted by rule name
+func (e *Engine) Alerts() []Alert {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	result := make([]Alert, 0, len(e.alerts))
+	for _, alert := range e.alerts {
+		if alert.State == StatePending || alert.State == StateFiring {
+			result = append(result, alert.copy())
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Rule < result[j].Rule
+	})
+
+	return result
+}
+
+// Run evaluates rules every interval until context is done, alerts changed by evaluation are passed to onEvaluate
+func (e *Engine) Run(ctx context.Context, interval time.Duration, onEvaluate func(changed []Alert)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			changed := e.Evaluate(now)
+			if onEvaluate != nil {
+				onEvaluate(changed)
+			}
+		}
+	}
+}
+
+func (a *Alert) copy() Alert {
+	c := *a
+	c.Labels = make(map[string]string, len(a.Labels))
+	for k, v := range a.Labels {
+		c.Labels[k] = v
+	}
+
+	return c
+}
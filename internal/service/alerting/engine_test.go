@@ -0,0 +1,96 @@
+package alerting
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeStorage struct {
+	gauges   map[string]float64
+	counters map[string]int64
+}
+
+func (s *fakeStorage) GetGauge(name string) (float64, bool) {
+	v, ok := s.gauges[name]
+	return v, ok
+}
+
+func (s *fakeStorage) GetCounter(name string) (int64, bool) {
+	v, ok := s.counters[name]
+	return v, ok
+}
+
+func TestEngine_Evaluate(t *testing.T) {
+	stor := &fakeStorage{gauges: map[string]float64{"Alloc": 10}, counters: map[string]int64{}}
+	engine := NewEngine([]Rule{
+		{Name: "HighAlloc", Metric: "Alloc", Type: "gauge", Op: ">", Threshold: 100, For: Duration(time.Minute)},
+	}, stor)
+	start := time.Unix(1000, 0)
+
+	assert.Empty(t, engine.Evaluate(start))
+	assert.Empty(t, engine.Alerts())
+
+	// condition holds, alert is pending
+	stor.gauges["Alloc"] = 200
+	changed := engine.Evaluate(start.Add(10 * time.Second))
+	require.Len(t, changed, 1)
+	assert.Equal(t, StatePending, changed[0].State)
+	assert.Equal(t, 200.0, changed[0].Value)
+	require.Len(t, engine.Alerts(), 1)
+
+	// not long enough yet
+	assert.Empty(t, engine.Evaluate(start.Add(30*time.Second)))
+
+	// firing after "for" duration
+	changed = engine.Evaluate(start.Add(70 * time.Second))
+	require.Len(t, changed, 1)
+	assert.Equal(t, StateFiring, changed[0].State)
+	assert.Equal(t, start.Add(70*time.Second), *changed[0].FiredAt)
+	assert.Equal(t, StateFiring, engine.Alerts()[0].State)
+
+	// resolved when condition stops holding
+	stor.gauges["Alloc"] = 50
+	changed = engine.Evaluate(start.Add(80 * time.Second))
+	require.Len(t, changed, 1)
+	assert.Equal(t, StateResolved, changed[0].State)
+	assert.Empty(t, engine.Alerts())
+
+	// pending again
+	stor.gauges["Alloc"] = 500
+	changed = engine.Evaluate(start.Add(90 * time.Second))
+	require.Len(t, changed, 1)
+	assert.Equal(t, StatePending, changed[0].State)
+	assert.Nil(t, changed[0].ResolvedAt)
+}
+
+func TestEngine_PendingReset(t *testing.T) {
+	stor := &fakeStorage{gauges: map[string]float64{"Alloc": 200}, counters: map[string]int64{}}
+	engine := NewEngine([]Rule{
+		{Name: "HighAlloc", Metric: "Alloc", Type: "gauge", Op: ">", Threshold: 100, For: Duration(time.Minute)},
+	}, stor)
+	start := time.Unix(1000, 0)
+
+	engine.Evaluate(start)
+	stor.gauges["Alloc"] = 10
+	assert.Empty(t, engine.Evaluate(start.Add(30*time.Second)), "pending alert is dropped silently")
+
+	stor.gauges["Alloc"] = 200
+	engine.Evaluate(start.Add(40 * time.Second))
+	assert.Empty(t, engine.Evaluate(start.Add(90*time.Second)), "pending period starts over")
+}
+
+func TestEngine_CounterWithoutFor(t *testing.T) {
+	stor := &fakeStorage{gauges: map[string]float64{}, counters: map[string]int64{`PollCount{host="a"}`: 20}}
+	engine := NewEngine([]Rule{
+		{Name: "Polls", Metric: "PollCount", Type: "counter", Match: map[string]string{"host": "a"}, Op: ">=", Threshold: 20},
+		{Name: "Missing", Metric: "None", Type: "gauge", Op: "<", Threshold: 1},
+	}, stor)
+
+	changed := engine.Evaluate(time.Unix(1000, 0))
+	require.Len(t, changed, 1)
+	assert.Equal(t, StateFiring, changed[0].State)
+	assert.Equal(t, map[string]string{"alertname": "Polls", "host": "a"}, changed[0].Labels)
+}
@@ -0,0 +1,123 @@
+package alerting
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/mixailo/go-training-metrics/internal/service/metrics"
+)
+
+// Duration is time.Duration written as a string like "1m30s" in rule files
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Rule fires an alert when the metric compared with threshold holds for the For duration
+type Rule struct {
+	Name      string            `json:"name"`
+	Metric    string            `json:"metric"`
+	Type      string            `json:"type"`            // gauge or counter, gauge by default
+	Match     map[string]string `json:"match,omitempty"` // labels of the series
+	Op        string            `json:"op"`
+	Threshold float64           `json:"threshold"`
+	For       Duration          `json:"for"`
+	Labels    map[string]string `json:"labels,omitempty"` // extra labels of the alert
+}
+
+type rulesFile struct {
+	Rules []Rule `json:"rules"`
+}
+
+// LoadRules reads rules from JSON file like {"rules": [{"name": "HighAlloc", "metric": "Alloc", "op": ">", "threshold": 1e9, "for": "1m"}]}
+func LoadRules(path string) ([]Rule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var f rulesFile
+	if err = json.Unmarshal(data, &f); err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool)
+	for i := range f.Rules {
+		if f.Rules[i].Type == "" {
+			f.Rules[i].Type = metrics.TypeGauge.String()
+		}
+		if err = f.Rules[i].Validate(); err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i, err)
+		}
+		if names[f.Rules[i].Name] {
+			return nil, fmt.Errorf("rule %d: duplicate name %s", i, f.Rules[i].Name)
+		}
+		names[f.Rules[i].Name] = true
+	}
+
+	return f.Rules, nil
+}
+
+// Validate checks that rule can be evaluated
+func (r *Rule) Validate() error {
+	if r.Name == "" || r.Metric == "" {
+		return errors.New("rule must have name and metric")
+	}
+	if r.Type != metrics.TypeGauge.String() && r.Type != metrics.TypeCounter.String() {
+		return fmt.Errorf("unsupported metric type %s", r.Type)
+	}
+	if _, ok := comparisons[r.Op]; !ok {
+		return fmt.Errorf("unknown comparison %s", r.Op)
+	}
+	if r.For < 0 {
+		return errors.New("for duration must not be negative")
+	}
+
+	return nil
+}
+
+var comparisons = map[string]func(v, threshold float64) bool{
+	">":  func(v, threshold float64) bool { return v > threshold },
+	">=": func(v, threshold float64) bool { return v >= threshold },
+	"<":  func(v, threshold float64) bool { return v < threshold },
+	"<=": func(v, threshold float64) bool { return v <= threshold },
+	"==": func(v, threshold float64) bool { return v == threshold },
+	"!=": func(v, threshold float64) bool { return v != threshold },
+}
+
+// series returns storage key of the metric the rule watches
+func (r *Rule) series() string {
+	return metrics.SeriesKey(r.Metric, r.Match)
+}
+
+// alertLabels returns labels identifying alert of the rule
+func (r *Rule) alertLabels() map[string]string {
+	labels := make(map[string]string, len(r.Match)+len(r.Labels)+1)
+	for k, v := range r.Match {
+		labels[k] = v
+	}
+	for k, v := range r.Labels {
+		labels[k] = v
+	}
+	labels["alertname"] = r.Name
+
+	return labels
+}
@@ -0,0 +1,55 @@
+package alerting
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeRules(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+
+	return path
+}
+
+func TestLoadRules(t *testing.T) {
+	path := writeRules(t, `{"rules": [
+		{"name": "HighAlloc", "metric": "Alloc", "op": ">", "threshold": 1000, "for": "1m", "labels": {"severity": "page"}},
+		{"name": "TooManyPolls", "metric": "PollCount", "type": "counter", "match": {"host": "a"}, "op": ">=", "threshold": 10, "for": "0s"}
+	]}`)
+
+	rules, err := LoadRules(path)
+	require.NoError(t, err)
+	require.Len(t, rules, 2)
+	assert.Equal(t, "gauge", rules[0].Type)
+	assert.Equal(t, Duration(time.Minute), rules[0].For)
+	assert.Equal(t, `PollCount{host="a"}`, rules[1].series())
+	assert.Equal(t, map[string]string{"alertname": "HighAlloc", "severity": "page"}, rules[0].alertLabels())
+}
+
+func TestLoadRules_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"unknown op", `{"rules": [{"name": "r", "metric": "m", "op": "~", "for": "1m"}]}`},
+		{"invalid duration", `{"rules": [{"name": "r", "metric": "m", "op": ">", "for": "1 minute"}]}`},
+		{"no metric", `{"rules": [{"name": "r", "op": ">", "for": "1m"}]}`},
+		{"unsupported type", `{"rules": [{"name": "r", "metric": "m", "type": "histogram", "op": ">", "for": "1m"}]}`},
+		{"duplicate name", `{"rules": [{"name": "r", "metric": "m", "op": ">", "for": "1m"}, {"name": "r", "metric": "n", "op": ">", "for": "1m"}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadRules(writeRules(t, tt.content))
+			assert.Error(t, err)
+		})
+	}
+
+	_, err := LoadRules(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
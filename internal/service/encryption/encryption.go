@@ -0,0 +1,154 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// KeyHeader keeps base64 encoded AES key of request body encrypted with RSA-OAEP, body is encrypted with AES-GCM
+const KeyHeader = "X-Encrypted-Key"
+
+const aesKeySize = 32
+
+var (
+	ErrNoPEM      = errors.New("no PEM block found")
+	ErrNotRSA     = errors.New("key is not an RSA key")
+	ErrCiphertext = errors.New("ciphertext is too short")
+)
+
+// Encrypt encrypts data with a new AES-256-GCM key, which is returned encrypted with RSA-OAEP of pub.
+// Data of any size is encrypted this way, while RSA alone is limited by key size.
+func Encrypt(pub *rsa.PublicKey, data []byte) (encryptedKey string, ciphertext []byte, err error) {
+	key := make([]byte, aesKeySize)
+	if _, err = rand.Read(key); err != nil {
+		return "", nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", nil, err
+	}
+
+	wrapped, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, key, nil)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// nonce is kept in front of ciphertext
+	return base64.StdEncoding.EncodeToString(wrapped), gcm.Seal(nonce, nonce, data, nil), nil
+}
+
+// Decrypt reverses Encrypt with private key
+func Decrypt(priv *rsa.PrivateKey, encryptedKey string, ciphertext []byte) ([]byte, error) {
+	wrapped, err := base64.StdEncoding.DecodeString(encryptedKey)
+	if err != nil {
+		return nil, err
+	}
+	key, err := rsa.DecryptOAEP(sha256.New(), nil, priv, wrapped, nil)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, ErrCiphertext
+	}
+
+	return gcm.Open(nil, ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():], nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != aesKeySize {
+		return nil, fmt.Errorf("invalid AES key size %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// EncodePrivateKey returns PEM encoded PKCS #1 private key
+func EncodePrivateKey(priv *rsa.PrivateKey) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)})
+}
+
+// EncodePublicKey returns PEM encoded PKIX public key
+func EncodePublicKey(pub *rsa.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+// LoadPrivateKey reads PEM encoded PKCS #1 or PKCS #8 RSA private key
+func LoadPrivateKey(path string) (*rsa.PrivateKey, error) {
+	der, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	if priv, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return priv, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	priv, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, ErrNotRSA
+	}
+
+	return priv, nil
+}
+
+// LoadPublicKey reads PEM encoded PKIX or PKCS #1 RSA public key
+func LoadPublicKey(path string) (*rsa.PublicKey, error) {
+	der, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	if pub, err := x509.ParsePKCS1PublicKey(der); err == nil {
+		return pub, nil
+	}
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, err
+	}
+	pub, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, ErrNotRSA
+	}
+
+	return pub, nil
+}
+
+func readPEM(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%w in %s", ErrNoPEM, path)
+	}
+
+	return block.Bytes, nil
+}
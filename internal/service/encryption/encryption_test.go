@@ -0,0 +1,93 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncrypt(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"small", []byte(`{"id":"c","type":"counter","delta":1}`)},
+		{"larger than RSA key", bytes.Repeat([]byte("x"), 1<<20)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, ciphertext, err := Encrypt(&priv.PublicKey, tt.data)
+			require.NoError(t, err)
+			if len(tt.data) > 0 {
+				assert.False(t, bytes.Contains(ciphertext, tt.data))
+			}
+
+			decrypted, err := Decrypt(priv, key, ciphertext)
+			require.NoError(t, err)
+			assert.Equal(t, len(tt.data), len(decrypted))
+			assert.True(t, bytes.Equal(tt.data, decrypted))
+		})
+	}
+}
+
+func TestDecrypt_Invalid(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	key, ciphertext, err := Encrypt(&priv.PublicKey, []byte("data"))
+	require.NoError(t, err)
+
+	_, err = Decrypt(other, key, ciphertext)
+	assert.Error(t, err, "other private key")
+
+	altered := bytes.Clone(ciphertext)
+	altered[len(altered)-1] ^= 1
+	_, err = Decrypt(priv, key, altered)
+	assert.Error(t, err, "altered ciphertext")
+
+	_, err = Decrypt(priv, key, ciphertext[:5])
+	assert.ErrorIs(t, err, ErrCiphertext)
+
+	_, err = Decrypt(priv, "not base64!", ciphertext)
+	assert.Error(t, err)
+}
+
+func TestLoadKeys(t *testing.T) {
+	dir := t.TempDir()
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	pubPEM, err := EncodePublicKey(&priv.PublicKey)
+	require.NoError(t, err)
+
+	privPath, pubPath := filepath.Join(dir, "private.pem"), filepath.Join(dir, "public.pem")
+	require.NoError(t, os.WriteFile(privPath, EncodePrivateKey(priv), 0600))
+	require.NoError(t, os.WriteFile(pubPath, pubPEM, 0644))
+
+	loadedPriv, err := LoadPrivateKey(privPath)
+	require.NoError(t, err)
+	assert.True(t, priv.Equal(loadedPriv))
+	loadedPub, err := LoadPublicKey(pubPath)
+	require.NoError(t, err)
+	assert.True(t, priv.PublicKey.Equal(loadedPub))
+
+	_, err = LoadPrivateKey(pubPath)
+	assert.Error(t, err, "public key is not private one")
+	garbage := filepath.Join(dir, "garbage.pem")
+	require.NoError(t, os.WriteFile(garbage, []byte("garbage"), 0644))
+	_, err = LoadPublicKey(garbage)
+	assert.ErrorIs(t, err, ErrNoPEM)
+	_, err = LoadPublicKey(filepath.Join(dir, "missing.pem"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
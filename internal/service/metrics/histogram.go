@@ -0,0 +1,209 @@
+package metrics
+
+import (
+	"errors"
+	"math"
+	"sort"
+)
+
+// DefaultBuckets are upper bounds of histogram buckets used when no bounds are given
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+var (
+	ErrInvalidBuckets  = errors.New("histogram bounds must be finite and strictly increasing")
+	ErrInvalidCounts   = errors.New("histogram counts do not match its bounds or total count")
+	ErrBucketsMismatch = errors.New("histogram bounds differ")
+	ErrInvalidValue    = errors.New("observed value must be finite")
+)
+
+// Histogram keeps number of observations per bucket. Counts are not cumulative,
+// Counts[i] is a number of observations in (Bounds[i-1], Bounds[i]],
+// the last one counts observations above the highest bound (+Inf bucket).
+type Histogram struct {
+	Bounds []float64 `json:"bounds"`
+	Counts []uint64  `json:"counts"`
+	Sum    float64   `json:"sum"`
+	Count  uint64    `json:"count"`
+}
+
+// NewHistogram creates empty histogram with given bucket upper bounds or DefaultBuckets if bounds are empty
+func NewHistogram(bounds []float64) (Histogram, error) {
+	if len(bounds) == 0 {
+		bounds = DefaultBuckets
+	}
+	if !validBounds(bounds) {
+		return Histogram{}, ErrInvalidBuckets
+	}
+
+	return Histogram{
+		Bounds: append([]float64(nil), bounds...),
+		Counts: make([]uint64, len(bounds)+1),
+	}, nil
+}
+
+// finite tells if v is neither infinity nor NaN
+func finite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+func validBounds(bounds []float64) bool {
+	for i, b := range bounds {
+		if !finite(b) {
+			return false
+		}
+		if i > 0 && bounds[i-1] >= b {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Validate checks that histogram is consistent
+func (h *Histogram) Validate() error {
+	if !validBounds(h.Bounds) {
+		return ErrInvalidBuckets
+	}
+	if len(h.Counts) != len(h.Bounds)+1 {
+		return ErrInvalidCounts
+	}
+
+	var total uint64
+	for _, c := range h.Counts {
+		total += c
+	}
+	if total != h.Count {
+		return ErrInvalidCounts
+	}
+	if !finite(h.Sum) {
+		return ErrInvalidValue
+	}
+
+	return nil
+}
+
+// Observe adds a single observation to the histogram, infinity and NaN are rejected as they would break its sum,
+// so are values which would overflow the sum
+func (h *Histogram) Observe(v float64) error {
+	if !finite(v) || !finite(h.Sum+v) {
+		return ErrInvalidValue
+	}
+
+	i := sort.SearchFloat64s(h.Bounds, v)
+	h.Counts[i]++
+	h.Sum += v
+	h.Count++
+
+	return nil
+}
+
+// Merge adds observations of other histogram with the same bounds
+func (h *Histogram) Merge(other Histogram) error {
+	if !equalBounds(h.Bounds, other.Bounds) {
+		return ErrBucketsMismatch
+	}
+	if err := other.Validate(); err != nil {
+		return err
+	}
+	if !finite(h.Sum + other.Sum) {
+		return ErrInvalidValue
+	}
+
+	for i, c := range other.Counts {
+		h.Counts[i] += c
+	}
+	h.Sum += other.Sum
+	h.Count += other.Count
+
+	return nil
+}
+
+// Copy returns a deep copy of the histogram
+func (h *Histogram) Copy() Histogram {
+	return Histogram{
+		Bounds: append([]float64(nil), h.Bounds...),
+		Counts: append([]uint64(nil), h.Counts...),
+		Sum:    h.Sum,
+		Count:  h.Count,
+	}
+}
+
+// Cumulative returns count of observations less or equal to each bound, including +Inf
+func (h *Histogram) Cumulative() []uint64 {
+	result := make([]uint64, len(h.Counts))
+	var total uint64
+	for i, c := range h.Counts {
+		total += c
+		result[i] = total
+	}
+
+	return result
+}
+
+func equalBounds(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ObserveHistogram returns stored histogram with the value observed. New histogram with given bounds
+// is created if stored is nil, otherwise bounds, if any, must match stored ones.
+func ObserveHistogram(stored *Histogram, value float64, bounds []float64) (Histogram, error) {
+	var (
+		h   Histogram
+		err error
+	)
+	if stored == nil {
+		h, err = NewHistogram(bounds)
+		if err != nil {
+			return h, err
+		}
+	} else {
+		if len(bounds) > 0 && !equalBounds(bounds, stored.Bounds) {
+			return h, ErrBucketsMismatch
+		}
+		h = stored.Copy()
+	}
+	if err = h.Observe(value); err != nil {
+		return Histogram{}, err
+	}
+
+	return h, nil
+}
+
+// MergeHistogram returns stored histogram merged with other one, or a copy of other if stored is nil
+func MergeHistogram(stored *Histogram, other Histogram) (Histogram, error) {
+	if err := other.Validate(); err != nil {
+		return Histogram{}, err
+	}
+	if stored == nil {
+		return other.Copy(), nil
+	}
+
+	h := stored.Copy()
+	err := h.Merge(other)
+
+	return h, err
+}
+
+// ApplyHistogram applies histogram metric to stored histogram (nil if there is none yet).
+// Metric carries either a single observation in Value with optional bounds in Histogram,
+// or a pre-bucketed Histogram to merge.
+func (m *Metrics) ApplyHistogram(stored *Histogram) (Histogram, error) {
+	if m.Value != nil {
+		var bounds []float64
+		if m.Histogram != nil {
+			bounds = m.Histogram.Bounds
+		}
+		return ObserveHistogram(stored, *m.Value, bounds)
+	}
+
+	return MergeHistogram(stored, *m.Histogram)
+}
@@ -0,0 +1,119 @@
+package metrics
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewHistogram(t *testing.T) {
+	tests := []struct {
+		name    string
+		bounds  []float64
+		want    []float64
+		wantErr bool
+	}{
+		{"default", nil, DefaultBuckets, false},
+		{"custom", []float64{1, 2, 5}, []float64{1, 2, 5}, false},
+		{"not increasing", []float64{1, 1}, nil, true},
+		{"infinite", []float64{1, math.Inf(1)}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := NewHistogram(tt.bounds)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidBuckets)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, h.Bounds)
+			assert.Len(t, h.Counts, len(tt.want)+1)
+		})
+	}
+}
+
+func TestHistogram_Observe(t *testing.T) {
+	h, err := NewHistogram([]float64{1, 2})
+	require.NoError(t, err)
+
+	for _, v := range []float64{0.5, 1, 1.5, 3} {
+		require.NoError(t, h.Observe(v))
+	}
+	for _, v := range []float64{math.Inf(1), math.Inf(-1), math.NaN()} {
+		assert.ErrorIs(t, h.Observe(v), ErrInvalidValue)
+	}
+
+	assert.Equal(t, []uint64{2, 1, 1}, h.Counts)
+	assert.Equal(t, []uint64{2, 3, 4}, h.Cumulative())
+	assert.Equal(t, uint64(4), h.Count)
+	assert.Equal(t, 6.0, h.Sum)
+	assert.NoError(t, h.Validate())
+
+	// sum of finite values may overflow
+	require.NoError(t, h.Observe(math.MaxFloat64))
+	assert.ErrorIs(t, h.Observe(math.MaxFloat64), ErrInvalidValue)
+	assert.Equal(t, uint64(5), h.Count, "rejected value is not observed")
+	assert.False(t, math.IsInf(h.Sum, 0))
+}
+
+func TestHistogram_Merge(t *testing.T) {
+	h, _ := NewHistogram([]float64{1, 2})
+	h.Observe(0.5)
+
+	err := h.Merge(Histogram{Bounds: []float64{1, 2}, Counts: []uint64{1, 2, 3}, Sum: 10, Count: 6})
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{2, 2, 3}, h.Counts)
+	assert.Equal(t, uint64(7), h.Count)
+
+	err = h.Merge(Histogram{Bounds: []float64{1, 3}, Counts: []uint64{1, 0, 0}, Count: 1})
+	assert.ErrorIs(t, err, ErrBucketsMismatch)
+
+	err = h.Merge(Histogram{Bounds: []float64{1, 2}, Counts: []uint64{1, 0, 0}, Count: 2})
+	assert.ErrorIs(t, err, ErrInvalidCounts)
+
+	err = h.Merge(Histogram{Bounds: []float64{1, 2}, Counts: []uint64{0, 0, 1}, Sum: math.Inf(1), Count: 1})
+	assert.ErrorIs(t, err, ErrInvalidValue)
+
+	h.Sum = math.MaxFloat64
+	err = h.Merge(Histogram{Bounds: []float64{1, 2}, Counts: []uint64{0, 0, 1}, Sum: math.MaxFloat64, Count: 1})
+	assert.ErrorIs(t, err, ErrInvalidValue)
+	assert.Equal(t, uint64(7), h.Count, "rejected histogram is not merged")
+}
+
+func TestMetrics_ApplyHistogram(t *testing.T) {
+	v := 1.5
+
+	observation := Metrics{ID: "h", MType: TypeHistogram.String(), Value: &v, Histogram: &Histogram{Bounds: []float64{1, 2}}}
+	h, err := observation.ApplyHistogram(nil)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{0, 1, 0}, h.Counts)
+
+	// stored histogram is not modified
+	stored := h
+	h, err = observation.ApplyHistogram(&stored)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{0, 2, 0}, h.Counts)
+	assert.Equal(t, []uint64{0, 1, 0}, stored.Counts)
+
+	mismatch := Metrics{ID: "h", MType: TypeHistogram.String(), Value: &v, Histogram: &Histogram{Bounds: []float64{5}}}
+	_, err = mismatch.ApplyHistogram(&stored)
+	assert.ErrorIs(t, err, ErrBucketsMismatch)
+
+	buckets := Metrics{ID: "h", MType: TypeHistogram.String(), Histogram: &Histogram{Bounds: []float64{1, 2}, Counts: []uint64{1, 0, 1}, Sum: 3, Count: 2}}
+	h, err = buckets.ApplyHistogram(&stored)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{1, 1, 1}, h.Counts)
+}
+
+func TestMetrics_IsWritableHistogram(t *testing.T) {
+	v := 1.0
+
+	assert.True(t, (&Metrics{ID: "h", MType: TypeHistogram.String(), Value: &v}).IsWritable())
+	assert.False(t, (&Metrics{ID: "h", MType: TypeHistogram.String()}).IsWritable())
+	inf := math.Inf(1)
+	assert.False(t, (&Metrics{ID: "h", MType: TypeHistogram.String(), Value: &inf}).IsWritable())
+	assert.False(t, (&Metrics{ID: "h", MType: TypeHistogram.String(), Value: &v, Histogram: &Histogram{Bounds: []float64{2, 1}}}).IsWritable())
+	assert.False(t, (&Metrics{ID: "h", MType: TypeHistogram.String(), Histogram: &Histogram{Bounds: []float64{1}, Counts: []uint64{1}}}).IsWritable())
+}
@@ -9,36 +9,61 @@ import (
 type Type string
 
 const (
-	TypeCounter Type = "counter"
-	TypeGauge   Type = "gauge"
+	TypeCounter   Type = "counter"
+	TypeGauge     Type = "gauge"
+	TypeHistogram Type = "histogram"
+	TypeSummary   Type = "summary"
 )
 
 func (t Type) String() string {
 	return string(t)
 }
 
+// Headers of update requests which make their retries idempotent: server applies request with the same
+// key of the same agent only once
+const (
+	HeaderAgentID        = "X-Agent-ID"
+	HeaderIdempotencyKey = "Idempotency-Key"
+)
+
 type Report struct {
 	value     map[string]Metrics
 	hasErrors bool
 	errors    []error
+	seq       uint64 // sequence number of report, idempotency keys of its requests are derived from it
 }
 
 type Metrics struct {
-	ID    string   `json:"id"`              // имя метрики
-	MType string   `json:"type"`            // параметр, принимающий значение gauge или counter
-	Delta *int64   `json:"delta,omitempty"` // значение метрики в случае передачи counter
-	Value *float64 `json:"value,omitempty"` // значение метрики в случае передачи gauge
+	ID     string            `json:"id"`               // имя метрики
+	MType  string            `json:"type"`             // параметр, принимающий значение gauge или counter
+	Delta  *int64            `json:"delta,omitempty"`  // значение метрики в случае передачи counter
+	Value  *float64          `json:"value,omitempty"`  // значение метрики в случае передачи gauge
+	Labels map[string]string `json:"labels,omitempty"` // метки, различающие серии одной метрики
+
+	Histogram *Histogram `json:"histogram,omitempty"` // корзины histogram, либо границы корзин для наблюдения в Value
+	Summary   *Sketch    `json:"summary,omitempty"`   // состояние summary
+	Quantile  *float64   `json:"quantile,omitempty"`  // запрашиваемый квантиль summary, оценка возвращается в Value
 }
 
 // IsWritable check if Metrics is ok for storing
 func (m *Metrics) IsWritable() bool {
-	if m.ID == "" {
+	if m.ID == "" || !m.hasValidLabels() {
 		return false
 	}
 	if m.MType == TypeCounter.String() && m.Delta != nil {
 		return true
 	} else if m.MType == TypeGauge.String() && m.Value != nil {
 		return true
+	} else if m.MType == TypeHistogram.String() {
+		if m.Value != nil {
+			return finite(*m.Value) && (m.Histogram == nil || validBounds(m.Histogram.Bounds))
+		}
+		return m.Histogram != nil && m.Histogram.Validate() == nil
+	} else if m.MType == TypeSummary.String() {
+		if m.Value != nil {
+			return finite(*m.Value)
+		}
+		return m.Summary != nil && m.Summary.Validate() == nil
 	}
 
 	return false
@@ -46,12 +71,15 @@ func (m *Metrics) IsWritable() bool {
 
 // IsReadable check if Metrics is ok for reading
 func (m *Metrics) IsReadable() bool {
-	if m.ID == "" {
+	if m.ID == "" || !m.hasValidLabels() {
 		return false
 	}
-	if m.MType == TypeCounter.String() || m.MType == TypeGauge.String() {
+	if m.MType == TypeCounter.String() || m.MType == TypeGauge.String() || m.MType == TypeHistogram.String() {
 		return true
 	}
+	if m.MType == TypeSummary.String() {
+		return m.Quantile == nil || (*m.Quantile >= 0 && *m.Quantile <= 1)
+	}
 	return false
 }
 
@@ -70,7 +98,7 @@ func (m *Metrics) String() string {
 		value = *m.Value
 	}
 
-	return fmt.Sprintf("%s: %s %d %f", m.ID, m.MType, delta, value)
+	return fmt.Sprintf("%s: %s %d %f", m.SeriesKey(), m.MType, delta, value)
 }
 
 func NewReport() Report {
@@ -88,10 +116,20 @@ func (r *Report) All() []Metrics {
 	return result
 }
 
+// Seq returns sequence number of report, zero means it is not assigned yet
+func (r *Report) Seq() uint64 {
+	return r.seq
+}
+
+func (r *Report) SetSeq(seq uint64) {
+	r.seq = seq
+}
+
 func (r *Report) Length() int {
 	return len(r.value)
 }
 
+// Get returns metric by its series key, which is the metric id for metrics without labels
 func (r *Report) Get(name string) (result Metrics, ok bool) {
 	result, ok = r.value[name]
 	return
@@ -112,9 +150,10 @@ func NewMetrics(counterType Type, name, value string) (res Metrics, err error) {
 			return
 		}
 		res.Delta = &v
-	} else if counterType == TypeGauge {
+	} else if counterType == TypeGauge || counterType == TypeHistogram || counterType == TypeSummary {
+		// histogram and summary value is a single observation
 		var v float64
-		res.MType = TypeGauge.String()
+		res.MType = counterType.String()
 		v, err = strconv.ParseFloat(value, 64)
 		if err != nil {
 			return
@@ -128,7 +167,7 @@ func NewMetrics(counterType Type, name, value string) (res Metrics, err error) {
 }
 
 func (r *Report) Add(metric Metrics) {
-	r.value[metric.ID] = metric
+	r.value[metric.SeriesKey()] = metric
 }
 
 func (r *Report) AddUnConverted(counterType Type, name, value string) {
@@ -140,3 +179,20 @@ func (r *Report) AddUnConverted(counterType Type, name, value string) {
 		r.hasErrors = true
 	}
 }
+
+// Merge adds metrics of other report, deltas of the same counter are summed and other metrics are replaced.
+// Sequence number of the report is kept.
+func (r *Report) Merge(other Report) {
+	for key, m := range other.value {
+		prev, ok := r.value[key]
+		if ok && m.MType == TypeCounter.String() && prev.MType == m.MType && prev.Delta != nil && m.Delta != nil {
+			sum := *prev.Delta + *m.Delta
+			m.Delta = &sum
+		}
+		r.value[key] = m
+	}
+	if other.hasErrors {
+		r.errors = append(r.errors, other.errors...)
+		r.hasErrors = true
+	}
+}
@@ -242,3 +242,30 @@ func TestNewMetrics(t *testing.T) {
 		})
 	}
 }
+
+func TestReport_Merge(t *testing.T) {
+	report := NewReport()
+	report.AddUnConverted(TypeCounter, "c", "1")
+	report.AddUnConverted(TypeGauge, "g", "1")
+	report.Add(Metrics{ID: "c", MType: TypeCounter.String(), Delta: new(int64), Labels: map[string]string{"dev": "a"}})
+
+	other := NewReport()
+	other.AddUnConverted(TypeCounter, "c", "2")
+	other.AddUnConverted(TypeGauge, "g", "2")
+	other.AddUnConverted(TypeCounter, "new", "3")
+	report.Merge(other)
+
+	assert.Equal(t, 4, report.Length())
+	c, _ := report.Get("c")
+	assert.Equal(t, int64(3), *c.Delta)
+	g, _ := report.Get("g")
+	assert.Equal(t, 2.0, *g.Value)
+	n, _ := report.Get("new")
+	assert.Equal(t, int64(3), *n.Delta)
+	labeled, _ := report.Get(`c{dev="a"}`)
+	assert.Equal(t, int64(0), *labeled.Delta)
+
+	other.AddUnConverted(TypeCounter, "c", "10")
+	c, _ = report.Get("c")
+	assert.Equal(t, int64(3), *c.Delta, "merged report does not share values")
+}
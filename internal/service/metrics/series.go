@@ -0,0 +1,113 @@
+package metrics
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SeriesKey builds canonical series identity from metric id and its labels sorted by name,
+// e.g. Alloc{host="a",region="eu"}. Key of a metric without labels equals its id.
+func SeriesKey(id string, labels map[string]string) string {
+	if len(labels) == 0 {
+		return id
+	}
+
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	sb.WriteString(id)
+	sb.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(name)
+		sb.WriteByte('=')
+		sb.WriteString(strconv.Quote(labels[name]))
+	}
+	sb.WriteByte('}')
+
+	return sb.String()
+}
+
+// ParseSeriesKey splits series key back to metric id and labels.
+// Key which cannot be parsed is treated as id of a metric without labels.
+func ParseSeriesKey(key string) (id string, labels map[string]string) {
+	start := strings.IndexByte(key, '{')
+	if start <= 0 || !strings.HasSuffix(key, "}") {
+		return key, nil
+	}
+
+	labels = make(map[string]string)
+	rest := key[start+1 : len(key)-1]
+	for len(rest) > 0 {
+		eq := strings.IndexByte(rest, '=')
+		if eq <= 0 || !IsValidLabelName(rest[:eq]) {
+			return key, nil
+		}
+		quoted, err := strconv.QuotedPrefix(rest[eq+1:])
+		if err != nil {
+			return key, nil
+		}
+		value, err := strconv.Unquote(quoted)
+		if err != nil {
+			return key, nil
+		}
+		labels[rest[:eq]] = value
+
+		rest = rest[eq+1+len(quoted):]
+		if len(rest) > 0 {
+			if rest[0] != ',' {
+				return key, nil
+			}
+			rest = rest[1:]
+		}
+	}
+
+	return key[:start], labels
+}
+
+// IsValidLabelName checks label name against [a-zA-Z_][a-zA-Z0-9_]*
+func IsValidLabelName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// SeriesKey returns canonical series identity of the metric
+func (m *Metrics) SeriesKey() string {
+	return SeriesKey(m.ID, m.Labels)
+}
+
+// IsValidID checks that metric id cannot be confused with series key of a labelled metric
+func IsValidID(id string) bool {
+	return id != "" && !strings.ContainsAny(id, "{}")
+}
+
+func (m *Metrics) hasValidLabels() bool {
+	if !IsValidID(m.ID) {
+		return false
+	}
+	for name := range m.Labels {
+		if !IsValidLabelName(name) {
+			return false
+		}
+	}
+
+	return true
+}
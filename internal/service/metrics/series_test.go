@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSeriesKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		id     string
+		labels map[string]string
+		want   string
+	}{
+		{
+			name: "no labels",
+			id:   "Alloc",
+			want: "Alloc",
+		},
+		{
+			name:   "sorted labels",
+			id:     "Alloc",
+			labels: map[string]string{"region": "eu", "host": "a"},
+			want:   `Alloc{host="a",region="eu"}`,
+		},
+		{
+			name:   "escaped value",
+			id:     "Alloc",
+			labels: map[string]string{"host": `a"b,c=d`},
+			want:   `Alloc{host="a\"b,c=d"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := SeriesKey(tt.id, tt.labels)
+			assert.Equal(t, tt.want, key)
+
+			id, labels := ParseSeriesKey(key)
+			assert.Equal(t, tt.id, id)
+			if len(tt.labels) == 0 {
+				assert.Empty(t, labels)
+			} else {
+				assert.Equal(t, tt.labels, labels)
+			}
+		})
+	}
+}
+
+func TestParseSeriesKey_Malformed(t *testing.T) {
+	for _, key := range []string{"{a=\"b\"}", `a{b="c}`, `a{1="c"}`, `a{b="c"d="e"}`, "a}"} {
+		t.Run(key, func(t *testing.T) {
+			id, labels := ParseSeriesKey(key)
+			assert.Equal(t, key, id)
+			assert.Nil(t, labels)
+		})
+	}
+}
+
+func TestMetrics_IsWritableLabels(t *testing.T) {
+	v := 1.0
+
+	valid := Metrics{ID: "Alloc", MType: TypeGauge.String(), Value: &v, Labels: map[string]string{"host": "a"}}
+	assert.True(t, valid.IsWritable())
+
+	invalidName := Metrics{ID: "Alloc", MType: TypeGauge.String(), Value: &v, Labels: map[string]string{"1host": "a"}}
+	assert.False(t, invalidName.IsWritable())
+
+	invalidID := Metrics{ID: "Alloc{}", MType: TypeGauge.String(), Value: &v, Labels: map[string]string{"host": "a"}}
+	assert.False(t, invalidID.IsWritable())
+
+	// id of label-less metric must not alias series key of a labelled one
+	aliasID := Metrics{ID: `Alloc{host="a"}`, MType: TypeGauge.String(), Value: &v}
+	assert.False(t, aliasID.IsWritable())
+	assert.False(t, aliasID.IsReadable())
+}
@@ -0,0 +1,270 @@
+package metrics
+
+import (
+	"errors"
+	"math"
+	"sort"
+)
+
+// DefaultSketchAccuracy is relative accuracy of quantiles estimated by summaries
+const DefaultSketchAccuracy = 0.01
+
+// values closer to zero than minIndexable are counted as zeros
+const minIndexable = 1e-9
+
+var (
+	ErrInvalidAccuracy = errors.New("sketch accuracy must be in (0, 1)")
+	ErrInvalidSketch   = errors.New("sketch counts do not match its total count")
+	ErrSketchMismatch  = errors.New("sketch accuracies differ")
+	ErrInvalidQuantile = errors.New("quantile must be in [0, 1]")
+	ErrEmptySketch     = errors.New("sketch has no observations")
+)
+
+// Sketch is a DDSketch: a mergeable quantile sketch with relative accuracy guarantee.
+// Observations are counted in logarithmically sized buckets, bucket i of positive values
+// holds values in (gamma^(i-1), gamma^i] where gamma = (1+Accuracy)/(1-Accuracy),
+// negative values are kept by their absolute value the same way.
+// Number of buckets grows with logarithm of the observed values range.
+type Sketch struct {
+	Accuracy float64        `json:"accuracy"`
+	Positive map[int]uint64 `json:"positive,omitempty"`
+	Negative map[int]uint64 `json:"negative,omitempty"`
+	Zero     uint64         `json:"zero,omitempty"`
+	Count    uint64         `json:"count"`
+	Sum      float64        `json:"sum"`
+	Min      float64        `json:"min"`
+	Max      float64        `json:"max"`
+}
+
+// NewSketch creates empty sketch with given relative accuracy or DefaultSketchAccuracy if accuracy is zero
+func NewSketch(accuracy float64) (Sketch, error) {
+	if accuracy == 0 {
+		accuracy = DefaultSketchAccuracy
+	}
+	if !(accuracy > 0 && accuracy < 1) {
+		return Sketch{}, ErrInvalidAccuracy
+	}
+
+	return Sketch{
+		Accuracy: accuracy,
+		Positive: make(map[int]uint64),
+		Negative: make(map[int]uint64),
+	}, nil
+}
+
+func (s *Sketch) gamma() float64 {
+	return (1 + s.Accuracy) / (1 - s.Accuracy)
+}
+
+func (s *Sketch) index(v float64) int {
+	return int(math.Ceil(math.Log(v) / math.Log(s.gamma())))
+}
+
+// value returns estimate of values in the bucket with the given index
+func (s *Sketch) value(i int) float64 {
+	g := s.gamma()
+	return 2 * math.Pow(g, float64(i)) / (g + 1)
+}
+
+// Validate checks that sketch is consistent
+func (s *Sketch) Validate() error {
+	if !(s.Accuracy > 0 && s.Accuracy < 1) {
+		return ErrInvalidAccuracy
+	}
+
+	total := s.Zero
+	for _, c := range s.Positive {
+		total += c
+	}
+	for _, c := range s.Negative {
+		total += c
+	}
+	if total != s.Count {
+		return ErrInvalidSketch
+	}
+	if !finite(s.Sum) || !finite(s.Min) || !finite(s.Max) {
+		return ErrInvalidValue
+	}
+
+	return nil
+}
+
+// Observe adds a single observation to the sketch, infinity and NaN are rejected as they cannot be indexed,
+// values which would overflow the sum are rejected too
+func (s *Sketch) Observe(v float64) error {
+	if !finite(v) || !finite(s.Sum+v) {
+		return ErrInvalidValue
+	}
+
+	switch {
+	case v > minIndexable:
+		if s.Positive == nil {
+			s.Positive = make(map[int]uint64)
+		}
+		s.Positive[s.index(v)]++
+	case v < -minIndexable:
+		if s.Negative == nil {
+			s.Negative = make(map[int]uint64)
+		}
+		s.Negative[s.index(-v)]++
+	default:
+		s.Zero++
+	}
+
+	if s.Count == 0 || v < s.Min {
+		s.Min = v
+	}
+	if s.Count == 0 || v > s.Max {
+		s.Max = v
+	}
+	s.Count++
+	s.Sum += v
+
+	return nil
+}
+
+// Merge adds observations of other sketch with the same accuracy
+func (s *Sketch) Merge(other Sketch) error {
+	if s.Accuracy != other.Accuracy {
+		return ErrSketchMismatch
+	}
+	if err := other.Validate(); err != nil {
+		return err
+	}
+	if !finite(s.Sum + other.Sum) {
+		return ErrInvalidValue
+	}
+	if other.Count == 0 {
+		return nil
+	}
+
+	if s.Positive == nil {
+		s.Positive = make(map[int]uint64)
+	}
+	for i, c := range other.Positive {
+		s.Positive[i] += c
+	}
+	if s.Negative == nil {
+		s.Negative = make(map[int]uint64)
+	}
+	for i, c := range other.Negative {
+		s.Negative[i] += c
+	}
+	s.Zero += other.Zero
+
+	if s.Count == 0 || other.Min < s.Min {
+		s.Min = other.Min
+	}
+	if s.Count == 0 || other.Max > s.Max {
+		s.Max = other.Max
+	}
+	s.Count += other.Count
+	s.Sum += other.Sum
+
+	return nil
+}
+
+// Quantile estimates q-quantile of observed values
+func (s *Sketch) Quantile(q float64) (float64, error) {
+	if !(q >= 0 && q <= 1) {
+		return 0, ErrInvalidQuantile
+	}
+	if s.Count == 0 {
+		return 0, ErrEmptySketch
+	}
+
+	rank := uint64(q * float64(s.Count-1))
+	var seen uint64
+
+	// negative values in ascending order are kept in descending order of indexes
+	negative := sortedIndexes(s.Negative)
+	for i := len(negative) - 1; i >= 0; i-- {
+		seen += s.Negative[negative[i]]
+		if seen > rank {
+			return s.clamp(-s.value(negative[i])), nil
+		}
+	}
+
+	seen += s.Zero
+	if seen > rank {
+		return s.clamp(0), nil
+	}
+
+	for _, i := range sortedIndexes(s.Positive) {
+		seen += s.Positive[i]
+		if seen > rank {
+			return s.clamp(s.value(i)), nil
+		}
+	}
+
+	return s.Max, nil
+}
+
+func (s *Sketch) clamp(v float64) float64 {
+	return math.Max(s.Min, math.Min(s.Max, v))
+}
+
+// Copy returns a deep copy of the sketch
+func (s *Sketch) Copy() Sketch {
+	c := *s
+	c.Positive = make(map[int]uint64, len(s.Positive))
+	for i, v := range s.Positive {
+		c.Positive[i] = v
+	}
+	c.Negative = make(map[int]uint64, len(s.Negative))
+	for i, v := range s.Negative {
+		c.Negative[i] = v
+	}
+
+	return c
+}
+
+func sortedIndexes(m map[int]uint64) []int {
+	indexes := make([]int, 0, len(m))
+	for i := range m {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+
+	return indexes
+}
+
+// ObserveSummary returns stored sketch with the value observed, new sketch is created if stored is nil
+func ObserveSummary(stored *Sketch, value float64) (Sketch, error) {
+	var s Sketch
+	if stored == nil {
+		s, _ = NewSketch(DefaultSketchAccuracy)
+	} else {
+		s = stored.Copy()
+	}
+	if err := s.Observe(value); err != nil {
+		return Sketch{}, err
+	}
+
+	return s, nil
+}
+
+// MergeSummary returns stored sketch merged with other one, or a copy of other if stored is nil
+func MergeSummary(stored *Sketch, other Sketch) (Sketch, error) {
+	if err := other.Validate(); err != nil {
+		return Sketch{}, err
+	}
+	if stored == nil {
+		return other.Copy(), nil
+	}
+
+	s := stored.Copy()
+	err := s.Merge(other)
+
+	return s, err
+}
+
+// ApplySummary applies summary metric to stored sketch (nil if there is none yet).
+// Metric carries either a single observation in Value or a Summary sketch to merge.
+func (m *Metrics) ApplySummary(stored *Sketch) (Sketch, error) {
+	if m.Value != nil {
+		return ObserveSummary(stored, *m.Value)
+	}
+
+	return MergeSummary(stored, *m.Summary)
+}
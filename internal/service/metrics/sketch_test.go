@@ -0,0 +1,146 @@
+package metrics
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand/v2"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSketch(t *testing.T) {
+	s, err := NewSketch(0)
+	require.NoError(t, err)
+	assert.Equal(t, DefaultSketchAccuracy, s.Accuracy)
+
+	_, err = NewSketch(1)
+	assert.ErrorIs(t, err, ErrInvalidAccuracy)
+}
+
+func TestSketch_Quantile(t *testing.T) {
+	rnd := rand.New(rand.NewPCG(1, 2))
+	values := make([]float64, 10000)
+	s, _ := NewSketch(DefaultSketchAccuracy)
+	for i := range values {
+		values[i] = rnd.ExpFloat64() * 100
+		s.Observe(values[i])
+	}
+	sort.Float64s(values)
+
+	for _, q := range []float64{0, 0.5, 0.9, 0.99, 1} {
+		want := values[int(q*float64(len(values)-1))]
+		got, err := s.Quantile(q)
+		require.NoError(t, err)
+		assert.InEpsilon(t, want, got, DefaultSketchAccuracy*1.01, "quantile %v", q)
+	}
+
+	_, err := s.Quantile(1.5)
+	assert.ErrorIs(t, err, ErrInvalidQuantile)
+}
+
+func TestSketch_ObserveNotFinite(t *testing.T) {
+	s, _ := NewSketch(DefaultSketchAccuracy)
+	for _, v := range []float64{math.Inf(1), math.Inf(-1), math.NaN()} {
+		assert.ErrorIs(t, s.Observe(v), ErrInvalidValue)
+	}
+	assert.Equal(t, uint64(0), s.Count)
+
+	_, err := ObserveSummary(nil, math.Inf(1))
+	assert.ErrorIs(t, err, ErrInvalidValue)
+	inf := math.Inf(1)
+	assert.False(t, (&Metrics{ID: "s", MType: TypeSummary.String(), Value: &inf}).IsWritable())
+}
+
+func TestSketch_SumOverflow(t *testing.T) {
+	s, _ := NewSketch(DefaultSketchAccuracy)
+	require.NoError(t, s.Observe(math.MaxFloat64))
+	assert.ErrorIs(t, s.Observe(math.MaxFloat64), ErrInvalidValue)
+	assert.Equal(t, uint64(1), s.Count, "rejected value is not observed")
+
+	other, _ := NewSketch(DefaultSketchAccuracy)
+	require.NoError(t, other.Observe(math.MaxFloat64))
+	assert.ErrorIs(t, s.Merge(other), ErrInvalidValue)
+	assert.Equal(t, uint64(1), s.Count, "rejected sketch is not merged")
+
+	other.Sum = math.Inf(1)
+	assert.ErrorIs(t, other.Validate(), ErrInvalidValue)
+}
+
+func TestSketch_NegativeAndZero(t *testing.T) {
+	s, _ := NewSketch(DefaultSketchAccuracy)
+	for _, v := range []float64{-10, -1, 0, 1, 10} {
+		s.Observe(v)
+	}
+
+	tests := []struct {
+		q    float64
+		want float64
+	}{
+		{0, -10},
+		{0.25, -1},
+		{0.5, 0},
+		{0.75, 1},
+		{1, 10},
+	}
+	for _, tt := range tests {
+		got, err := s.Quantile(tt.q)
+		require.NoError(t, err)
+		assert.InDelta(t, tt.want, got, math.Abs(tt.want)*DefaultSketchAccuracy, "quantile %v", tt.q)
+	}
+}
+
+func TestSketch_Merge(t *testing.T) {
+	a, _ := NewSketch(DefaultSketchAccuracy)
+	b, _ := NewSketch(DefaultSketchAccuracy)
+	all, _ := NewSketch(DefaultSketchAccuracy)
+	for i := 1; i <= 100; i++ {
+		if i%2 == 0 {
+			a.Observe(float64(i))
+		} else {
+			b.Observe(float64(i))
+		}
+		all.Observe(float64(i))
+	}
+
+	require.NoError(t, a.Merge(b))
+	assert.Equal(t, all, a)
+
+	other, _ := NewSketch(0.05)
+	assert.ErrorIs(t, a.Merge(other), ErrSketchMismatch)
+}
+
+func TestSketch_JSON(t *testing.T) {
+	s, _ := NewSketch(DefaultSketchAccuracy)
+	s.Observe(-3)
+	s.Observe(0)
+	s.Observe(42)
+
+	data, err := json.Marshal(s)
+	require.NoError(t, err)
+
+	var decoded Sketch
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	assert.NoError(t, decoded.Validate())
+	assert.Equal(t, s, decoded)
+}
+
+func TestMetrics_ApplySummary(t *testing.T) {
+	v := 5.0
+	observation := Metrics{ID: "s", MType: TypeSummary.String(), Value: &v}
+
+	s, err := observation.ApplySummary(nil)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), s.Count)
+
+	stored := s
+	s, err = observation.ApplySummary(&stored)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), s.Count)
+	assert.Equal(t, uint64(1), stored.Count)
+
+	invalid := Metrics{ID: "s", MType: TypeSummary.String(), Summary: &Sketch{Accuracy: 0.01, Count: 1}}
+	assert.False(t, invalid.IsWritable())
+}
@@ -0,0 +1,260 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mixailo/go-training-metrics/internal/service/alerting"
+	"github.com/mixailo/go-training-metrics/internal/service/logger"
+	"github.com/mixailo/go-training-metrics/internal/service/metrics"
+)
+
+// Config of notifications delivery
+type Config struct {
+	URLs           []string      // webhooks to deliver notifications to
+	GroupBy        []string      // alert labels to group alerts by, all alerts form a single group if empty
+	RepeatInterval time.Duration // interval to repeat notification about still firing alert
+	MaxAttempts    int           // delivery attempts per webhook
+	Backoff        time.Duration // delay before the second attempt, doubled for every next one
+	Timeout        time.Duration // timeout of a single delivery attempt
+}
+
+// Notification is a body of webhook request
+type Notification struct {
+	Status      string            `json:"status"`
+	GroupKey    string            `json:"groupKey"`
+	GroupLabels map[string]string `json:"groupLabels"`
+	Alerts      []alerting.Alert  `json:"alerts"`
+}
+
+// Notifier delivers firing and resolved alerts to webhooks, delivery to every webhook is tracked separately
+type Notifier struct {
+	cfg    Config
+	client *http.Client
+
+	mu      sync.Mutex
+	targets []*target
+
+	now   func() time.Time
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// target is a webhook with alerts notified to it
+type target struct {
+	url       string
+	notified  map[string]time.Time      // rule name to last firing notification time
+	resolving map[string]alerting.Alert // resolved alerts which were notified as firing, but not as resolved yet
+}
+
+func New(cfg Config) *Notifier {
+	if cfg.MaxAttempts < 1 {
+		cfg.MaxAttempts = 1
+	}
+
+	targets := make([]*target, 0, len(cfg.URLs))
+	for _, url := range cfg.URLs {
+		targets = append(targets, &target{
+			url:       url,
+			notified:  make(map[string]time.Time),
+			resolving: make(map[string]alerting.Alert),
+		})
+	}
+
+	return &Notifier{
+		cfg:     cfg,
+		client:  &http.Client{Timeout: cfg.Timeout},
+		targets: targets,
+		now:     time.Now,
+		sleep:   sleep,
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Notify sends notifications about firing alerts which were not notified within repeat interval
+// and about resolved alerts which were notified as firing. Other alerts are ignored.
+// Resolved alerts which are not delivered are kept and sent again on the next call.
+func (n *Notifier) Notify(ctx context.Context, alerts []alerting.Alert) error {
+	now := n.now()
+
+	// the latest state of an alert wins
+	latest := make(map[string]alerting.Alert)
+	for _, a := range alerts {
+		latest[a.Rule] = a
+	}
+
+	n.mu.Lock()
+	due := make([][]Notification, len(n.targets))
+	for i, t := range n.targets {
+		due[i] = n.group(t.due(latest, now, n.cfg.RepeatInterval))
+	}
+	n.mu.Unlock()
+
+	// lock is not held while webhooks are waited for
+	var errs []error
+	for i, t := range n.targets {
+		for _, notification := range due[i] {
+			err := n.deliver(ctx, t.url, notification)
+			if err != nil {
+				errs = append(errs, err)
+			}
+			n.mu.Lock()
+			t.delivered(notification, err == nil, now)
+			n.mu.Unlock()
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// due returns alerts to be notified to the target, resolved alerts which were notified as firing are kept
+// until they are delivered
+func (t *target) due(latest map[string]alerting.Alert, now time.Time, repeat time.Duration) []alerting.Alert {
+	due := make([]alerting.Alert, 0)
+	for _, rule := range sortedRules(latest) {
+		a := latest[rule]
+		last, notified := t.notified[rule]
+		switch a.State {
+		case alerting.StateFiring:
+			// alert fired again before it was notified as resolved
+			delete(t.resolving, rule)
+			if !notified || now.Sub(last) >= repeat {
+				due = append(due, a)
+			}
+		case alerting.StateResolved:
+			if notified {
+				delete(t.notified, rule)
+				t.resolving[rule] = a
+			}
+		}
+	}
+	for _, rule := range sortedRules(t.resolving) {
+		due = append(due, t.resolving[rule])
+	}
+
+	return due
+}
+
+// delivered records the result of notification delivery, firing alerts which are not delivered are sent again
+// on the next call as they are still firing, resolved ones are kept until they are delivered
+func (t *target) delivered(notification Notification, ok bool, now time.Time) {
+	if !ok {
+		return
+	}
+	for _, a := range notification.Alerts {
+		if a.State == alerting.StateFiring {
+			t.notified[a.Rule] = now
+		} else {
+			delete(t.resolving, a.Rule)
+		}
+	}
+}
+
+// group splits alerts into notifications by status and values of group labels
+func (n *Notifier) group(alerts []alerting.Alert) []Notification {
+	byKey := make(map[string]*Notification)
+	keys := make([]string, 0)
+	for _, a := range alerts {
+		groupLabels := make(map[string]string, len(n.cfg.GroupBy))
+		for _, name := range n.cfg.GroupBy {
+			groupLabels[name] = a.Labels[name]
+		}
+		groupKey := metrics.SeriesKey("", groupLabels)
+		key := string(a.State) + groupKey
+
+		notification, ok := byKey[key]
+		if !ok {
+			notification = &Notification{
+				Status:      string(a.State),
+				GroupKey:    groupKey,
+				GroupLabels: groupLabels,
+			}
+			byKey[key] = notification
+			keys = append(keys, key)
+		}
+		notification.Alerts = append(notification.Alerts, a)
+	}
+
+	result := make([]Notification, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, *byKey[key])
+	}
+
+	return result
+}
+
+// deliver posts notification to webhook, retrying failed attempts with exponential backoff
+func (n *Notifier) deliver(ctx context.Context, url string, notification Notification) error {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+
+	delay := n.cfg.Backoff
+	for attempt := 1; ; attempt++ {
+		var retryable bool
+		retryable, err = n.post(ctx, url, body)
+		if err == nil {
+			return nil
+		}
+		if !retryable || attempt >= n.cfg.MaxAttempts {
+			return fmt.Errorf("webhook %s: %w", url, err)
+		}
+		logger.Log.Info("notification delivery failed, will retry", zap.String("url", url), zap.Duration("delay", delay), zap.Error(err))
+		if err = n.sleep(ctx, delay); err != nil {
+			return fmt.Errorf("webhook %s: %w", url, err)
+		}
+		delay *= 2
+	}
+}
+
+// post sends a single request and tells if failure is worth retrying
+func (n *Notifier) post(ctx context.Context, url string, body []byte) (retryable bool, err error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := n.client.Do(request)
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 300 {
+		retryable = response.StatusCode >= 500 || response.StatusCode == http.StatusTooManyRequests
+		return retryable, fmt.Errorf("unexpected status %s", response.Status)
+	}
+
+	return false, nil
+}
+
+func sortedRules(alerts map[string]alerting.Alert) []string {
+	rules := make([]string, 0, len(alerts))
+	for rule := range alerts {
+		rules = append(rules, rule)
+	}
+	sort.Strings(rules)
+
+	return rules
+}
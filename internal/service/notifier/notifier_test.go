@@ -0,0 +1,234 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mixailo/go-training-metrics/internal/service/alerting"
+)
+
+type webhook struct {
+	mu       sync.Mutex
+	received []Notification
+	statuses []int // responses to return, 200 when exhausted
+}
+
+func (wh *webhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+
+	if len(wh.statuses) > 0 {
+		status := wh.statuses[0]
+		wh.statuses = wh.statuses[1:]
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+	}
+
+	var n Notification
+	if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	wh.received = append(wh.received, n)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (wh *webhook) notifications() []Notification {
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+
+	return append([]Notification(nil), wh.received...)
+}
+
+type fakeClock struct {
+	t      time.Time
+	sleeps []time.Duration
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func (c *fakeClock) sleep(_ context.Context, d time.Duration) error {
+	c.sleeps = append(c.sleeps, d)
+	return nil
+}
+
+func newTestNotifier(cfg Config) (*Notifier, *fakeClock) {
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	n := New(cfg)
+	n.now = clock.now
+	n.sleep = clock.sleep
+
+	return n, clock
+}
+
+func alert(rule string, state alerting.State, labels map[string]string) alerting.Alert {
+	l := map[string]string{"alertname": rule}
+	for k, v := range labels {
+		l[k] = v
+	}
+
+	return alerting.Alert{Rule: rule, State: state, Labels: l}
+}
+
+func TestNotifier_GroupAndDeduplicate(t *testing.T) {
+	wh := &webhook{}
+	server := httptest.NewServer(wh)
+	defer server.Close()
+
+	n, clock := newTestNotifier(Config{
+		URLs:           []string{server.URL},
+		GroupBy:        []string{"severity"},
+		RepeatInterval: time.Hour,
+		MaxAttempts:    1,
+	})
+
+	alerts := []alerting.Alert{
+		alert("A", alerting.StateFiring, map[string]string{"severity": "page"}),
+		alert("B", alerting.StateFiring, map[string]string{"severity": "page"}),
+		alert("C", alerting.StateFiring, map[string]string{"severity": "ticket"}),
+		alert("D", alerting.StatePending, map[string]string{"severity": "page"}),
+	}
+	require.NoError(t, n.Notify(context.Background(), alerts))
+
+	received := wh.notifications()
+	require.Len(t, received, 2)
+	assert.Equal(t, "firing", received[0].Status)
+	assert.Equal(t, map[string]string{"severity": "page"}, received[0].GroupLabels)
+	assert.Len(t, received[0].Alerts, 2)
+	assert.Equal(t, map[string]string{"severity": "ticket"}, received[1].GroupLabels)
+	assert.Len(t, received[1].Alerts, 1)
+
+	// repeated firing alerts are not sent again within repeat interval
+	clock.t = clock.t.Add(time.Minute)
+	require.NoError(t, n.Notify(context.Background(), alerts))
+	assert.Len(t, wh.notifications(), 2)
+
+	// resolved alert is sent once
+	resolved := []alerting.Alert{alert("A", alerting.StateResolved, map[string]string{"severity": "page"})}
+	require.NoError(t, n.Notify(context.Background(), resolved))
+	require.NoError(t, n.Notify(context.Background(), resolved))
+	received = wh.notifications()
+	require.Len(t, received, 3)
+	assert.Equal(t, "resolved", received[2].Status)
+
+	// still firing alerts are repeated after repeat interval
+	clock.t = clock.t.Add(time.Hour)
+	require.NoError(t, n.Notify(context.Background(), alerts[1:]))
+	assert.Len(t, wh.notifications(), 5)
+}
+
+func TestNotifier_ResolvedWithoutFiring(t *testing.T) {
+	wh := &webhook{}
+	server := httptest.NewServer(wh)
+	defer server.Close()
+
+	n, _ := newTestNotifier(Config{URLs: []string{server.URL}, RepeatInterval: time.Hour})
+	require.NoError(t, n.Notify(context.Background(), []alerting.Alert{alert("A", alerting.StateResolved, nil)}))
+	assert.Empty(t, wh.notifications())
+}
+
+func TestNotifier_Retry(t *testing.T) {
+	wh := &webhook{statuses: []int{http.StatusServiceUnavailable, http.StatusInternalServerError}}
+	server := httptest.NewServer(wh)
+	defer server.Close()
+
+	n, clock := newTestNotifier(Config{
+		URLs:           []string{server.URL},
+		RepeatInterval: time.Hour,
+		MaxAttempts:    3,
+		Backoff:        time.Second,
+	})
+
+	require.NoError(t, n.Notify(context.Background(), []alerting.Alert{alert("A", alerting.StateFiring, nil)}))
+	assert.Len(t, wh.notifications(), 1)
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second}, clock.sleeps)
+}
+
+func TestNotifier_FailedDeliveryIsRepeated(t *testing.T) {
+	wh := &webhook{statuses: []int{http.StatusBadRequest}}
+	server := httptest.NewServer(wh)
+	defer server.Close()
+
+	n, clock := newTestNotifier(Config{
+		URLs:           []string{server.URL},
+		RepeatInterval: time.Hour,
+		MaxAttempts:    3,
+		Backoff:        time.Second,
+	})
+
+	alerts := []alerting.Alert{alert("A", alerting.StateFiring, nil)}
+	assert.Error(t, n.Notify(context.Background(), alerts))
+	assert.Empty(t, clock.sleeps, "client errors are not retried")
+	assert.Empty(t, wh.notifications())
+
+	require.NoError(t, n.Notify(context.Background(), alerts))
+	assert.Len(t, wh.notifications(), 1)
+}
+
+func TestNotifier_FailedResolvedIsRepeated(t *testing.T) {
+	wh := &webhook{}
+	server := httptest.NewServer(wh)
+	defer server.Close()
+
+	n, _ := newTestNotifier(Config{URLs: []string{server.URL}, RepeatInterval: time.Hour})
+	require.NoError(t, n.Notify(context.Background(), []alerting.Alert{alert("A", alerting.StateFiring, nil)}))
+
+	// resolved alert is passed only in evaluation where it changed
+	wh.statuses = []int{http.StatusServiceUnavailable}
+	assert.Error(t, n.Notify(context.Background(), []alerting.Alert{alert("A", alerting.StateResolved, nil)}))
+	require.NoError(t, n.Notify(context.Background(), nil))
+
+	received := wh.notifications()
+	require.Len(t, received, 2)
+	assert.Equal(t, "resolved", received[1].Status)
+
+	require.NoError(t, n.Notify(context.Background(), nil))
+	assert.Len(t, wh.notifications(), 2, "delivered resolved alert is not repeated")
+}
+
+func TestNotifier_DeliveryPerWebhook(t *testing.T) {
+	healthy, failing := &webhook{}, &webhook{statuses: []int{http.StatusBadRequest}}
+	healthyServer, failingServer := httptest.NewServer(healthy), httptest.NewServer(failing)
+	defer healthyServer.Close()
+	defer failingServer.Close()
+
+	n, _ := newTestNotifier(Config{URLs: []string{failingServer.URL, healthyServer.URL}, RepeatInterval: time.Hour})
+	alerts := []alerting.Alert{alert("A", alerting.StateFiring, nil)}
+	assert.Error(t, n.Notify(context.Background(), alerts))
+	require.NoError(t, n.Notify(context.Background(), alerts))
+
+	assert.Len(t, healthy.notifications(), 1, "webhook which received notification does not get it again")
+	assert.Len(t, failing.notifications(), 1)
+}
+
+func TestNotifier_UnlockedWhileWaiting(t *testing.T) {
+	wh := &webhook{statuses: []int{http.StatusServiceUnavailable}}
+	server := httptest.NewServer(wh)
+	defer server.Close()
+
+	n, _ := newTestNotifier(Config{URLs: []string{server.URL}, RepeatInterval: time.Hour, MaxAttempts: 2})
+	locked := false
+	n.sleep = func(context.Context, time.Duration) error {
+		if n.mu.TryLock() {
+			n.mu.Unlock()
+		} else {
+			locked = true
+		}
+		return nil
+	}
+
+	require.NoError(t, n.Notify(context.Background(), []alerting.Alert{alert("A", alerting.StateFiring, nil)}))
+	assert.False(t, locked, "notifier is not locked during backoff")
+}
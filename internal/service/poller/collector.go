@@ -0,0 +1,111 @@
+package poller
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/mixailo/go-training-metrics/internal/service/metrics"
+)
+
+var ErrUnknownCollector = errors.New("unknown collector")
+
+// Collector gathers a group of metrics into report
+type Collector interface {
+	// Name identifies collector in configuration
+	Name() string
+	Collect(report *metrics.Report) error
+}
+
+// Registry keeps collectors known to agent, only enabled ones are polled
+type Registry struct {
+	collectors []Collector
+	enabled    map[string]bool
+}
+
+// NewRegistry returns registry of collectors, all of them are enabled
+func NewRegistry(collectors ...Collector) *Registry {
+	r := &Registry{enabled: make(map[string]bool, len(collectors))}
+	for _, c := range collectors {
+		r.Register(c)
+	}
+
+	return r
+}
+
+// DefaultRegistry returns registry of collectors shipped with agent
+func DefaultRegistry() *Registry {
+	return NewRegistry(RuntimeCollector{}, NewHostCollector("/proc"), NewDiskCollector("/proc", nil, nil), NewNetCollector("/proc"))
+}
+
+// Register adds enabled collector, collector with the same name is replaced
+func (r *Registry) Register(c Collector) {
+	for i, registered := range r.collectors {
+		if registered.Name() == c.Name() {
+			r.collectors[i] = c
+			r.enabled[c.Name()] = true
+			return
+		}
+	}
+
+	r.collectors = append(r.collectors, c)
+	r.enabled[c.Name()] = true
+}
+
+// Enable leaves enabled only collectors with given names
+func (r *Registry) Enable(names ...string) error {
+	enabled := make(map[string]bool, len(names))
+	for _, name := range names {
+		if !r.has(name) {
+			return fmt.Errorf("%w %q", ErrUnknownCollector, name)
+		}
+		enabled[name] = true
+	}
+	r.enabled = enabled
+
+	return nil
+}
+
+// Names returns names of registered collectors in order of registration
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.collectors))
+	for _, c := range r.collectors {
+		names = append(names, c.Name())
+	}
+
+	return names
+}
+
+// Enabled returns enabled collectors in order of registration
+func (r *Registry) Enabled() []Collector {
+	enabled := make([]Collector, 0, len(r.enabled))
+	for _, c := range r.collectors {
+		if r.enabled[c.Name()] {
+			enabled = append(enabled, c)
+		}
+	}
+
+	return enabled
+}
+
+// Collect polls enabled collectors, metrics of the succeeded ones are reported even if others fail
+func (r *Registry) Collect() (metrics.Report, error) {
+	report := metrics.NewReport()
+	var errs []error
+	for _, c := range r.Enabled() {
+		if err := c.Collect(&report); err != nil {
+			errs = append(errs, fmt.Errorf("collector %s: %w", c.Name(), err))
+		}
+	}
+
+	return report, errors.Join(errs...)
+}
+
+func (r *Registry) has(name string) bool {
+	for _, c := range r.collectors {
+		if c.Name() == name {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,83 @@
+package poller
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mixailo/go-training-metrics/internal/service/metrics"
+)
+
+type stubCollector struct {
+	name   string
+	metric string
+	err    error
+}
+
+func (c stubCollector) Name() string {
+	return c.name
+}
+
+func (c stubCollector) Collect(report *metrics.Report) error {
+	report.AddUnConverted(metrics.TypeGauge, c.metric, "1")
+	return c.err
+}
+
+func TestRegistry_Collect(t *testing.T) {
+	failure := errors.New("failure")
+	tests := []struct {
+		name    string
+		enable  []string
+		want    []string
+		wantErr bool
+	}{
+		{name: "all are enabled by default", want: []string{"A", "B", "C"}, wantErr: true},
+		{name: "enabled only", enable: []string{"a", "c"}, want: []string{"A", "C"}, wantErr: true},
+		{name: "succeeded only", enable: []string{"a", "b"}, want: []string{"A", "B"}},
+		{name: "none", enable: []string{}, want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRegistry(
+				stubCollector{name: "a", metric: "A"},
+				stubCollector{name: "b", metric: "B"},
+				stubCollector{name: "c", metric: "C", err: failure},
+			)
+			if tt.enable != nil {
+				require.NoError(t, r.Enable(tt.enable...))
+			}
+
+			enabled := make([]string, 0)
+			for _, c := range r.Enabled() {
+				enabled = append(enabled, c.Name())
+			}
+			assert.Len(t, enabled, len(tt.want))
+
+			report, err := r.Collect()
+			if tt.wantErr {
+				assert.ErrorIs(t, err, failure)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, len(tt.want), report.Length())
+			for _, name := range tt.want {
+				assert.True(t, report.Has(name), name)
+			}
+		})
+	}
+}
+
+func TestRegistry_Enable(t *testing.T) {
+	r := DefaultRegistry()
+	assert.Equal(t, []string{"runtime", "host", "disk", "net"}, r.Names())
+	assert.ErrorIs(t, r.Enable("runtime", "unknown"), ErrUnknownCollector)
+
+	require.NoError(t, r.Enable("runtime"))
+	r.Register(stubCollector{name: "runtime", metric: "Replaced"})
+	assert.Equal(t, []string{"runtime", "host", "disk", "net"}, r.Names())
+	report, err := r.Collect()
+	require.NoError(t, err)
+	assert.True(t, report.Has("Replaced"))
+}
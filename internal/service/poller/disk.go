@@ -0,0 +1,246 @@
+package poller
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mixailo/go-training-metrics/internal/service/metrics"
+)
+
+// DefaultFSTypesExclude are pseudo filesystems skipped unless other types to exclude are configured
+var DefaultFSTypesExclude = []string{
+	"autofs", "binfmt_misc", "bpf", "cgroup", "cgroup2", "configfs", "debugfs", "devpts", "devtmpfs",
+	"fusectl", "hugetlbfs", "mqueue", "nsfs", "overlay", "proc", "pstore", "rpc_pipefs", "securityfs",
+	"selinuxfs", "squashfs", "sysfs", "tracefs",
+}
+
+// sectorSize is the unit of sectors in /proc/diskstats regardless of device sector size
+const sectorSize = 512
+
+type mount struct {
+	device, path, fsType string
+}
+
+// fsStats holds filesystem capacity in bytes and inodes
+type fsStats struct {
+	size, free, avail uint64
+	files, filesFree  uint64
+}
+
+// diskStats holds IO counters of a block device since boot
+type diskStats struct {
+	reads, readSectors, writes, writtenSectors, ioTimeMs uint64
+}
+
+// DiskCollector reports usage of mounted filesystems labelled by mountpoint, fstype and device,
+// and IO of block devices labelled by device. IO counters are reported as deltas since the previous poll
+// along with per second rates.
+type DiskCollector struct {
+	procDir string
+	include map[string]bool
+	exclude map[string]bool
+	statfs  func(path string) (fsStats, error)
+	now     func() time.Time
+
+	mu       sync.Mutex
+	prev     map[string]diskStats
+	prevTime time.Time
+}
+
+// NewDiskCollector returns collector reading procfs mounted at procDir, usually /proc.
+// Filesystems of types in include are reported only, if it is not empty, types in exclude are skipped.
+// DefaultFSTypesExclude is used when exclude is nil.
+func NewDiskCollector(procDir string, include, exclude []string) *DiskCollector {
+	if exclude == nil {
+		exclude = DefaultFSTypesExclude
+	}
+
+	return &DiskCollector{
+		procDir: procDir,
+		include: stringSet(include),
+		exclude: stringSet(exclude),
+		statfs:  statFS,
+		now:     time.Now,
+	}
+}
+
+func (c *DiskCollector) Name() string {
+	return "disk"
+}
+
+func (c *DiskCollector) Collect(report *metrics.Report) error {
+	mounts, err := readProcFile(filepath.Join(c.procDir, "self", "mounts"), parseMounts)
+	if err != nil {
+		return err
+	}
+	for _, m := range mounts {
+		if !c.reported(m.fsType) {
+			continue
+		}
+		stats, err := c.statfs(m.path)
+		if err != nil {
+			// mounts may be inaccessible to agent or gone since they were listed
+			continue
+		}
+		labels := map[string]string{"mountpoint": m.path, "fstype": m.fsType, "device": m.device}
+		addGauge(report, "FilesystemSize", labels, float64(stats.size))
+		addGauge(report, "FilesystemFree", labels, float64(stats.free))
+		addGauge(report, "FilesystemAvail", labels, float64(stats.avail))
+		addGauge(report, "FilesystemFiles", labels, float64(stats.files))
+		addGauge(report, "FilesystemFilesFree", labels, float64(stats.filesFree))
+	}
+
+	disks, err := readProcFile(filepath.Join(c.procDir, "diskstats"), parseDiskStats)
+	if err != nil {
+		return err
+	}
+	now := c.now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elapsed := now.Sub(c.prevTime).Seconds()
+	for device, cur := range disks {
+		prev, ok := c.prev[device]
+		// deltas are unknown on the first poll and when counters are reset
+		if !ok || elapsed <= 0 || cur.reads < prev.reads || cur.readSectors < prev.readSectors ||
+			cur.writes < prev.writes || cur.writtenSectors < prev.writtenSectors || cur.ioTimeMs < prev.ioTimeMs {
+			continue
+		}
+		labels := map[string]string{"device": device}
+		reads := cur.reads - prev.reads
+		readBytes := (cur.readSectors - prev.readSectors) * sectorSize
+		writes := cur.writes - prev.writes
+		writtenBytes := (cur.writtenSectors - prev.writtenSectors) * sectorSize
+
+		addCounter(report, "DiskReads", labels, reads)
+		addCounter(report, "DiskReadBytes", labels, readBytes)
+		addCounter(report, "DiskWrites", labels, writes)
+		addCounter(report, "DiskWrittenBytes", labels, writtenBytes)
+		addCounter(report, "DiskIOTimeMs", labels, cur.ioTimeMs-prev.ioTimeMs)
+		addGauge(report, "DiskReadsPerSecond", labels, float64(reads)/elapsed)
+		addGauge(report, "DiskReadBytesPerSecond", labels, float64(readBytes)/elapsed)
+		addGauge(report, "DiskWritesPerSecond", labels, float64(writes)/elapsed)
+		addGauge(report, "DiskWrittenBytesPerSecond", labels, float64(writtenBytes)/elapsed)
+	}
+	c.prev = disks
+	c.prevTime = now
+
+	return nil
+}
+
+func (c *DiskCollector) reported(fsType string) bool {
+	if len(c.include) > 0 && !c.include[fsType] {
+		return false
+	}
+
+	return !c.exclude[fsType]
+}
+
+func stringSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[v] = true
+	}
+
+	return set
+}
+
+func addGauge(report *metrics.Report, id string, labels map[string]string, value float64) {
+	report.Add(metrics.Metrics{ID: id, MType: metrics.TypeGauge.String(), Value: &value, Labels: labels})
+}
+
+func addCounter(report *metrics.Report, id string, labels map[string]string, delta uint64) {
+	d := int64(delta)
+	report.Add(metrics.Metrics{ID: id, MType: metrics.TypeCounter.String(), Delta: &d, Labels: labels})
+}
+
+// parseMounts reads mounts in fstab format of /proc/self/mounts
+func parseMounts(r io.Reader) ([]mount, error) {
+	var mounts []mount
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("too few fields of mount %s", fields[0])
+		}
+		mounts = append(mounts, mount{
+			device: unescapeMountField(fields[0]),
+			path:   unescapeMountField(fields[1]),
+			fsType: fields[2],
+		})
+	}
+
+	return mounts, scanner.Err()
+}
+
+// unescapeMountField decodes octal escapes of space, tab, newline and backslash
+func unescapeMountField(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				sb.WriteByte(byte(v))
+				i += 3
+				continue
+			}
+		}
+		sb.WriteByte(s[i])
+	}
+
+	return sb.String()
+}
+
+// parseDiskStats reads IO counters of every device of /proc/diskstats, loop and ram devices are skipped
+func parseDiskStats(r io.Reader) (map[string]diskStats, error) {
+	disks := make(map[string]diskStats)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 14 {
+			return nil, fmt.Errorf("too few fields of diskstats line %q", scanner.Text())
+		}
+		device := fields[2]
+		if strings.HasPrefix(device, "loop") || strings.HasPrefix(device, "ram") {
+			continue
+		}
+
+		// reads completed, sectors read, writes completed, sectors written and time spent doing IO
+		var values [5]uint64
+		for i, field := range []int{3, 5, 7, 9, 12} {
+			v, err := strconv.ParseUint(fields[field], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid counter of %s: %w", device, err)
+			}
+			values[i] = v
+		}
+		disks[device] = diskStats{
+			reads:          values[0],
+			readSectors:    values[1],
+			writes:         values[2],
+			writtenSectors: values[3],
+			ioTimeMs:       values[4],
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return disks, nil
+}
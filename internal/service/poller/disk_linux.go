@@ -0,0 +1,21 @@
+//go:build linux
+
+package poller
+
+import "syscall"
+
+func statFS(path string) (fsStats, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return fsStats{}, err
+	}
+	bsize := uint64(st.Bsize)
+
+	return fsStats{
+		size:      st.Blocks * bsize,
+		free:      st.Bfree * bsize,
+		avail:     st.Bavail * bsize,
+		files:     st.Files,
+		filesFree: st.Ffree,
+	}, nil
+}
@@ -0,0 +1,157 @@
+package poller
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mixailo/go-training-metrics/internal/service/metrics"
+)
+
+func TestParseMounts(t *testing.T) {
+	f, err := os.Open("testdata/disk/first/self/mounts")
+	require.NoError(t, err)
+	defer f.Close()
+
+	mounts, err := parseMounts(f)
+	require.NoError(t, err)
+	require.Len(t, mounts, 5)
+	assert.Equal(t, mount{device: "/dev/sda1", path: "/", fsType: "ext4"}, mounts[2])
+	assert.Equal(t, mount{device: "/dev/sdb1", path: "/mnt/backup disk", fsType: "xfs"}, mounts[4])
+
+	_, err = parseMounts(strings.NewReader("/dev/sda1 /\n"))
+	assert.Error(t, err)
+}
+
+func TestUnescapeMountField(t *testing.T) {
+	tests := map[string]string{
+		`/mnt/plain`:         "/mnt/plain",
+		`/mnt/a\040b`:        "/mnt/a b",
+		`/mnt/tab\011end`:    "/mnt/tab\tend",
+		`/mnt/back\134slash`: `/mnt/back\slash`,
+		`/mnt/trailing\04`:   `/mnt/trailing\04`,
+		`/mnt/invalid\0x9`:   `/mnt/invalid\0x9`,
+	}
+	for escaped, want := range tests {
+		assert.Equal(t, want, unescapeMountField(escaped), escaped)
+	}
+}
+
+func TestParseDiskStats(t *testing.T) {
+	f, err := os.Open("testdata/disk/first/diskstats")
+	require.NoError(t, err)
+	defer f.Close()
+
+	disks, err := parseDiskStats(f)
+	require.NoError(t, err)
+	assert.Len(t, disks, 3, "loop devices are skipped")
+	assert.Equal(t, diskStats{reads: 1000, readSectors: 80000, writes: 2000, writtenSectors: 160000, ioTimeMs: 2800}, disks["sda"])
+	assert.Equal(t, diskStats{reads: 5000, readSectors: 400000, writes: 6000, writtenSectors: 800000, ioTimeMs: 4000}, disks["nvme0n1"])
+
+	_, err = parseDiskStats(strings.NewReader("8 0 sda 1 2 3\n"))
+	assert.Error(t, err)
+	_, err = parseDiskStats(strings.NewReader("8 0 sda x 0 0 0 0 0 0 0 0 0 0\n"))
+	assert.Error(t, err)
+}
+
+func TestDiskCollector_Filesystems(t *testing.T) {
+	dir := t.TempDir()
+	procDir := filepath.Join(dir, "proc")
+	require.NoError(t, os.MkdirAll(filepath.Join(procDir, "self"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(procDir, "diskstats"), nil, 0644))
+	mounts := "proc /proc proc rw 0 0\n" +
+		"/dev/test " + dir + " ext4 rw 0 0\n" +
+		"/dev/gone " + filepath.Join(dir, "missing") + " ext4 rw 0 0\n" +
+		"tmpfs " + dir + " tmpfs rw 0 0\n"
+	require.NoError(t, os.WriteFile(filepath.Join(procDir, "self", "mounts"), []byte(mounts), 0644))
+
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		want    []string
+	}{
+		{name: "pseudo filesystems are excluded by default", want: []string{"ext4", "tmpfs"}},
+		{name: "exclude", exclude: []string{"ext4"}, want: []string{"proc", "tmpfs"}},
+		{name: "include", include: []string{"tmpfs"}, exclude: []string{}, want: []string{"tmpfs"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewDiskCollector(procDir, tt.include, tt.exclude)
+			report := metrics.NewReport()
+			require.NoError(t, c.Collect(&report))
+
+			var fsTypes []string
+			for _, m := range report.All() {
+				if m.ID == "FilesystemSize" {
+					fsTypes = append(fsTypes, m.Labels["fstype"])
+				}
+			}
+			assert.ElementsMatch(t, tt.want, fsTypes)
+		})
+	}
+
+	c := NewDiskCollector(procDir, nil, nil)
+	report := metrics.NewReport()
+	require.NoError(t, c.Collect(&report))
+	labels := map[string]string{"mountpoint": dir, "fstype": "ext4", "device": "/dev/test"}
+	size, ok := report.Get(metrics.SeriesKey("FilesystemSize", labels))
+	require.True(t, ok)
+	avail, ok := report.Get(metrics.SeriesKey("FilesystemAvail", labels))
+	require.True(t, ok)
+	assert.Positive(t, *size.Value)
+	assert.LessOrEqual(t, *avail.Value, *size.Value)
+	assert.False(t, report.Has(metrics.SeriesKey("FilesystemSize", map[string]string{"mountpoint": filepath.Join(dir, "missing"), "fstype": "ext4", "device": "/dev/gone"})))
+}
+
+func TestDiskCollector_IO(t *testing.T) {
+	start := time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC)
+	c := NewDiskCollector("testdata/disk/first", nil, nil)
+	c.statfs = func(string) (fsStats, error) {
+		return fsStats{size: 100}, nil
+	}
+	c.now = func() time.Time {
+		return start
+	}
+
+	report := metrics.NewReport()
+	require.NoError(t, c.Collect(&report))
+	assert.False(t, report.Has(metrics.SeriesKey("DiskReads", map[string]string{"device": "sda"})), "deltas are unknown on the first poll")
+
+	c.procDir = "testdata/disk/second"
+	c.now = func() time.Time {
+		return start.Add(10 * time.Second)
+	}
+	report = metrics.NewReport()
+	require.NoError(t, c.Collect(&report))
+
+	sda := map[string]string{"device": "sda"}
+	for id, want := range map[string]int64{
+		"DiskReads":        100,
+		"DiskReadBytes":    20480 * sectorSize,
+		"DiskWrites":       50,
+		"DiskWrittenBytes": 4096 * sectorSize,
+		"DiskIOTimeMs":     100,
+	} {
+		m, ok := report.Get(metrics.SeriesKey(id, sda))
+		require.True(t, ok, id)
+		assert.Equal(t, metrics.TypeCounter.String(), m.MType)
+		assert.Equal(t, want, *m.Delta, id)
+	}
+	for id, want := range map[string]float64{
+		"DiskReadsPerSecond":        10,
+		"DiskReadBytesPerSecond":    2048 * sectorSize,
+		"DiskWritesPerSecond":       5,
+		"DiskWrittenBytesPerSecond": 409.6 * sectorSize,
+	} {
+		m, ok := report.Get(metrics.SeriesKey(id, sda))
+		require.True(t, ok, id)
+		assert.InDelta(t, want, *m.Value, 1e-9, id)
+	}
+	assert.False(t, report.Has(metrics.SeriesKey("DiskReads", map[string]string{"device": "nvme0n1"})), "reset counters are skipped")
+}
@@ -0,0 +1,197 @@
+package poller
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mixailo/go-training-metrics/internal/service/metrics"
+)
+
+// cpuTimes holds time a cpu spent in every state since boot, in USER_HZ
+type cpuTimes struct {
+	user, nice, system, idle, iowait, irq, softirq, steal uint64
+}
+
+func (t cpuTimes) total() uint64 {
+	// guest time is already included in user and nice
+	return t.user + t.nice + t.system + t.idle + t.iowait + t.irq + t.softirq + t.steal
+}
+
+func (t cpuTimes) idleTotal() uint64 {
+	return t.idle + t.iowait
+}
+
+// utilization returns percentage of time cpu was busy since prev
+func (t cpuTimes) utilization(prev cpuTimes) float64 {
+	total := t.total() - prev.total()
+	if t.total() < prev.total() || total == 0 {
+		return 0
+	}
+	idle := t.idleTotal() - prev.idleTotal()
+	if t.idleTotal() < prev.idleTotal() || idle > total {
+		return 0
+	}
+
+	return 100 * float64(total-idle) / float64(total)
+}
+
+// memInfo holds memory statistics in bytes
+type memInfo struct {
+	total, free uint64
+}
+
+// HostCollector reports host memory as TotalMemory and FreeMemory and utilization of every cpu
+// since the previous poll as CPUutilization1..N
+type HostCollector struct {
+	procDir string
+
+	mu   sync.Mutex
+	prev []cpuTimes
+}
+
+// NewHostCollector returns collector reading procfs mounted at procDir, usually /proc
+func NewHostCollector(procDir string) *HostCollector {
+	return &HostCollector{procDir: procDir}
+}
+
+func (c *HostCollector) Name() string {
+	return "host"
+}
+
+func (c *HostCollector) Collect(report *metrics.Report) error {
+	mem, err := readProcFile(filepath.Join(c.procDir, "meminfo"), parseMemInfo)
+	if err != nil {
+		return err
+	}
+	report.AddUnConverted(metrics.TypeGauge, "TotalMemory", strconv.FormatUint(mem.total, 10))
+	report.AddUnConverted(metrics.TypeGauge, "FreeMemory", strconv.FormatUint(mem.free, 10))
+
+	cpus, err := readProcFile(filepath.Join(c.procDir, "stat"), parseCPUTimes)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// utilization is unknown until the second poll, or when cpus are added or removed
+	if len(c.prev) == len(cpus) {
+		for i, t := range cpus {
+			report.AddUnConverted(metrics.TypeGauge, "CPUutilization"+strconv.Itoa(i+1), strconv.FormatFloat(t.utilization(c.prev[i]), 'f', -1, 64))
+		}
+	}
+	c.prev = cpus
+
+	return nil
+}
+
+func readProcFile[T any](path string, parse func(r io.Reader) (T, error)) (T, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	defer f.Close()
+
+	result, err := parse(f)
+	if err != nil {
+		return result, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return result, nil
+}
+
+// parseMemInfo reads MemTotal and MemFree of /proc/meminfo
+func parseMemInfo(r io.Reader) (memInfo, error) {
+	var (
+		mem               memInfo
+		hasTotal, hasFree bool
+		scanner           = bufio.NewScanner(r)
+	)
+	for scanner.Scan() {
+		name, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok || (name != "MemTotal" && name != "MemFree") {
+			continue
+		}
+
+		fields := strings.Fields(value)
+		if len(fields) == 0 {
+			return mem, fmt.Errorf("no value of %s", name)
+		}
+		v, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			return mem, fmt.Errorf("invalid value of %s: %w", name, err)
+		}
+		if len(fields) > 1 && fields[1] == "kB" {
+			v *= 1024
+		}
+
+		if name == "MemTotal" {
+			mem.total, hasTotal = v, true
+		} else {
+			mem.free, hasFree = v, true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return mem, err
+	}
+	if !hasTotal || !hasFree {
+		return mem, errors.New("no MemTotal or MemFree")
+	}
+
+	return mem, nil
+}
+
+// parseCPUTimes reads lines of every cpu of /proc/stat, the aggregated cpu line is skipped
+func parseCPUTimes(r io.Reader) ([]cpuTimes, error) {
+	var cpus []cpuTimes
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024) // intr line is long on hosts with many interrupts
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || !strings.HasPrefix(fields[0], "cpu") || fields[0] == "cpu" {
+			continue
+		}
+		// steal time, the last one, is missing on kernels before 2.6.11
+		if len(fields) < 8 {
+			return nil, fmt.Errorf("too few fields of %s", fields[0])
+		}
+
+		values := make([]uint64, 8)
+		for i := range values {
+			if i+1 >= len(fields) {
+				break
+			}
+			v, err := strconv.ParseUint(fields[i+1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value of %s: %w", fields[0], err)
+			}
+			values[i] = v
+		}
+		cpus = append(cpus, cpuTimes{
+			user:    values[0],
+			nice:    values[1],
+			system:  values[2],
+			idle:    values[3],
+			iowait:  values[4],
+			irq:     values[5],
+			softirq: values[6],
+			steal:   values[7],
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(cpus) == 0 {
+		return nil, errors.New("no cpu lines")
+	}
+
+	return cpus, nil
+}
@@ -0,0 +1,88 @@
+package poller
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mixailo/go-training-metrics/internal/service/metrics"
+)
+
+func TestParseMemInfo(t *testing.T) {
+	f, err := os.Open("testdata/host/first/meminfo")
+	require.NoError(t, err)
+	defer f.Close()
+
+	mem, err := parseMemInfo(f)
+	require.NoError(t, err)
+	assert.Equal(t, memInfo{total: 6158152 * 1024, free: 3409752 * 1024}, mem)
+
+	_, err = parseMemInfo(strings.NewReader("MemTotal: 100 kB\n"))
+	assert.Error(t, err)
+	_, err = parseMemInfo(strings.NewReader("MemTotal: x kB\nMemFree: 1 kB\n"))
+	assert.Error(t, err)
+}
+
+func TestParseCPUTimes(t *testing.T) {
+	f, err := os.Open("testdata/host/first/stat")
+	require.NoError(t, err)
+	defer f.Close()
+
+	cpus, err := parseCPUTimes(f)
+	require.NoError(t, err)
+	require.Len(t, cpus, 2)
+	assert.Equal(t, cpuTimes{user: 1000, nice: 5, system: 300, idle: 8500, iowait: 150, softirq: 45}, cpus[0])
+
+	_, err = parseCPUTimes(strings.NewReader("intr 1 2 3\n"))
+	assert.Error(t, err)
+	_, err = parseCPUTimes(strings.NewReader("cpu0 1 2 3\n"))
+	assert.Error(t, err)
+}
+
+func TestCPUTimes_Utilization(t *testing.T) {
+	tests := []struct {
+		name string
+		prev cpuTimes
+		cur  cpuTimes
+		want float64
+	}{
+		{name: "busy", prev: cpuTimes{user: 100, idle: 100}, cur: cpuTimes{user: 175, idle: 125}, want: 75},
+		{name: "iowait is idle", prev: cpuTimes{}, cur: cpuTimes{system: 10, idle: 20, iowait: 10}, want: 25},
+		{name: "no time passed", prev: cpuTimes{user: 100}, cur: cpuTimes{user: 100}, want: 0},
+		{name: "counters reset", prev: cpuTimes{user: 100}, cur: cpuTimes{user: 10}, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.InDelta(t, tt.want, tt.cur.utilization(tt.prev), 1e-9)
+		})
+	}
+}
+
+func TestHostCollector_Collect(t *testing.T) {
+	c := NewHostCollector("testdata/host/first")
+
+	report := metrics.NewReport()
+	require.NoError(t, c.Collect(&report))
+	total, ok := report.Get("TotalMemory")
+	require.True(t, ok)
+	assert.Equal(t, float64(6158152*1024), *total.Value)
+	assert.True(t, report.Has("FreeMemory"))
+	assert.False(t, report.Has("CPUutilization1"), "utilization is unknown on the first poll")
+
+	c.procDir = "testdata/host/second"
+	report = metrics.NewReport()
+	require.NoError(t, c.Collect(&report))
+	for name, want := range map[string]float64{"CPUutilization1": 400.0 / 700 * 100, "CPUutilization2": 20} {
+		m, ok := report.Get(name)
+		require.True(t, ok, name)
+		assert.InDelta(t, want, *m.Value, 1e-9, name)
+	}
+	assert.False(t, report.Has("CPUutilization3"))
+
+	c.procDir = "testdata/missing"
+	report = metrics.NewReport()
+	assert.Error(t, c.Collect(&report))
+}
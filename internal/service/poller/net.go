@@ -0,0 +1,204 @@
+package poller
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mixailo/go-training-metrics/internal/service/metrics"
+)
+
+// tcpStates are names of connection states of /proc/net/tcp by their codes, see include/net/tcp_states.h
+var tcpStates = map[uint64]string{
+	0x01: "ESTABLISHED",
+	0x02: "SYN_SENT",
+	0x03: "SYN_RECV",
+	0x04: "FIN_WAIT1",
+	0x05: "FIN_WAIT2",
+	0x06: "TIME_WAIT",
+	0x07: "CLOSE",
+	0x08: "CLOSE_WAIT",
+	0x09: "LAST_ACK",
+	0x0A: "LISTEN",
+	0x0B: "CLOSING",
+	0x0C: "NEW_SYN_RECV",
+}
+
+// interfaceStats holds traffic counters of a network interface since it is up
+type interfaceStats struct {
+	rxBytes, rxPackets, rxErrors, rxDropped uint64
+	txBytes, txPackets, txErrors, txDropped uint64
+}
+
+// counters returns metric ids of interface counters along with their values
+func (s interfaceStats) counters() map[string]uint64 {
+	return map[string]uint64{
+		"NetReceivedBytes":      s.rxBytes,
+		"NetReceivedPackets":    s.rxPackets,
+		"NetReceiveErrors":      s.rxErrors,
+		"NetReceiveDrops":       s.rxDropped,
+		"NetTransmittedBytes":   s.txBytes,
+		"NetTransmittedPackets": s.txPackets,
+		"NetTransmitErrors":     s.txErrors,
+		"NetTransmitDrops":      s.txDropped,
+	}
+}
+
+// reset reports whether any counter is less than its previous value
+func (s interfaceStats) reset(prev interfaceStats) bool {
+	prevCounters := prev.counters()
+	for id, v := range s.counters() {
+		if v < prevCounters[id] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NetCollector reports traffic of network interfaces labelled by interface as deltas since the previous poll,
+// and number of IPv4 and IPv6 TCP connections labelled by state
+type NetCollector struct {
+	procDir string
+
+	mu   sync.Mutex
+	prev map[string]interfaceStats
+}
+
+// NewNetCollector returns collector reading procfs mounted at procDir, usually /proc
+func NewNetCollector(procDir string) *NetCollector {
+	return &NetCollector{procDir: procDir}
+}
+
+func (c *NetCollector) Name() string {
+	return "net"
+}
+
+func (c *NetCollector) Collect(report *metrics.Report) error {
+	interfaces, err := readProcFile(filepath.Join(c.procDir, "net", "dev"), parseNetDev)
+	if err != nil {
+		return err
+	}
+	c.collectInterfaces(report, interfaces)
+
+	connections := make(map[string]int, len(tcpStates))
+	for _, state := range tcpStates {
+		connections[state] = 0
+	}
+	for _, name := range []string{"tcp", "tcp6"} {
+		counts, err := readProcFile(filepath.Join(c.procDir, "net", name), parseTCPStates)
+		if errors.Is(err, os.ErrNotExist) {
+			// there is no tcp6 when IPv6 is disabled
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for state, n := range counts {
+			connections[state] += n
+		}
+	}
+	for state, n := range connections {
+		addGauge(report, "TCPConnections", map[string]string{"state": state}, float64(n))
+	}
+
+	return nil
+}
+
+func (c *NetCollector) collectInterfaces(report *metrics.Report, interfaces map[string]interfaceStats) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for name, cur := range interfaces {
+		prev, ok := c.prev[name]
+		// deltas are unknown on the first poll and when counters are reset by recreated interface
+		if !ok || cur.reset(prev) {
+			continue
+		}
+		labels := map[string]string{"interface": name}
+		prevCounters := prev.counters()
+		for id, v := range cur.counters() {
+			addCounter(report, id, labels, v-prevCounters[id])
+		}
+	}
+	c.prev = interfaces
+}
+
+// parseNetDev reads counters of every interface of /proc/net/dev
+func parseNetDev(r io.Reader) (map[string]interfaceStats, error) {
+	interfaces := make(map[string]interfaceStats)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		// the first two lines are headers, which have no colon
+		name, values, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		name = strings.TrimSpace(name)
+
+		fields := strings.Fields(values)
+		if len(fields) < 16 {
+			return nil, fmt.Errorf("too few counters of interface %s", name)
+		}
+		var counters [16]uint64
+		for i := range counters {
+			v, err := strconv.ParseUint(fields[i], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid counter of interface %s: %w", name, err)
+			}
+			counters[i] = v
+		}
+		interfaces[name] = interfaceStats{
+			rxBytes:   counters[0],
+			rxPackets: counters[1],
+			rxErrors:  counters[2],
+			rxDropped: counters[3],
+			txBytes:   counters[8],
+			txPackets: counters[9],
+			txErrors:  counters[10],
+			txDropped: counters[11],
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return interfaces, nil
+}
+
+// parseTCPStates counts connections of /proc/net/tcp or /proc/net/tcp6 by state
+func parseTCPStates(r io.Reader) (map[string]int, error) {
+	counts := make(map[string]int)
+	scanner := bufio.NewScanner(r)
+	for header := true; scanner.Scan(); header = false {
+		if header {
+			continue
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 4 {
+			return nil, fmt.Errorf("too few fields of connection %s", fields[0])
+		}
+		code, err := strconv.ParseUint(fields[3], 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid state of connection %s: %w", fields[0], err)
+		}
+		// states added by newer kernels are skipped
+		if state, ok := tcpStates[code]; ok {
+			counts[state]++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
@@ -0,0 +1,125 @@
+package poller
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mixailo/go-training-metrics/internal/service/metrics"
+)
+
+func TestParseNetDev(t *testing.T) {
+	f, err := os.Open("testdata/net/first/net/dev")
+	require.NoError(t, err)
+	defer f.Close()
+
+	interfaces, err := parseNetDev(f)
+	require.NoError(t, err)
+	require.Len(t, interfaces, 2)
+	assert.Equal(t, interfaceStats{
+		rxBytes:   1048576,
+		rxPackets: 2000,
+		rxErrors:  1,
+		rxDropped: 2,
+		txBytes:   524288,
+		txPackets: 1500,
+		txDropped: 3,
+	}, interfaces["eth0"])
+
+	_, err = parseNetDev(strings.NewReader("eth0: 1 2 3\n"))
+	assert.Error(t, err)
+	_, err = parseNetDev(strings.NewReader("eth0: 1 2 3 4 5 6 7 8 9 10 11 12 13 14 15 x\n"))
+	assert.Error(t, err)
+}
+
+func TestParseTCPStates(t *testing.T) {
+	tests := []struct {
+		path string
+		want map[string]int
+	}{
+		{path: "testdata/net/first/net/tcp", want: map[string]int{"LISTEN": 1, "ESTABLISHED": 2, "TIME_WAIT": 1}},
+		{path: "testdata/net/first/net/tcp6", want: map[string]int{"LISTEN": 1, "CLOSE_WAIT": 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			f, err := os.Open(tt.path)
+			require.NoError(t, err)
+			defer f.Close()
+
+			counts, err := parseTCPStates(f)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, counts)
+		})
+	}
+
+	header := "  sl  local_address rem_address   st\n"
+	_, err := parseTCPStates(strings.NewReader(header + "   0: 00000000:1F90 00000000:0000 ZZ\n"))
+	assert.Error(t, err)
+	counts, err := parseTCPStates(strings.NewReader(header + "   0: 00000000:1F90 00000000:0000 7F\n"))
+	require.NoError(t, err)
+	assert.Empty(t, counts, "unknown states are skipped")
+}
+
+func TestNetCollector_Collect(t *testing.T) {
+	c := NewNetCollector("testdata/net/first")
+
+	report := metrics.NewReport()
+	require.NoError(t, c.Collect(&report))
+	assert.False(t, report.Has(metrics.SeriesKey("NetReceivedBytes", map[string]string{"interface": "eth0"})), "deltas are unknown on the first poll")
+	for state, want := range map[string]float64{"LISTEN": 2, "ESTABLISHED": 2, "TIME_WAIT": 1, "CLOSE_WAIT": 1, "SYN_SENT": 0} {
+		m, ok := report.Get(metrics.SeriesKey("TCPConnections", map[string]string{"state": state}))
+		require.True(t, ok, state)
+		assert.Equal(t, want, *m.Value, state)
+	}
+
+	// the second fixture has no tcp6, like hosts with IPv6 disabled
+	c.procDir = "testdata/net/second"
+	report = metrics.NewReport()
+	require.NoError(t, c.Collect(&report))
+	eth0 := map[string]string{"interface": "eth0"}
+	for id, want := range map[string]int64{
+		"NetReceivedBytes":      1048576,
+		"NetReceivedPackets":    1000,
+		"NetReceiveErrors":      3,
+		"NetReceiveDrops":       0,
+		"NetTransmittedBytes":   262144,
+		"NetTransmittedPackets": 300,
+		"NetTransmitErrors":     1,
+		"NetTransmitDrops":      0,
+	} {
+		m, ok := report.Get(metrics.SeriesKey(id, eth0))
+		require.True(t, ok, id)
+		assert.Equal(t, want, *m.Delta, id)
+	}
+	m, ok := report.Get(metrics.SeriesKey("TCPConnections", map[string]string{"state": "LISTEN"}))
+	require.True(t, ok)
+	assert.Equal(t, 1.0, *m.Value)
+
+	c.procDir = "testdata/missing"
+	assert.Error(t, c.Collect(&report))
+}
+
+func TestNetCollector_CounterReset(t *testing.T) {
+	c := NewNetCollector("testdata/net/first")
+	eth0 := map[string]string{"interface": "eth0"}
+
+	report := metrics.NewReport()
+	c.collectInterfaces(&report, map[string]interfaceStats{"eth0": {rxBytes: 1000, txBytes: 500}})
+
+	// received bytes are reset, so the whole interface is skipped although transmitted bytes grow
+	report = metrics.NewReport()
+	c.collectInterfaces(&report, map[string]interfaceStats{"eth0": {rxBytes: 100, txBytes: 600}})
+	assert.False(t, report.Has(metrics.SeriesKey("NetReceivedBytes", eth0)), "reset counters are skipped")
+	assert.False(t, report.Has(metrics.SeriesKey("NetTransmittedBytes", eth0)), "reset counters are skipped")
+
+	report = metrics.NewReport()
+	c.collectInterfaces(&report, map[string]interfaceStats{"eth0": {rxBytes: 300, txBytes: 700}})
+	for id, want := range map[string]int64{"NetReceivedBytes": 200, "NetTransmittedBytes": 100} {
+		m, ok := report.Get(metrics.SeriesKey(id, eth0))
+		require.True(t, ok, id)
+		assert.Equal(t, want, *m.Delta, id)
+	}
+}
@@ -36,6 +36,7 @@ func TestPollMetrics(t *testing.T) {
 		"Sys",
 		"TotalAlloc",
 		"RandomValue",
+		"PollCount",
 	}
 
 	report := PollMetrics()
@@ -0,0 +1,27 @@
+package sender
+
+import (
+	"crypto/rsa"
+
+	"github.com/mixailo/go-training-metrics/internal/service/encryption"
+)
+
+var publicKey *rsa.PublicKey
+
+// SetPublicKey makes request bodies encrypted with public key of server, bodies are not encrypted if it is nil
+func SetPublicKey(pub *rsa.PublicKey) {
+	publicKey = pub
+}
+
+// encrypt returns body to be sent along with its encrypted key, which is empty if body is not encrypted
+func encrypt(body []byte) ([]byte, string, error) {
+	if publicKey == nil {
+		return body, "", nil
+	}
+	key, ciphertext, err := encryption.Encrypt(publicKey, body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return ciphertext, key, nil
+}
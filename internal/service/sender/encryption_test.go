@@ -0,0 +1,49 @@
+package sender
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mixailo/go-training-metrics/internal/service/encryption"
+	"github.com/mixailo/go-training-metrics/internal/service/metrics"
+	"github.com/mixailo/go-training-metrics/internal/service/signature"
+)
+
+func TestSendBatchReport_Encrypted(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	setTestRetryPolicy(t, RetryPolicy{MaxAttempts: 1})
+	SetPublicKey(&priv.PublicKey)
+	SetSignKey("secret")
+	t.Cleanup(func() {
+		SetPublicKey(nil)
+		SetSignKey("")
+	})
+
+	var decoded []metrics.Metrics
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		assert.True(t, signature.Verify([]byte("secret"), body, r.Header.Get(signature.Header)), "encrypted body is signed")
+
+		compressed, err := encryption.Decrypt(priv, r.Header.Get(encryption.KeyHeader), body)
+		require.NoError(t, err)
+		zr, err := gzip.NewReader(bytes.NewReader(compressed))
+		require.NoError(t, err)
+		require.NoError(t, json.NewDecoder(zr).Decode(&decoded))
+	}))
+	defer server.Close()
+
+	require.NoError(t, SendBatchReport(testReport(1), testServerEndpoint(t, server)))
+	assert.Len(t, decoded, 2)
+}
@@ -0,0 +1,54 @@
+package sender
+
+import (
+	"hash/fnv"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/mixailo/go-training-metrics/internal/service/metrics"
+)
+
+var (
+	agentID string
+	// lastSeq starts at start time, so restarted agent does not reuse sequence numbers
+	lastSeq atomic.Uint64
+)
+
+func init() {
+	lastSeq.Store(uint64(time.Now().UnixNano()))
+}
+
+// SetAgentID makes requests idempotent, server remembers keys of requests of every agent separately.
+// Keys are not sent if id is empty.
+func SetAgentID(id string) {
+	agentID = id
+}
+
+// NextSeq returns a new sequence number of report
+func NextSeq() uint64 {
+	return lastSeq.Add(1)
+}
+
+// withSeq assigns sequence number to report unless it has one
+func withSeq(report metrics.Report) metrics.Report {
+	if report.Seq() == 0 {
+		report.SetSeq(NextSeq())
+	}
+
+	return report
+}
+
+// batchKey returns idempotency key of request sending the whole report
+func batchKey(report metrics.Report) string {
+	return strconv.FormatUint(report.Seq(), 10)
+}
+
+// metricKey returns idempotency key of request sending a single metric of report,
+// it does not depend on other metrics, so it is kept when report is sent partially
+func metricKey(report metrics.Report, metric metrics.Metrics) string {
+	h := fnv.New64a()
+	h.Write([]byte(metric.MType + " " + metric.SeriesKey()))
+
+	return strconv.FormatUint(report.Seq(), 10) + "-" + strconv.FormatUint(h.Sum64(), 16)
+}
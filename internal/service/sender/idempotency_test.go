@@ -0,0 +1,60 @@
+package sender
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mixailo/go-training-metrics/internal/service/metrics"
+)
+
+func TestSendReport_IdempotencyKeys(t *testing.T) {
+	setTestRetryPolicy(t, RetryPolicy{MaxAttempts: 2})
+	SetAgentID("agent")
+	t.Cleanup(func() { SetAgentID("") })
+
+	var (
+		mu   sync.Mutex
+		keys []string
+	)
+	failed := map[string]bool{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Equal(t, "agent", r.Header.Get(metrics.HeaderAgentID))
+		key := r.Header.Get(metrics.HeaderIdempotencyKey)
+		keys = append(keys, key)
+		// every request fails once
+		if !failed[key] {
+			failed[key] = true
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	report := testReport(1)
+	report.SetSeq(42)
+	require.NoError(t, SendReport(report, testServerEndpoint(t, server)))
+
+	// retries reuse keys, metrics of report have different ones
+	require.Len(t, keys, 4)
+	assert.Len(t, failed, 2)
+	for key := range failed {
+		assert.Regexp(t, `^42-[0-9a-f]+$`, key)
+	}
+}
+
+func Test_withSeq(t *testing.T) {
+	first := withSeq(testReport(1))
+	second := withSeq(testReport(2))
+	assert.NotZero(t, first.Seq())
+	assert.Greater(t, second.Seq(), first.Seq())
+	again := withSeq(first)
+	assert.Equal(t, first.Seq(), again.Seq())
+}
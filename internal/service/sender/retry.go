@@ -0,0 +1,169 @@
+package sender
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// StatusError is returned when server responds with unsuccessful status
+type StatusError struct {
+	StatusCode int
+	RetryAfter time.Duration // delay requested by server in Retry-After header, zero if there is none
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("server responded with status %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// Retryable reports if request may succeed later: server errors, timeouts and rate limiting are temporary,
+// other client errors mean the request is rejected
+func (e *StatusError) Retryable() bool {
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusRequestTimeout || e.StatusCode == http.StatusTooManyRequests
+}
+
+// IsPermanent reports if err means server rejected request, so it must not be sent again
+func IsPermanent(err error) bool {
+	var se *StatusError
+	return errors.As(err, &se) && !se.Retryable()
+}
+
+// Clock abstracts time for retry policy
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+// RetryPolicy retries failed requests with exponential backoff: the delay starts at BaseDelay and doubles
+// after every attempt up to MaxDelay, random part of it up to Jitter fraction is subtracted to spread retries
+// of agents. Delay requested by server in Retry-After is respected, request is not retried if it exceeds MaxDelay.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	Jitter      float64
+
+	clock  Clock
+	random func() float64
+}
+
+// DefaultRetryPolicy makes 4 attempts in about 0.7 seconds
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 4,
+	BaseDelay:   100 * time.Millisecond,
+	MaxDelay:    5 * time.Second,
+	Jitter:      0.2,
+}
+
+var retryPolicy = DefaultRetryPolicy
+
+// SetRetryPolicy sets policy of sending reports
+func SetRetryPolicy(p RetryPolicy) {
+	retryPolicy = p
+}
+
+// Do calls send until it succeeds, fails with error which is not retryable or attempts are exhausted
+func (p RetryPolicy) Do(send func() error) error {
+	clock := p.clockOrReal()
+
+	var err error
+	for attempt := 0; attempt < max(p.MaxAttempts, 1); attempt++ {
+		if attempt > 0 {
+			delay, ok := p.delay(attempt, err)
+			if !ok {
+				return err
+			}
+			clock.Sleep(delay)
+		}
+
+		if err = send(); err == nil || !retryable(err) {
+			return err
+		}
+	}
+
+	return err
+}
+
+func (p RetryPolicy) clockOrReal() Clock {
+	if p.clock == nil {
+		return realClock{}
+	}
+
+	return p.clock
+}
+
+// delay returns delay before attempt, it is not ok to retry if server asks to wait longer than MaxDelay
+func (p RetryPolicy) delay(attempt int, err error) (time.Duration, bool) {
+	var se *StatusError
+	if errors.As(err, &se) && se.RetryAfter > 0 {
+		return se.RetryAfter, p.MaxDelay <= 0 || se.RetryAfter <= p.MaxDelay
+	}
+
+	delay := p.BaseDelay
+	for i := 1; i < attempt && (p.MaxDelay <= 0 || delay < p.MaxDelay); i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 {
+		delay = min(delay, p.MaxDelay)
+	}
+
+	if p.Jitter > 0 {
+		random := p.random
+		if random == nil {
+			random = rand.Float64
+		}
+		delay -= time.Duration(float64(delay) * min(p.Jitter, 1) * random())
+	}
+
+	return delay, true
+}
+
+func retryable(err error) bool {
+	if errors.Is(err, ErrBatchNotSupported) {
+		return false
+	}
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.Retryable()
+	}
+
+	// transport errors
+	return true
+}
+
+// checkResponse returns StatusError for unsuccessful response
+func checkResponse(response *http.Response, clock Clock) error {
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return nil
+	}
+
+	return &StatusError{StatusCode: response.StatusCode, RetryAfter: parseRetryAfter(response.Header.Get("Retry-After"), clock)}
+}
+
+// parseRetryAfter reads delay in seconds or HTTP date, zero is returned for invalid or past values
+func parseRetryAfter(value string, clock Clock) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(clock.Now()), 0)
+	}
+
+	return 0
+}
@@ -0,0 +1,234 @@
+package sender
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mixailo/go-training-metrics/internal/service/metrics"
+)
+
+// fakeClock records sleeps instead of sleeping
+type fakeClock struct {
+	now    time.Time
+	sleeps []time.Duration
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.sleeps = append(c.sleeps, d)
+}
+
+// setTestRetryPolicy replaces retry policy of the package until the test ends
+func setTestRetryPolicy(t *testing.T, p RetryPolicy) *fakeClock {
+	clock := &fakeClock{now: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	p.clock = clock
+	prev := retryPolicy
+	SetRetryPolicy(p)
+	t.Cleanup(func() {
+		SetRetryPolicy(prev)
+	})
+
+	return clock
+}
+
+type scriptedResponse struct {
+	status     int
+	retryAfter string
+}
+
+// scriptedServer responds with given responses one by one, the last one is repeated
+func scriptedServer(t *testing.T, responses ...scriptedResponse) (*httptest.Server, func() int) {
+	var (
+		mu       sync.Mutex
+		requests int
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		resp := responses[min(requests, len(responses)-1)]
+		requests++
+		if resp.retryAfter != "" {
+			w.Header().Set("Retry-After", resp.retryAfter)
+		}
+		w.WriteHeader(resp.status)
+	}))
+	t.Cleanup(server.Close)
+
+	return server, func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests
+	}
+}
+
+func TestRetryPolicy_Send(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 4, BaseDelay: 100 * time.Millisecond, MaxDelay: 5 * time.Second}
+	httpDate := time.Date(2024, 5, 1, 12, 0, 3, 0, time.UTC).Format(http.TimeFormat)
+
+	tests := []struct {
+		name          string
+		responses     []scriptedResponse
+		wantErr       bool
+		wantPermanent bool
+		wantRequests  int
+		wantSleeps    []time.Duration
+	}{
+		{
+			name:         "server errors then success",
+			responses:    []scriptedResponse{{status: 500}, {status: 502}, {status: 200}},
+			wantRequests: 3,
+			wantSleeps:   []time.Duration{100 * time.Millisecond, 200 * time.Millisecond},
+		},
+		{
+			name:          "rejected request is not retried",
+			responses:     []scriptedResponse{{status: 400}},
+			wantErr:       true,
+			wantPermanent: true,
+			wantRequests:  1,
+		},
+		{
+			name:         "retry after seconds",
+			responses:    []scriptedResponse{{status: 429, retryAfter: "2"}, {status: 200}},
+			wantRequests: 2,
+			wantSleeps:   []time.Duration{2 * time.Second},
+		},
+		{
+			name:         "retry after date",
+			responses:    []scriptedResponse{{status: 503, retryAfter: httpDate}, {status: 200}},
+			wantRequests: 2,
+			wantSleeps:   []time.Duration{3 * time.Second},
+		},
+		{
+			name:         "retry after exceeds max delay",
+			responses:    []scriptedResponse{{status: 503, retryAfter: "60"}},
+			wantErr:      true,
+			wantRequests: 1,
+		},
+		{
+			name:         "attempts are exhausted",
+			responses:    []scriptedResponse{{status: 500}},
+			wantErr:      true,
+			wantRequests: 4,
+			wantSleeps:   []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := setTestRetryPolicy(t, policy)
+			server, requests := scriptedServer(t, tt.responses...)
+
+			report := testReport(1)
+			metric, _ := report.Get("PollCount")
+			err := sendReportMetricWithRetries(metric, "1", testServerEndpoint(t, server))
+			if tt.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantPermanent, IsPermanent(err))
+			assert.Equal(t, tt.wantRequests, requests())
+			assert.Equal(t, tt.wantSleeps, clock.sleeps)
+		})
+	}
+}
+
+func TestRetryPolicy_delay(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  RetryPolicy
+		attempt int
+		err     error
+		want    time.Duration
+		wantOk  bool
+	}{
+		{
+			name:    "first retry",
+			policy:  RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second},
+			attempt: 1,
+			want:    100 * time.Millisecond,
+			wantOk:  true,
+		},
+		{
+			name:    "capped by max delay",
+			policy:  RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second},
+			attempt: 10,
+			want:    time.Second,
+			wantOk:  true,
+		},
+		{
+			name:    "jitter",
+			policy:  RetryPolicy{BaseDelay: 100 * time.Millisecond, Jitter: 0.2, random: func() float64 { return 0.5 }},
+			attempt: 2,
+			want:    180 * time.Millisecond,
+			wantOk:  true,
+		},
+		{
+			name:    "retry after ignores jitter",
+			policy:  RetryPolicy{BaseDelay: 100 * time.Millisecond, Jitter: 0.2, random: func() float64 { return 0.5 }},
+			attempt: 1,
+			err:     &StatusError{StatusCode: http.StatusTooManyRequests, RetryAfter: time.Second},
+			want:    time.Second,
+			wantOk:  true,
+		},
+		{
+			name:    "retry after exceeds max delay",
+			policy:  RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second},
+			attempt: 1,
+			err:     &StatusError{StatusCode: http.StatusServiceUnavailable, RetryAfter: time.Minute},
+			want:    time.Minute,
+			wantOk:  false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.policy.delay(tt.attempt, tt.err)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.wantOk, ok)
+		})
+	}
+}
+
+func Test_retryable(t *testing.T) {
+	assert.True(t, retryable(errors.New("connection refused")))
+	assert.True(t, retryable(&StatusError{StatusCode: http.StatusInternalServerError}))
+	assert.True(t, retryable(&StatusError{StatusCode: http.StatusRequestTimeout}))
+	assert.False(t, retryable(&StatusError{StatusCode: http.StatusBadRequest}))
+	assert.False(t, retryable(ErrBatchNotSupported))
+}
+
+func Test_parseRetryAfter(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+
+	assert.Equal(t, 120*time.Second, parseRetryAfter("120", clock))
+	assert.Equal(t, 90*time.Second, parseRetryAfter("Wed, 01 May 2024 12:01:30 GMT", clock))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("Wed, 01 May 2024 11:00:00 GMT", clock))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("-5", clock))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("soon", clock))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("", clock))
+}
+
+func TestSendReport_Rejected(t *testing.T) {
+	setTestRetryPolicy(t, DefaultRetryPolicy)
+	server, requests := scriptedServer(t, scriptedResponse{status: http.StatusBadRequest})
+
+	spool, err := NewSpool(t.TempDir(), 0, 0)
+	require.NoError(t, err)
+	err = spool.Send(testReport(1), func(report metrics.Report) error {
+		return SendReport(report, testServerEndpoint(t, server))
+	})
+
+	// rejected metrics are neither retried nor spooled
+	assert.True(t, IsPermanent(err))
+	assert.Equal(t, 2, requests())
+	assert.Equal(t, 0, spool.Len())
+}
@@ -4,16 +4,21 @@ import (
 	"bytes"
 	"compress/gzip"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"go.uber.org/zap"
 	"net/http"
 	"strconv"
 	"strings"
-	"time"
 
+	"github.com/mixailo/go-training-metrics/internal/service/encryption"
 	"github.com/mixailo/go-training-metrics/internal/service/logger"
 	"github.com/mixailo/go-training-metrics/internal/service/metrics"
 )
 
+// ErrBatchNotSupported is returned when server has no batch update endpoint
+var ErrBatchNotSupported = errors.New("batch update is not supported by server")
+
 type ServerEndpoint struct {
 	Scheme string
 	Host   string
@@ -36,68 +41,167 @@ func (se *ServerEndpoint) CreateURL(path string) string {
 	return se.String() + "/" + strings.TrimLeft(path, "/")
 }
 
-func SendReport(report metrics.Report, endpoint ServerEndpoint) (err error) {
+// UnsentError is returned when report is delivered partially, Unsent keeps metrics which are not delivered
+type UnsentError struct {
+	Unsent metrics.Report
+	Err    error
+}
+
+func (e *UnsentError) Error() string {
+	return fmt.Sprintf("%d metrics are not sent: %s", e.Unsent.Length(), e.Err)
+}
+
+func (e *UnsentError) Unwrap() error {
+	return e.Err
+}
+
+// SendReport sends metrics one by one, metrics which may be delivered later are returned in UnsentError.
+// Metrics rejected by server are dropped.
+func SendReport(report metrics.Report, endpoint ServerEndpoint) error {
+	report = withSeq(report)
+	unsent := metrics.NewReport()
+	unsent.SetSeq(report.Seq())
+	var errs, rejected []error
 	for _, metric := range report.All() {
 		logger.Log.Info("send report", zap.String("metric", metric.String()))
-		err = sendReportMetricWithRetries(metric, endpoint)
-		if err != nil {
-			logger.Log.Info("error", zap.Error(err))
+		err := sendReportMetricWithRetries(metric, metricKey(report, metric), endpoint)
+		switch {
+		case err == nil:
+		case IsPermanent(err):
+			logger.Log.Error("metric is rejected by server", zap.String("metric", metric.SeriesKey()), zap.Error(err))
+			rejected = append(rejected, err)
+		default:
+			unsent.Add(metric)
+			errs = append(errs, err)
 		}
 	}
-	return
+	if len(errs) > 0 {
+		return &UnsentError{Unsent: unsent, Err: errors.Join(errs...)}
+	}
+
+	return errors.Join(rejected...)
 }
 
-func sendReportMetricWithRetries(metric metrics.Metrics, endpoint ServerEndpoint) (err error) {
-	for i := 0; i < 3; i++ {
-		err = sendReportMetric(metric, endpoint)
-		if err == nil {
-			break
-		} else {
-			logger.Log.Info("error, will retry request after 0.05 secs", zap.Error(err))
-			time.Sleep(50 * time.Millisecond)
-		}
+// SendBatchReport sends the whole report in a single request,
+// falls back to sending metrics one by one if server does not support batches
+func SendBatchReport(report metrics.Report, endpoint ServerEndpoint) error {
+	batch := report.All()
+	if len(batch) == 0 {
+		return nil
+	}
+
+	report = withSeq(report)
+	logger.Log.Info("send batch report", zap.Int("metrics", len(batch)), zap.Uint64("seq", report.Seq()))
+	err := withRetries(func() error {
+		return sendReportBatch(batch, batchKey(report), endpoint)
+	})
+	if errors.Is(err, ErrBatchNotSupported) {
+		logger.Log.Info("batch is not supported, fall back to single metric updates")
+		return SendReport(report, endpoint)
 	}
 
 	return err
 }
 
-func sendReportMetric(metric metrics.Metrics, endpoint ServerEndpoint) error {
+func sendReportMetricWithRetries(metric metrics.Metrics, key string, endpoint ServerEndpoint) (err error) {
+	return withRetries(func() error {
+		return sendReportMetric(metric, key, endpoint)
+	})
+}
+
+func withRetries(send func() error) error {
+	return retryPolicy.Do(func() error {
+		err := send()
+		if err != nil {
+			logger.Log.Info("request error", zap.Error(err), zap.Bool("retryable", retryable(err)))
+		}
+		return err
+	})
+}
+
+func sendReportMetric(metric metrics.Metrics, key string, endpoint ServerEndpoint) error {
+	response, err := postGzipped(metric, endpoint.CreateURL(reportPath()), key)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	return checkResponse(response, retryPolicy.clockOrReal())
+}
+
+func sendReportBatch(batch []metrics.Metrics, key string, endpoint ServerEndpoint) error {
+	response, err := postGzipped(batch, endpoint.CreateURL(batchReportPath()), key)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return ErrBatchNotSupported
+	}
+
+	return checkResponse(response, retryPolicy.clockOrReal())
+}
+
+// postGzipped sends body with idempotency key, retries of the request must use the same key
+func postGzipped(body any, url string, key string) (*http.Response, error) {
 	var buf bytes.Buffer
 
 	// create gzip encoder
 	zl, err := gzip.NewWriterLevel(&buf, gzip.BestSpeed)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// json-encode request body
 	enc := json.NewEncoder(zl)
-	err = enc.Encode(metric)
+	err = enc.Encode(body)
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 	zl.Close()
 
+	// compressed body is encrypted and the result is signed
+	payload, encryptedKey, err := encrypt(buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
 	// send request
-	reportURLPath := endpoint.CreateURL(reportPath())
-	request, err := http.NewRequest("POST", reportURLPath, &buf)
+	request, err := http.NewRequest("POST", url, bytes.NewReader(payload))
 	if err != nil {
-		return err
+		return nil, err
 	}
 	request.Header.Set("Content-Type", "application/json")
 	request.Header.Set("Content-Encoding", "gzip")
+	if encryptedKey != "" {
+		request.Header.Set(encryption.KeyHeader, encryptedKey)
+	}
+	if agentID != "" && key != "" {
+		request.Header.Set(metrics.HeaderAgentID, agentID)
+		request.Header.Set(metrics.HeaderIdempotencyKey, key)
+	}
+	sign(request, payload)
 
 	response, err := http.DefaultClient.Do(request)
-
-	if err == nil {
-		defer response.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if err = verifyResponse(response); err != nil {
+		response.Body.Close()
+		return nil, err
 	}
 
-	return err
+	return response, nil
 }
 
 func reportPath() (result string) {
 	result = "/update/"
 	return
 }
+
+func batchReportPath() (result string) {
+	result = "/updates/"
+	return
+}
@@ -1,9 +1,17 @@
 package sender
 
 import (
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/mixailo/go-training-metrics/internal/service/metrics"
 )
@@ -74,3 +82,64 @@ func Test_reportPath(t *testing.T) {
 func Test_sendReportMetric(t *testing.T) {
 	t.Skip()
 }
+
+func testServerEndpoint(t *testing.T, server *httptest.Server) ServerEndpoint {
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	port, err := strconv.Atoi(u.Port())
+	require.NoError(t, err)
+
+	return NewServerEndpoint(u.Scheme, u.Hostname(), port)
+}
+
+func TestSendBatchReport(t *testing.T) {
+	report := metrics.NewReport()
+	report.AddUnConverted(metrics.TypeGauge, "Alloc", "10.5")
+	report.AddUnConverted(metrics.TypeCounter, "PollCount", "5")
+
+	t.Run("single batch request", func(t *testing.T) {
+		var (
+			mu      sync.Mutex
+			paths   []string
+			decoded []metrics.Metrics
+		)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			paths = append(paths, r.URL.Path)
+
+			assert.Equal(t, "gzip", r.Header.Get("Content-Encoding"))
+			zr, err := gzip.NewReader(r.Body)
+			require.NoError(t, err)
+			require.NoError(t, json.NewDecoder(zr).Decode(&decoded))
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer server.Close()
+
+		require.NoError(t, SendBatchReport(report, testServerEndpoint(t, server)))
+		assert.Equal(t, []string{"/updates/"}, paths)
+		assert.Len(t, decoded, 2)
+	})
+
+	t.Run("fallback to single updates", func(t *testing.T) {
+		var (
+			mu    sync.Mutex
+			paths []string
+		)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			paths = append(paths, r.URL.Path)
+
+			if r.URL.Path == "/updates/" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer server.Close()
+
+		require.NoError(t, SendBatchReport(report, testServerEndpoint(t, server)))
+		assert.Equal(t, []string{"/updates/", "/update/", "/update/"}, paths)
+	})
+}
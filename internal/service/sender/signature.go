@@ -0,0 +1,54 @@
+package sender
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/mixailo/go-training-metrics/internal/service/signature"
+)
+
+// ErrSignatureMismatch is returned when response is signed with other key or altered on the way
+var ErrSignatureMismatch = errors.New("response signature mismatch")
+
+var signKey []byte
+
+// SetSignKey makes requests signed with HMAC-SHA256 of key and signatures of responses verified.
+// Requests are not signed if key is empty.
+func SetSignKey(key string) {
+	signKey = nil
+	if key != "" {
+		signKey = []byte(key)
+	}
+}
+
+// sign adds signature of body, which is sent as is, to request
+func sign(request *http.Request, body []byte) {
+	if signKey == nil {
+		return
+	}
+	request.Header.Set(signature.Header, signature.Sign(signKey, body))
+	// signature of response covers its body as it is sent, so it must not be decompressed by transport
+	request.Header.Set("Accept-Encoding", "gzip")
+}
+
+// verifyResponse checks signature of response body, response of server which does not sign them is accepted
+func verifyResponse(response *http.Response) error {
+	hash := response.Header.Get(signature.Header)
+	if signKey == nil || hash == "" {
+		return nil
+	}
+
+	body, err := io.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil {
+		return err
+	}
+	response.Body = io.NopCloser(bytes.NewReader(body))
+	if !signature.Verify(signKey, body, hash) {
+		return ErrSignatureMismatch
+	}
+
+	return nil
+}
@@ -0,0 +1,52 @@
+package sender
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mixailo/go-training-metrics/internal/service/signature"
+)
+
+func TestSendBatchReport_Signed(t *testing.T) {
+	setTestRetryPolicy(t, RetryPolicy{MaxAttempts: 1})
+	SetSignKey("secret")
+	t.Cleanup(func() { SetSignKey("") })
+
+	tests := []struct {
+		name    string
+		respKey string
+		wantErr error
+	}{
+		{name: "signed response", respKey: "secret"},
+		{name: "unsigned response"},
+		{name: "response signed with other key", respKey: "other", wantErr: ErrSignatureMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, err := io.ReadAll(r.Body)
+				require.NoError(t, err)
+				assert.True(t, signature.Verify([]byte("secret"), body, r.Header.Get(signature.Header)), "compressed body is signed")
+
+				resp := []byte(`[]`)
+				if tt.respKey != "" {
+					w.Header().Set(signature.Header, signature.Sign([]byte(tt.respKey), resp))
+				}
+				w.Write(resp)
+			}))
+			defer server.Close()
+
+			err := SendBatchReport(testReport(1), testServerEndpoint(t, server))
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
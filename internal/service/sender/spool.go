@@ -0,0 +1,386 @@
+package sender
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mixailo/go-training-metrics/internal/service/logger"
+	"github.com/mixailo/go-training-metrics/internal/service/metrics"
+)
+
+const spoolExt = ".json"
+
+// spooled is a report kept in spool file
+type spooled struct {
+	CreatedAt time.Time         `json:"createdAt"`
+	Seq       uint64            `json:"seq,omitempty"`       // sequence number of report, so its retries are idempotent
+	Attempted bool              `json:"attempted,omitempty"` // report was sent, so server may have applied it
+	Metrics   []metrics.Metrics `json:"metrics"`
+}
+
+type spoolEntry struct {
+	seq  uint64
+	size int64
+}
+
+// Spool keeps reports which cannot be delivered in a directory, one file per report, and sends them in order
+// before newer reports once server is available. When spool exceeds maxSize or keeps reports older than maxAge,
+// the oldest reports are merged, so gauges keep the latest values and counter deltas are summed, not lost.
+// Reports which were sent are never merged: server may have applied them by their idempotency keys.
+type Spool struct {
+	dir     string
+	maxSize int64         // total size of files in bytes, zero means no limit
+	maxAge  time.Duration // zero means no limit
+	now     func() time.Time
+
+	mu        sync.Mutex
+	entries   []spoolEntry // ordered by seq, the oldest first
+	next      uint64
+	sending   bool         // report is sent directly, it is not spooled yet
+	replaying bool         // the oldest entry is sent by replay, it must not be merged
+	pending   atomic.Int64 // number of entries, read without lock
+}
+
+// NewSpool opens spool in dir, reports spooled by previous runs are kept
+func NewSpool(dir string, maxSize int64, maxAge time.Duration) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &Spool{dir: dir, maxSize: maxSize, maxAge: maxAge, now: time.Now, next: 1}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		seq, err := strconv.ParseUint(strings.TrimSuffix(f.Name(), spoolExt), 10, 64)
+		if f.IsDir() || !strings.HasSuffix(f.Name(), spoolExt) || err != nil {
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			return nil, err
+		}
+		s.entries = append(s.entries, spoolEntry{seq: seq, size: info.Size()})
+		if seq >= s.next {
+			s.next = seq + 1
+		}
+	}
+	sort.Slice(s.entries, func(i, j int) bool {
+		return s.entries[i].seq < s.entries[j].seq
+	})
+	s.pending.Store(int64(len(s.entries)))
+
+	return s, nil
+}
+
+// Len returns number of spooled reports
+func (s *Spool) Len() int {
+	return int(s.pending.Load())
+}
+
+// Send delivers spooled reports and then report by send. Reports which cannot be delivered are spooled,
+// so error is returned only when report cannot be spooled or it is rejected by server. While spool is empty
+// report is sent without waiting for lock, newer reports are spooled until it is delivered or spooled itself.
+// Reports are delivered one by one to keep order of updates, so concurrent senders only spool their reports
+// while another report is sent; lock is not held while waiting for server.
+func (s *Spool) Send(report metrics.Report, send func(metrics.Report) error) error {
+	// spooled report is sent with the same idempotency keys, so it is not applied twice
+	// if server applied it but its response is lost
+	report = withSeq(report)
+
+	s.mu.Lock()
+	if len(s.entries) > 0 || s.sending || s.replaying {
+		defer s.mu.Unlock()
+
+		// newer report is sent after spooled ones to keep order of updates
+		if err := s.push(report, false); err != nil {
+			return err
+		}
+		if s.sending || s.replaying {
+			// the report being sent replays spool when it is done, replay in progress sends it as well
+			return nil
+		}
+		return s.replay(send)
+	}
+	// undelivered report takes its place in spool before reports spooled while it is sent
+	seq := s.next
+	s.next++
+	s.sending = true
+	s.mu.Unlock()
+
+	err := send(report)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sending = false
+	if err != nil && !IsPermanent(err) {
+		logger.Log.Warn("report is not delivered, spool it", zap.Error(err))
+		return s.insert(seq, unsent(report, err), true)
+	}
+	if len(s.entries) > 0 {
+		if replayErr := s.replay(send); replayErr != nil {
+			return replayErr
+		}
+	}
+
+	// report rejected by server would be rejected again
+	return err
+}
+
+// replay sends spooled reports in order until the first failure. It is called with s.mu held,
+// the lock is released while report is sent, so reports of other senders are spooled meanwhile.
+func (s *Spool) replay(send func(metrics.Report) error) error {
+	if s.replaying {
+		return nil
+	}
+	s.replaying = true
+	defer func() {
+		s.replaying = false
+	}()
+
+	for len(s.entries) > 0 {
+		entry := s.entries[0]
+		report, _, err := s.read(entry)
+		if err != nil {
+			// broken file would block the spool forever
+			logger.Log.Error("drop unreadable spooled report", zap.Error(err), zap.Uint64("seq", entry.seq))
+			if err = s.remove(0); err != nil {
+				return err
+			}
+			continue
+		}
+
+		s.mu.Unlock()
+		err = send(report)
+		s.mu.Lock()
+		// entries are only appended or merged after the oldest one meanwhile, so it is still the first
+		if IsPermanent(err) {
+			logger.Log.Error("spooled report is rejected by server, drop it", zap.Error(err), zap.Uint64("seq", entry.seq))
+			err = nil
+		}
+		if err != nil {
+			logger.Log.Warn("spooled reports are not delivered", zap.Error(err), zap.Int("reports", len(s.entries)))
+			// delivered metrics must not be sent again
+			return s.rewrite(0, unsent(report, err))
+		}
+		if err = s.remove(0); err != nil {
+			return err
+		}
+	}
+	logger.Log.Info("spooled reports are delivered")
+
+	return nil
+}
+
+// push appends report to spool and enforces limits, attempted tells if the report was sent
+func (s *Spool) push(report metrics.Report, attempted bool) error {
+	seq := s.next
+	s.next++
+
+	return s.insert(seq, report, attempted)
+}
+
+// insert spools report in order of seq and enforces limits
+func (s *Spool) insert(seq uint64, report metrics.Report, attempted bool) error {
+	if report.Length() == 0 {
+		return nil
+	}
+
+	entry := spoolEntry{seq: seq}
+	size, err := s.write(entry.seq, spooled{CreatedAt: s.now(), Seq: report.Seq(), Attempted: attempted, Metrics: report.All()})
+	if err != nil {
+		return err
+	}
+	entry.size = size
+	i := sort.Search(len(s.entries), func(i int) bool {
+		return s.entries[i].seq > seq
+	})
+	s.entries = slices.Insert(s.entries, i, entry)
+	s.pending.Store(int64(len(s.entries)))
+
+	return s.enforceLimits()
+}
+
+// enforceLimits merges expired reports into one and then merges the oldest reports until spool fits into max size
+func (s *Spool) enforceLimits() error {
+	if s.maxAge > 0 {
+		deadline := s.now().Add(-s.maxAge)
+		for i := s.mergeable(); i >= 0; i = s.mergeable() {
+			_, createdAt, err := s.read(s.entries[i+1])
+			if err != nil || !createdAt.Before(deadline) {
+				break
+			}
+			if err = s.merge(i); err != nil {
+				return err
+			}
+		}
+	}
+
+	if s.maxSize > 0 {
+		for i := s.mergeable(); i >= 0 && s.size() > s.maxSize; i = s.mergeable() {
+			if err := s.merge(i); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// mergeable returns index of the oldest report which may be merged into the next one, or -1 if there is none.
+// Merged report is sent with a new idempotency key, so reports which may have been applied by server are skipped.
+func (s *Spool) mergeable() int {
+	first := 0
+	if s.replaying {
+		// report being replayed may be applied by server
+		first = 1
+	}
+	for i := first; i+1 < len(s.entries); i++ {
+		data, err := os.ReadFile(s.path(s.entries[i].seq))
+		var sp spooled
+		if err != nil || json.Unmarshal(data, &sp) != nil || !sp.Attempted {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// merge merges report i into the next one
+func (s *Spool) merge(i int) error {
+	oldest, _, err := s.read(s.entries[i])
+	if err != nil {
+		return s.remove(i)
+	}
+	next, createdAt, err := s.read(s.entries[i+1])
+	if err != nil {
+		next, createdAt = metrics.NewReport(), s.now()
+	}
+
+	oldest.Merge(next)
+	// merged report differs from both of them, so it must not be taken for any of them by server
+	if _, err = s.write(s.entries[i+1].seq, spooled{CreatedAt: createdAt, Seq: NextSeq(), Metrics: oldest.All()}); err != nil {
+		return err
+	}
+	if err = s.remove(i); err != nil {
+		return err
+	}
+	logger.Log.Warn("spool limit is exceeded, the oldest reports are merged", zap.Int("reports", len(s.entries)))
+
+	return s.refreshSize(i)
+}
+
+// rewrite replaces report of entry i with its part which was sent but not delivered
+func (s *Spool) rewrite(i int, report metrics.Report) error {
+	_, createdAt, err := s.read(s.entries[i])
+	if err != nil {
+		createdAt = s.now()
+	}
+	if _, err = s.write(s.entries[i].seq, spooled{CreatedAt: createdAt, Seq: report.Seq(), Attempted: true, Metrics: report.All()}); err != nil {
+		return err
+	}
+
+	return s.refreshSize(i)
+}
+
+func (s *Spool) refreshSize(i int) error {
+	info, err := os.Stat(s.path(s.entries[i].seq))
+	if err != nil {
+		return err
+	}
+	s.entries[i].size = info.Size()
+
+	return nil
+}
+
+func (s *Spool) size() int64 {
+	var total int64
+	for _, e := range s.entries {
+		total += e.size
+	}
+
+	return total
+}
+
+func (s *Spool) path(seq uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", seq, spoolExt))
+}
+
+func (s *Spool) read(entry spoolEntry) (metrics.Report, time.Time, error) {
+	data, err := os.ReadFile(s.path(entry.seq))
+	if err != nil {
+		return metrics.Report{}, time.Time{}, err
+	}
+	var sp spooled
+	if err = json.Unmarshal(data, &sp); err != nil {
+		return metrics.Report{}, time.Time{}, err
+	}
+
+	report := metrics.NewReport()
+	report.SetSeq(sp.Seq)
+	for _, m := range sp.Metrics {
+		report.Add(m)
+	}
+
+	return report, sp.CreatedAt, nil
+}
+
+// write saves report to a temporary file renamed to the spool file, so spool never keeps partial reports
+func (s *Spool) write(seq uint64, sp spooled) (int64, error) {
+	data, err := json.Marshal(sp)
+	if err != nil {
+		return 0, err
+	}
+
+	tmp, err := os.CreateTemp(s.dir, "spool.tmp*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err = tmp.Close(); err != nil {
+		return 0, err
+	}
+
+	return int64(len(data)), os.Rename(tmp.Name(), s.path(seq))
+}
+
+func (s *Spool) remove(i int) error {
+	if err := os.Remove(s.path(s.entries[i].seq)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	s.entries = append(s.entries[:i], s.entries[i+1:]...)
+	s.pending.Store(int64(len(s.entries)))
+
+	return nil
+}
+
+// unsent returns metrics of report which are not delivered
+func unsent(report metrics.Report, err error) metrics.Report {
+	var ue *UnsentError
+	if errors.As(err, &ue) {
+		return ue.Unsent
+	}
+
+	return report
+}
@@ -0,0 +1,328 @@
+package sender
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mixailo/go-training-metrics/internal/service/metrics"
+)
+
+var errUnavailable = errors.New("server is unavailable")
+
+// fakeServer records delivered reports, it fails while it is down
+type fakeServer struct {
+	down      bool
+	delivered []metrics.Report
+}
+
+func (s *fakeServer) send(report metrics.Report) error {
+	if s.down {
+		return errUnavailable
+	}
+	s.delivered = append(s.delivered, report)
+	return nil
+}
+
+// totals returns sum of delivered counter deltas and the last delivered gauges
+func (s *fakeServer) totals() (counters map[string]int64, gauges map[string]float64) {
+	counters, gauges = make(map[string]int64), make(map[string]float64)
+	for _, r := range s.delivered {
+		for _, m := range r.All() {
+			if m.Delta != nil {
+				counters[m.ID] += *m.Delta
+			}
+			if m.Value != nil {
+				gauges[m.ID] = *m.Value
+			}
+		}
+	}
+
+	return counters, gauges
+}
+
+func testReport(i int) metrics.Report {
+	report := metrics.NewReport()
+	report.AddUnConverted(metrics.TypeCounter, "PollCount", "1")
+	report.AddUnConverted(metrics.TypeGauge, "Seq", strconv.Itoa(i))
+
+	return report
+}
+
+func TestSpool_Send(t *testing.T) {
+	spool, err := NewSpool(t.TempDir(), 0, 0)
+	require.NoError(t, err)
+	server := &fakeServer{}
+
+	require.NoError(t, spool.Send(testReport(1), server.send))
+	assert.Equal(t, 0, spool.Len())
+	assert.Len(t, server.delivered, 1)
+
+	server.down = true
+	require.NoError(t, spool.Send(testReport(2), server.send))
+	require.NoError(t, spool.Send(testReport(3), server.send))
+	assert.Equal(t, 2, spool.Len())
+	assert.Len(t, server.delivered, 1)
+
+	server.down = false
+	require.NoError(t, spool.Send(testReport(4), server.send))
+	assert.Equal(t, 0, spool.Len())
+	require.Len(t, server.delivered, 4)
+	for i, r := range server.delivered {
+		m, ok := r.Get("Seq")
+		require.True(t, ok)
+		assert.Equal(t, float64(i+1), *m.Value, "reports are delivered in order")
+	}
+}
+
+func TestSpool_SendConcurrently(t *testing.T) {
+	for _, delivered := range []bool{true, false} {
+		t.Run(fmt.Sprintf("delivered %v", delivered), func(t *testing.T) {
+			spool, err := NewSpool(t.TempDir(), 0, 0)
+			require.NoError(t, err)
+			server := &fakeServer{}
+
+			// the first report is sent while the second one arrives
+			started, release := make(chan struct{}), make(chan struct{})
+			first := true
+			send := func(report metrics.Report) error {
+				if !first {
+					return server.send(report)
+				}
+				first = false
+				close(started)
+				<-release
+				if !delivered {
+					return errUnavailable
+				}
+				return server.send(report)
+			}
+			done := make(chan error)
+			go func() {
+				done <- spool.Send(testReport(1), send)
+			}()
+			<-started
+			require.NoError(t, spool.Send(testReport(2), server.send))
+			assert.Empty(t, server.delivered, "newer report waits for the one being sent")
+			close(release)
+			require.NoError(t, <-done)
+
+			require.NoError(t, spool.Send(testReport(3), send))
+			assert.Equal(t, 0, spool.Len())
+			require.Len(t, server.delivered, 3)
+			for i, r := range server.delivered {
+				m, _ := r.Get("Seq")
+				assert.Equal(t, float64(i+1), *m.Value, "reports are delivered in order")
+			}
+		})
+	}
+}
+
+func TestSpool_SendWhileReplaying(t *testing.T) {
+	spool, err := NewSpool(t.TempDir(), 1, 0)
+	require.NoError(t, err)
+	server := &fakeServer{down: true}
+	for i := 1; i <= 4; i++ {
+		require.NoError(t, spool.Send(testReport(i), server.send))
+	}
+	require.Equal(t, 2, spool.Len(), "the first report was sent, the others are merged")
+
+	// replay waits for server on the merged report
+	server.down = false
+	started, release := make(chan struct{}), make(chan struct{})
+	send := func(report metrics.Report) error {
+		if m, ok := report.Get("Seq"); ok && *m.Value == 4 {
+			close(started)
+			<-release
+		}
+		return server.send(report)
+	}
+	done := make(chan error)
+	go func() {
+		done <- spool.Send(metrics.NewReport(), send)
+	}()
+	<-started
+
+	spooled := make(chan error)
+	go func() {
+		spooled <- spool.Send(testReport(5), send)
+	}()
+	select {
+	case err := <-spooled:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "report is not spooled while spool is replayed")
+	}
+	assert.Len(t, server.delivered, 1, "newer report waits for replay")
+
+	close(release)
+	require.NoError(t, <-done)
+	assert.Equal(t, 0, spool.Len())
+	require.Len(t, server.delivered, 3)
+	counters, gauges := server.totals()
+	assert.Equal(t, int64(5), counters["PollCount"], "report being replayed is not merged")
+	assert.Equal(t, 5.0, gauges["Seq"], "the latest gauge is delivered last")
+}
+
+func TestSpool_SendPartially(t *testing.T) {
+	spool, err := NewSpool(t.TempDir(), 0, 0)
+	require.NoError(t, err)
+
+	var delivered []metrics.Metrics
+	partial := func(report metrics.Report) error {
+		unsent := metrics.NewReport()
+		for _, m := range report.All() {
+			if m.ID == "PollCount" {
+				unsent.Add(m)
+			} else {
+				delivered = append(delivered, m)
+			}
+		}
+		if unsent.Length() > 0 {
+			return &UnsentError{Unsent: unsent, Err: errUnavailable}
+		}
+		return nil
+	}
+	require.NoError(t, spool.Send(testReport(1), partial))
+	assert.Equal(t, 1, spool.Len())
+
+	server := &fakeServer{}
+	require.NoError(t, spool.Send(metrics.NewReport(), server.send))
+	require.Len(t, server.delivered, 1)
+	assert.Equal(t, 1, server.delivered[0].Length(), "delivered metrics are not sent again")
+	assert.True(t, server.delivered[0].Has("PollCount"))
+	assert.Len(t, delivered, 1)
+}
+
+func TestSpool_Limits(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxSize     int64
+		maxAge      time.Duration
+		wantSpooled int
+	}{
+		{name: "unlimited", wantSpooled: 10},
+		// the first report was sent, so it is kept apart from merged ones
+		{name: "by size", maxSize: 480, wantSpooled: 2},
+		{name: "by age", maxAge: 150 * time.Second, wantSpooled: 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spool, err := NewSpool(t.TempDir(), tt.maxSize, tt.maxAge)
+			require.NoError(t, err)
+			clock := time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC)
+			spool.now = func() time.Time {
+				return clock
+			}
+
+			server := &fakeServer{down: true}
+			for i := 1; i <= 10; i++ {
+				require.NoError(t, spool.Send(testReport(i), server.send))
+				clock = clock.Add(time.Minute)
+			}
+			assert.Equal(t, tt.wantSpooled, spool.Len())
+			if tt.maxSize > 0 {
+				assert.LessOrEqual(t, spool.size(), tt.maxSize)
+			}
+
+			server.down = false
+			require.NoError(t, spool.Send(metrics.NewReport(), server.send))
+			counters, gauges := server.totals()
+			assert.Equal(t, int64(10), counters["PollCount"], "counter deltas are merged, not dropped")
+			assert.Equal(t, 10.0, gauges["Seq"], "the latest gauge is delivered last")
+		})
+	}
+}
+
+func TestSpool_KeepAttempted(t *testing.T) {
+	spool, err := NewSpool(t.TempDir(), 1, 0)
+	require.NoError(t, err)
+	server := &fakeServer{down: true}
+	sent := testReport(1)
+	sent.SetSeq(7)
+	require.NoError(t, spool.Send(sent, server.send))
+	for i := 2; i <= 4; i++ {
+		require.NoError(t, spool.Send(testReport(i), server.send))
+	}
+	assert.Equal(t, 2, spool.Len(), "reports which were not sent are merged")
+
+	server.down = false
+	require.NoError(t, spool.Send(metrics.NewReport(), server.send))
+	require.Len(t, server.delivered, 2)
+	assert.Equal(t, uint64(7), server.delivered[0].Seq(), "report which may have been applied keeps its key")
+	c, _ := server.delivered[0].Get("PollCount")
+	assert.Equal(t, int64(1), *c.Delta)
+}
+
+func TestSpool_Reopen(t *testing.T) {
+	dir := t.TempDir()
+	spool, err := NewSpool(dir, 0, 0)
+	require.NoError(t, err)
+	server := &fakeServer{down: true}
+	require.NoError(t, spool.Send(testReport(1), server.send))
+	require.NoError(t, spool.Send(testReport(2), server.send))
+	require.NoError(t, os.WriteFile(dir+"/unrelated.txt", []byte("x"), 0644))
+
+	reopened, err := NewSpool(dir, 0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 2, reopened.Len())
+
+	server.down = false
+	require.NoError(t, reopened.Send(testReport(3), server.send))
+	require.Len(t, server.delivered, 3)
+	m, _ := server.delivered[2].Get("Seq")
+	assert.Equal(t, 3.0, *m.Value)
+}
+
+func TestSpool_DropRejected(t *testing.T) {
+	spool, err := NewSpool(t.TempDir(), 0, 0)
+	require.NoError(t, err)
+	server := &fakeServer{down: true}
+	require.NoError(t, spool.Send(testReport(1), server.send))
+	require.Equal(t, 1, spool.Len())
+
+	// spooled report rejected by server must not block the newer ones
+	rejected := true
+	require.NoError(t, spool.Send(testReport(2), func(report metrics.Report) error {
+		if rejected {
+			rejected = false
+			return &StatusError{StatusCode: 400}
+		}
+		return (&fakeServer{}).send(report)
+	}))
+	assert.Equal(t, 0, spool.Len())
+}
+
+func TestSendReport_Unsent(t *testing.T) {
+	setTestRetryPolicy(t, RetryPolicy{MaxAttempts: 2})
+	endpoint := NewServerEndpoint("http", "127.0.0.1", 1)
+	err := SendReport(testReport(1), endpoint)
+
+	var ue *UnsentError
+	require.ErrorAs(t, err, &ue)
+	assert.Equal(t, 2, ue.Unsent.Length())
+}
+
+func TestSpool_KeepSeq(t *testing.T) {
+	dir := t.TempDir()
+	spool, err := NewSpool(dir, 0, 0)
+	require.NoError(t, err)
+	server := &fakeServer{down: true}
+	report := testReport(1)
+	report.SetSeq(7)
+	require.NoError(t, spool.Send(report, server.send))
+
+	reopened, err := NewSpool(dir, 0, 0)
+	require.NoError(t, err)
+	server.down = false
+	require.NoError(t, reopened.Send(testReport(2), server.send))
+	require.Len(t, server.delivered, 2)
+	assert.Equal(t, uint64(7), server.delivered[0].Seq())
+	assert.NotZero(t, server.delivered[1].Seq())
+}
@@ -0,0 +1,42 @@
+package signature
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// Header keeps hex encoded HMAC-SHA256 of request or response body
+const Header = "HashSHA256"
+
+// RequestHeader keeps hex encoded HMAC-SHA256 of RequestData, it binds request without body to its route and time
+const RequestHeader = "X-Request-Signature"
+
+// TimestampHeader keeps time the request was signed at in RFC 3339 format with nanoseconds,
+// so signatures of repeated requests differ
+const TimestampHeader = "X-Request-Timestamp"
+
+// RequestData returns signed data of request: its method, path and time it was signed at
+func RequestData(method, path, timestamp string) []byte {
+	return []byte(method + "\n" + path + "\n" + timestamp)
+}
+
+// Sign returns hex encoded HMAC-SHA256 of data
+func Sign(key, data []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks that hash is HMAC-SHA256 of data, comparison takes constant time
+func Verify(key, data []byte, hash string) bool {
+	decoded, err := hex.DecodeString(hash)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+
+	return hmac.Equal(decoded, mac.Sum(nil))
+}
@@ -0,0 +1,34 @@
+package signature
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSign(t *testing.T) {
+	// RFC 4231 test case 2
+	assert.Equal(t, "5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843",
+		Sign([]byte("Jefe"), []byte("what do ya want for nothing?")))
+}
+
+func TestVerify(t *testing.T) {
+	key, data := []byte("secret"), []byte(`{"id":"c","type":"counter","delta":1}`)
+	hash := Sign(key, data)
+
+	assert.True(t, Verify(key, data, hash))
+	assert.False(t, Verify([]byte("other"), data, hash))
+	assert.False(t, Verify(key, []byte(`{"id":"c","type":"counter","delta":2}`), hash))
+	assert.False(t, Verify(key, data, "not hex"))
+	assert.False(t, Verify(key, data, ""))
+}
+
+func TestRequestData(t *testing.T) {
+	key := []byte("secret")
+	hash := Sign(key, RequestData("POST", "/api/v1/admin/backups", "2024-10-01T12:00:00.000000001Z"))
+
+	assert.True(t, Verify(key, RequestData("POST", "/api/v1/admin/backups", "2024-10-01T12:00:00.000000001Z"), hash))
+	assert.False(t, Verify(key, RequestData("GET", "/api/v1/admin/backups", "2024-10-01T12:00:00.000000001Z"), hash))
+	assert.False(t, Verify(key, RequestData("POST", "/api/v1/admin/backups/x/restore", "2024-10-01T12:00:00.000000001Z"), hash))
+	assert.False(t, Verify(key, RequestData("POST", "/api/v1/admin/backups", "2024-10-01T12:00:00.000000002Z"), hash))
+}